@@ -1,7 +1,83 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/internal/notifications"
+	"github.com/aboglioli/big-brother/internal/oauth"
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/mailer"
+	"github.com/aboglioli/big-brother/pkg/server"
+)
 
 func main() {
-	fmt.Println("Let's GO")
+	c := config.Get()
+
+	// Infrastructure
+	usersRepo, err := users.NewRepository()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webAuthnRepo, err := users.NewWebAuthnRepository()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authCache, err := cache.New("auth")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Second factors are stored without expiration and must not be evicted
+	usersCache, err := cache.NewPersistent("users")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// OAuth clients are stored without expiration and must not be evicted
+	oauthCache, err := cache.NewPersistent("oauth")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	eventMgr, err := events.NewRabbitMQ()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mail, err := mailer.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authEnc, err := auth.NewEncoder()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Services
+	authServ := auth.NewService(auth.NewRepository(authCache), authEnc)
+	usersServ := users.NewService(usersRepo, webAuthnRepo, eventMgr, authServ, usersCache)
+	oauthServ := oauth.NewService(oauth.NewRepository(oauthCache), authServ, usersServ, authEnc, oauthCache)
+
+	// Notifications
+	if err := notifications.NewNotifier(eventMgr, mail).Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	// HTTP
+	r := server.New()
+	auth.NewHandler(authEnc).Routes(r)
+	users.NewHandler(usersServ, authServ).Routes(r)
+	oauth.NewHandler(oauthServ, authServ).Routes(r)
+
+	if err := r.Run(fmt.Sprintf(":%d", c.User.Port)); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Errors
+var (
+	ErrSignAction    = errors.Status.New("auth.service.sign_action").S(500)
+	ErrInvalidAction = errors.Status.New("auth.service.invalid_action").S(400)
+)
+
+// ActionClaims is the payload of a token that lets its holder perform one
+// action for a user, like verifying an email. They are sent out of band, so
+// they carry no jti and are never accepted as access tokens.
+type ActionClaims struct {
+	jwt.StandardClaims
+	Action string `json:"act"`
+	Email  string `json:"email,omitempty"`
+}
+
+// ActionRequest describes the action a token is for. Email binds the token
+// to an address, so it stops working if the address changes.
+type ActionRequest struct {
+	UserID string
+	Action string
+	Email  string
+	TTL    time.Duration
+}
+
+func (s *service) SignAction(req *ActionRequest) (string, error) {
+	if req.UserID == "" || req.Action == "" || req.TTL <= 0 {
+		return "", ErrSignAction
+	}
+
+	now := time.Now()
+	tokenStr, err := s.enc.Sign(&ActionClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   req.UserID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(req.TTL).Unix(),
+		},
+		Action: req.Action,
+		Email:  req.Email,
+	})
+	if err != nil {
+		return "", ErrSignAction.Wrap(err)
+	}
+
+	return tokenStr, nil
+}
+
+// VerifyAction rejects tokens for other actions, so a token cannot be
+// replayed against another endpoint.
+func (s *service) VerifyAction(tokenStr, action string) (*ActionClaims, error) {
+	claims := &ActionClaims{}
+	if err := s.enc.Parse(tokenStr, claims); err != nil {
+		return nil, ErrInvalidAction.Wrap(err)
+	}
+	if claims.Action == "" || claims.Action != action || claims.Subject == "" {
+		return nil, ErrInvalidAction.C("action", claims.Action)
+	}
+	return claims, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActions(t *testing.T) {
+	serv := &service{
+		repo:       NewRepository(cache.NewInMemory("auth")),
+		enc:        newTestEncoder(),
+		ttl:        time.Hour,
+		refreshTTL: 24 * time.Hour,
+	}
+
+	tokenStr, err := serv.SignAction(&ActionRequest{
+		UserID: "user123",
+		Action: "verify_email",
+		Email:  "user@user.com",
+		TTL:    time.Hour,
+	})
+	require.Nil(t, err)
+
+	t.Run("verify", func(t *testing.T) {
+		claims, err := serv.VerifyAction(tokenStr, "verify_email")
+		require.Nil(t, err)
+		assert.Equal(t, "user123", claims.Subject)
+		assert.Equal(t, "user@user.com", claims.Email)
+	})
+
+	t.Run("other action", func(t *testing.T) {
+		claims, err := serv.VerifyAction(tokenStr, "reset_password")
+		errors.Assert(t, ErrInvalidAction, err)
+		assert.Nil(t, claims)
+	})
+
+	t.Run("not an access token", func(t *testing.T) {
+		token, err := serv.Validate(tokenStr)
+		assert.NotNil(t, err)
+		assert.Nil(t, token)
+	})
+
+	t.Run("access tokens are not actions", func(t *testing.T) {
+		pair, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+		_, err = serv.VerifyAction(pair.AccessToken, "")
+		errors.Assert(t, ErrInvalidAction, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expiredStr, err := serv.enc.Sign(&ActionClaims{
+			StandardClaims: jwt.StandardClaims{
+				Subject:   "user123",
+				ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+			},
+			Action: "verify_email",
+		})
+		require.Nil(t, err)
+		_, err = serv.VerifyAction(expiredStr, "verify_email")
+		errors.Assert(t, ErrInvalidAction.Wrap(ErrTokenExpired), err)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		_, err := serv.SignAction(&ActionRequest{UserID: "user123", Action: "verify_email"})
+		errors.Assert(t, ErrSignAction, err)
+	})
+}
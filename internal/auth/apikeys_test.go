@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	perms := map[string][]models.Permission{"users": {models.READ}}
+
+	tests := []struct {
+		name string
+		req  *CreateAPIKeyRequest
+		err  error
+	}{{
+		"without user",
+		&CreateAPIKeyRequest{Name: "ci", Permissions: perms},
+		ErrAPIKeyCreate,
+	}, {
+		"without name",
+		&CreateAPIKeyRequest{UserID: "user123", Permissions: perms},
+		ErrAPIKeyInvalid.F("name", "required"),
+	}, {
+		"long name",
+		&CreateAPIKeyRequest{UserID: "user123", Name: strings.Repeat("a", 65), Permissions: perms},
+		ErrAPIKeyInvalid.F("name", "too_long"),
+	}, {
+		"without permissions",
+		&CreateAPIKeyRequest{UserID: "user123", Name: "ci"},
+		ErrAPIKeyInvalid.F("permissions", "required"),
+	}, {
+		"unknown permission",
+		&CreateAPIKeyRequest{UserID: "user123", Name: "ci", Permissions: map[string][]models.Permission{
+			"users": {"x"},
+		}},
+		ErrAPIKeyInvalid.F("permissions", "invalid"),
+	}, {
+		"negative expiration",
+		&CreateAPIKeyRequest{UserID: "user123", Name: "ci", Permissions: perms, ExpiresIn: -1},
+		ErrAPIKeyInvalid.F("expiresIn", "invalid"),
+	}, {
+		"valid",
+		&CreateAPIKeyRequest{UserID: "user123", Role: models.USER, Name: "ci", Permissions: perms, ExpiresIn: 3600},
+		nil,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := &service{repo: NewRepository(cache.NewInMemory("auth"))}
+
+			key, keyStr, err := serv.CreateAPIKey(test.req)
+
+			if test.err != nil {
+				errors.Assert(t, test.err, err)
+				assert.Nil(key)
+				assert.Empty(keyStr)
+				return
+			}
+
+			require.Nil(t, err)
+			assert.Regexp(`^bb_[0-9a-f]{32}_[A-Za-z0-9_-]{43}$`, keyStr)
+			assert.True(strings.HasPrefix(keyStr, key.Prefix+"_"))
+			assert.Equal("user123", key.UserID)
+			assert.Equal(models.USER, key.Role)
+			assert.Equal(perms, key.Permissions)
+			assert.Equal(key.CreatedAt+int64(time.Hour), key.ExpiresAt)
+			// Only the hash is stored
+			assert.NotContains(key.Hash, keyStr[len(key.Prefix)+1:])
+			assert.Equal(hashAPIKey(keyStr), key.Hash)
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	newServ := func() *service {
+		return &service{repo: NewRepository(cache.NewInMemory("auth"))}
+	}
+	create := func(t *testing.T, serv *service, userID string) (*models.APIKey, string) {
+		key, keyStr, err := serv.CreateAPIKey(&CreateAPIKeyRequest{
+			UserID:      userID,
+			Name:        "ci",
+			Permissions: map[string][]models.Permission{"users": {models.READ}},
+		})
+		require.Nil(t, err)
+		return key, keyStr
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+		created, keyStr := create(t, serv, "user123")
+
+		key, err := serv.ValidateAPIKey(keyStr)
+		require.Nil(t, err)
+		assert.Equal(created.ID, key.ID)
+		assert.True(key.HasPermission("users", models.READ))
+		assert.False(key.HasPermission("users", models.DELETE))
+		assert.NotZero(key.LastUsedAt)
+
+		stored, err := serv.repo.FindAPIKey(key.ID)
+		require.Nil(t, err)
+		assert.Equal(key.LastUsedAt, stored.LastUsedAt)
+	})
+
+	t.Run("validate keeps a concurrent revocation", func(t *testing.T) {
+		serv := newServ()
+		created, keyStr := create(t, serv, "user123")
+		stale, err := serv.repo.FindAPIKey(created.ID)
+		require.Nil(t, err)
+
+		require.Nil(t, serv.RevokeAPIKey("user123", created.ID))
+		serv.touchAPIKey(stale)
+
+		_, err = serv.ValidateAPIKey(keyStr)
+		errors.Assert(t, ErrValidate, err)
+		keys, err := serv.ListAPIKeys("user123")
+		require.Nil(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		serv := newServ()
+		created, keyStr := create(t, serv, "user123")
+
+		for _, invalid := range []string{
+			"",
+			"encoded.token",
+			"bb_",
+			created.Prefix,
+			created.Prefix + "_wrong",
+			"bb_unknown_" + keyStr[len(created.Prefix)+1:],
+			keyStr + "x",
+		} {
+			_, err := serv.ValidateAPIKey(invalid)
+			errors.Assert(t, ErrValidate, err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		serv := newServ()
+		key, keyStr := create(t, serv, "user123")
+		key.ExpiresAt = time.Now().Add(-time.Second).UnixNano()
+		// Stored without expiration, as if the cache had not dropped it yet
+		repo := serv.repo.(*repository)
+		require.Nil(t, repo.set(apiKeyKey(key.ID), key, 0, 0))
+
+		_, err := serv.ValidateAPIKey(keyStr)
+		errors.Assert(t, ErrExpired, err)
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+		first, _ := create(t, serv, "user123")
+		second, secondStr := create(t, serv, "user123")
+		create(t, serv, "other")
+
+		keys, err := serv.ListAPIKeys("user123")
+		require.Nil(t, err)
+		assert.Equal([]*models.APIKey{first, second}, keys)
+
+		err = serv.RevokeAPIKey("other", second.ID)
+		errors.Assert(t, ErrNoAPIKey, err)
+
+		require.Nil(t, serv.RevokeAPIKey("user123", second.ID))
+		_, err = serv.ValidateAPIKey(secondStr)
+		errors.Assert(t, ErrValidate, err)
+
+		err = serv.RevokeAPIKey("user123", second.ID)
+		errors.Assert(t, ErrNoAPIKey, err)
+
+		keys, err = serv.ListAPIKeys("user123")
+		require.Nil(t, err)
+		assert.Equal([]*models.APIKey{first}, keys)
+	})
+
+	t.Run("revoke all of a user", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+		_, firstStr := create(t, serv, "user123")
+		_, secondStr := create(t, serv, "user123")
+		other, otherStr := create(t, serv, "other")
+
+		require.Nil(t, serv.RevokeAPIKeys("user123"))
+
+		for _, keyStr := range []string{firstStr, secondStr} {
+			_, err := serv.ValidateAPIKey(keyStr)
+			errors.Assert(t, ErrValidate, err)
+		}
+		keys, err := serv.ListAPIKeys("user123")
+		require.Nil(t, err)
+		assert.Empty(keys)
+
+		key, err := serv.ValidateAPIKey(otherStr)
+		require.Nil(t, err)
+		assert.Equal(other.ID, key.ID)
+	})
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serv := &service{
+		repo:       NewRepository(cache.NewInMemory("auth")),
+		enc:        &encoder{secret: []byte("my_secret")},
+		ttl:        time.Hour,
+		refreshTTL: 24 * time.Hour,
+	}
+	_, keyStr, err := serv.CreateAPIKey(&CreateAPIKeyRequest{
+		UserID:      "user123",
+		Name:        "ci",
+		Permissions: map[string][]models.Permission{"users": {models.READ}},
+	})
+	require.Nil(t, err)
+	pair, err := serv.Create(&CreateRequest{UserID: "user123"})
+	require.Nil(t, err)
+
+	r := gin.New()
+	authorized := r.Group("/", Middleware(serv))
+	authorized.GET("/read", RequirePermission("users", models.READ), func(c *gin.Context) {
+		c.String(http.StatusOK, Token(c).UserID)
+	})
+	authorized.GET("/delete", RequirePermission("users", models.DELETE), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	authorized.GET("/login", RejectAPIKeys(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/read", keyStr, http.StatusOK},
+		{"/read", "bb_unknown_key", http.StatusUnauthorized},
+		{"/delete", keyStr, http.StatusForbidden},
+		{"/login", keyStr, http.StatusForbidden},
+		{"/read", pair.AccessToken, http.StatusOK},
+		{"/delete", pair.AccessToken, http.StatusOK},
+		{"/login", pair.AccessToken, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+
+		assert.Equal(t, test.status, res.Code, test.path)
+		if test.path == "/read" && res.Code == http.StatusOK {
+			assert.Equal(t, "user123", res.Body.String())
+		}
+	}
+}
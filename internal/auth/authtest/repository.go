@@ -0,0 +1,180 @@
+// Package authtest provides a conformance suite for auth.Repository
+// implementations.
+package authtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RepositoryContract runs the behaviour every auth.Repository must share.
+// newRepo is called once per subtest and must return an empty repository.
+func RepositoryContract(t *testing.T, newRepo func(t *testing.T) auth.Repository) {
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepo(t)
+
+		token, err := repo.FindByID(models.NewID())
+		assert.Nil(t, token)
+		errors.Assert(t, auth.ErrRepositoryNotFound, err)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		repo := newRepo(t)
+		token := models.NewToken(models.NewID())
+		token.Expires(time.Hour)
+		require.Nil(t, repo.Insert(token))
+
+		found, err := repo.FindByID(token.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, token, found)
+	})
+
+	t.Run("session round trip", func(t *testing.T) {
+		repo := newRepo(t)
+		session := models.NewSession(models.NewID())
+		session.Expires(time.Hour)
+		session.TokenIDs = append(session.TokenIDs, models.NewID(), models.NewID())
+
+		_, err := repo.FindSession(session.ID)
+		errors.Assert(t, auth.ErrRepositoryNotFound, err)
+
+		require.Nil(t, repo.InsertSession(session))
+		found, err := repo.FindSession(session.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, session, found)
+
+		// Replace
+		session.Revoked = true
+		require.Nil(t, repo.InsertSession(session))
+		found, err = repo.FindSession(session.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, session, found)
+	})
+
+	t.Run("session revocation", func(t *testing.T) {
+		repo := newRepo(t)
+		session := models.NewSession(models.NewID())
+		session.Expires(time.Hour)
+		require.Nil(t, repo.InsertSession(session))
+
+		revoked, err := repo.SessionRevoked(session.ID)
+		assert.Nil(t, err)
+		assert.False(t, revoked)
+
+		require.Nil(t, repo.RevokeSession(session))
+		revoked, err = repo.SessionRevoked(session.ID)
+		assert.Nil(t, err)
+		assert.True(t, revoked)
+
+		// A stale copy written back keeps the mark
+		require.Nil(t, repo.InsertSession(session))
+		found, err := repo.FindSession(session.ID)
+		assert.Nil(t, err)
+		assert.True(t, found.Revoked)
+	})
+
+	t.Run("sessions by user", func(t *testing.T) {
+		repo := newRepo(t)
+		userID := models.NewID()
+
+		sessions, err := repo.FindSessionsByUser(userID)
+		assert.Nil(t, err)
+		assert.Empty(t, sessions)
+
+		active := models.NewSession(userID)
+		active.Expires(time.Hour)
+		revoked := models.NewSession(userID)
+		revoked.Expires(time.Hour)
+		other := models.NewSession(models.NewID())
+		other.Expires(time.Hour)
+		for _, s := range []*models.Session{active, revoked, other} {
+			require.Nil(t, repo.InsertSession(s))
+		}
+		// Inserting again must not duplicate the index entry
+		require.Nil(t, repo.InsertSession(active))
+
+		revoked.Revoked = true
+		require.Nil(t, repo.InsertSession(revoked))
+
+		sessions, err = repo.FindSessionsByUser(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, []*models.Session{active}, sessions)
+	})
+
+	t.Run("api keys by user", func(t *testing.T) {
+		repo := newRepo(t)
+		userID := models.NewID()
+
+		keys, err := repo.FindAPIKeysByUser(userID)
+		assert.Nil(t, err)
+		assert.Empty(t, keys)
+
+		first := models.NewAPIKey(models.NewID(), userID)
+		second := models.NewAPIKey(models.NewID(), userID)
+		second.Expires(time.Hour)
+		other := models.NewAPIKey(models.NewID(), models.NewID())
+		for _, k := range []*models.APIKey{first, second, other} {
+			require.Nil(t, repo.InsertAPIKey(k))
+		}
+		// An ID taken already is refused, not replaced
+		taken := models.NewAPIKey(first.ID, models.NewID())
+		errors.Assert(t, auth.ErrRepositoryExists, repo.InsertAPIKey(taken))
+
+		found, err := repo.FindAPIKey(second.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, second, found)
+
+		keys, err = repo.FindAPIKeysByUser(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, []*models.APIKey{first, second}, keys)
+
+		require.Nil(t, repo.DeleteAPIKey(first))
+		_, err = repo.FindAPIKey(first.ID)
+		errors.Assert(t, auth.ErrRepositoryNotFound, err)
+
+		keys, err = repo.FindAPIKeysByUser(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, []*models.APIKey{second}, keys)
+	})
+
+	t.Run("api key last use", func(t *testing.T) {
+		repo := newRepo(t)
+		key := models.NewAPIKey(models.NewID(), models.NewID())
+		key.Expires(time.Hour)
+		require.Nil(t, repo.InsertAPIKey(key))
+
+		now := time.Now().UnixNano()
+		require.Nil(t, repo.TouchAPIKey(key, now))
+		found, err := repo.FindAPIKey(key.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, now, found.LastUsedAt)
+
+		// Touching a deleted key does not bring it back
+		require.Nil(t, repo.DeleteAPIKey(key))
+		require.Nil(t, repo.TouchAPIKey(key, now+1))
+		_, err = repo.FindAPIKey(key.ID)
+		errors.Assert(t, auth.ErrRepositoryNotFound, err)
+		keys, err := repo.FindAPIKeysByUser(key.UserID)
+		assert.Nil(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepo(t)
+		token := models.NewToken(models.NewID())
+		require.Nil(t, repo.Insert(token))
+
+		assert.Nil(t, repo.Delete(token.ID))
+		_, err := repo.FindByID(token.ID)
+		errors.Assert(t, auth.ErrRepositoryNotFound, err)
+
+		// Idempotent
+		assert.Nil(t, repo.Delete(token.ID))
+	})
+}
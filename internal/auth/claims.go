@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Claims is the payload of every token: sub is the user, or the client for
+// machine tokens, and jti the token in the repository. iss and aud are only
+// set when configured.
+type Claims struct {
+	jwt.StandardClaims
+	Role     models.Role `json:"role,omitempty"`
+	ClientID string      `json:"client_id,omitempty"`
+	Scope    string      `json:"scope,omitempty"`
+	Machine  bool        `json:"machine,omitempty"`
+}
+
+func NewClaims(token *models.Token, issuer, audience string) *Claims {
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:       token.ID,
+			Subject:  token.UserID,
+			Issuer:   issuer,
+			Audience: audience,
+			IssuedAt: time.Unix(0, token.CreatedAt).Unix(),
+		},
+		Role:     token.Role,
+		ClientID: token.ClientID,
+		Scope:    token.Scope,
+		Machine:  token.Machine,
+	}
+	if token.ExpiresAt != 0 {
+		claims.ExpiresAt = time.Unix(0, token.ExpiresAt).Unix()
+	}
+	return claims
+}
+
+// TokenID returns the jti claim.
+func (c *Claims) TokenID() string {
+	return c.Id
+}
+
+// UserID returns the sub claim.
+func (c *Claims) UserID() string {
+	return c.Subject
+}
@@ -0,0 +1,29 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/internal/auth/authtest"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRepositoryContract(t *testing.T) {
+	authtest.RepositoryContract(t, func(t *testing.T) auth.Repository {
+		return auth.NewRepository(cache.NewInMemory("auth"))
+	})
+}
+
+func TestRedisRepositoryContract(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	authtest.RepositoryContract(t, func(t *testing.T) auth.Repository {
+		c, err := cache.NewRedis("auth-test-" + models.NewID())
+		require.Nil(t, err)
+		return auth.NewRepository(c)
+	})
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+type SessionDTO struct {
+	ID        string `json:"id"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Current   bool   `json:"current"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// NewSessionDTO hides the token IDs of session. current is the token of the
+// request, used to flag the session it belongs to.
+func NewSessionDTO(session *models.Session, current *models.Token) *SessionDTO {
+	return &SessionDTO{
+		ID:        session.ID,
+		IP:        session.IP,
+		UserAgent: session.UserAgent,
+		Current:   current != nil && current.SessionID == session.ID,
+
+		CreatedAt:  time.Unix(0, session.CreatedAt).UTC(),
+		LastUsedAt: time.Unix(0, session.LastUsedAt).UTC(),
+		ExpiresAt:  time.Unix(0, session.ExpiresAt).UTC(),
+	}
+}
+
+type APIKeyDTO struct {
+	ID          string                         `json:"id"`
+	Name        string                         `json:"name"`
+	Prefix      string                         `json:"prefix"`
+	Permissions map[string][]models.Permission `json:"permissions"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+}
+
+// NewAPIKeyDTO hides the hash of key. LastUsedAt and ExpiresAt are null for
+// keys never used and keys that never expire.
+func NewAPIKeyDTO(key *models.APIKey) *APIKeyDTO {
+	return &APIKeyDTO{
+		ID:          key.ID,
+		Name:        key.Name,
+		Prefix:      key.Prefix,
+		Permissions: key.Permissions,
+
+		CreatedAt:  time.Unix(0, key.CreatedAt).UTC(),
+		LastUsedAt: optionalTime(key.LastUsedAt),
+		ExpiresAt:  optionalTime(key.ExpiresAt),
+	}
+}
+
+func optionalTime(unixNano int64) *time.Time {
+	if unixNano == 0 {
+		return nil
+	}
+	t := time.Unix(0, unixNano).UTC()
+	return &t
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"github.com/aboglioli/big-brother/pkg/config"
 	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
 	"github.com/dgrijalva/jwt-go"
 )
 
@@ -10,59 +11,199 @@ var (
 	ErrTokenEncode        = errors.Internal.New("auth.token.encode")
 	ErrTokenSigningMethod = errors.Internal.New("auth.token.signing_method")
 	ErrTokenDecode        = errors.Internal.New("auth.token.decode")
+	ErrTokenExpired       = errors.Internal.New("auth.token.expired")
+	ErrTokenUnknownKey    = errors.Internal.New("auth.token.unknown_key")
+	ErrTokenClaims        = errors.Internal.New("auth.token.claims")
 )
 
 // Interface
 type Encoder interface {
-	Encode(tokenID string) (string, error)
-	Decode(tokenStr string) (string, error)
+	Encode(token *models.Token) (string, error)
+	Decode(tokenStr string) (*Claims, error)
+	// Sign encodes claims other than a token's, such as an ID token, with
+	// the same key.
+	Sign(claims jwt.Claims) (string, error)
+	// Parse verifies a token made by Sign and decodes it into claims.
+	Parse(tokenStr string, claims jwt.Claims) error
+	// Alg returns the algorithm tokens are signed with.
+	Alg() string
+	// JWKS returns the public keys that verify the encoded tokens.
+	JWKS() *JWKS
+}
+
+// NewEncoder signs with the private key in config.JWTPrivateKeyFile or, if
+// there is none, with the shared config.JWTSecret. The keys in
+// config.JWTVerificationKeyFiles only verify tokens.
+func NewEncoder() (Encoder, error) {
+	c := config.Get()
+	if c.JWTPrivateKeyFile == "" {
+		return &encoder{
+			secret:   c.JWTSecret,
+			issuer:   c.JWTIssuer,
+			audience: c.JWTAudience,
+		}, nil
+	}
+
+	active, err := LoadKey(c.JWTPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	verify := make([]*Key, 0, len(c.JWTVerificationKeyFiles))
+	for _, path := range c.JWTVerificationKeyFiles {
+		key, err := LoadKey(path)
+		if err != nil {
+			return nil, err
+		}
+		verify = append(verify, key)
+	}
+
+	return NewKeyEncoder(NewKeyring(active, verify...)), nil
 }
 
 // Implementation
+// encoder signs with HS256. Verifying its tokens requires the secret, so its
+// JWKS is empty.
 type encoder struct {
-	secret []byte
+	secret   []byte
+	issuer   string
+	audience string
+}
+
+func (e *encoder) Encode(token *models.Token) (string, error) {
+	claims := NewClaims(token, e.issuer, e.audience)
+	return encode(jwt.NewWithClaims(jwt.SigningMethodHS256, claims), e.secret)
+}
+
+func (e *encoder) Decode(tokenStr string) (*Claims, error) {
+	return decode(tokenStr, e.issuer, e.audience, e.key)
 }
 
-func NewEncoder() Encoder {
+func (e *encoder) Sign(claims jwt.Claims) (string, error) {
+	return encode(jwt.NewWithClaims(jwt.SigningMethodHS256, claims), e.secret)
+}
+
+func (e *encoder) Parse(tokenStr string, claims jwt.Claims) error {
+	return parse(tokenStr, claims, e.key)
+}
+
+func (e *encoder) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, ErrTokenSigningMethod
+	}
+	return e.secret, nil
+}
+
+func (e *encoder) Alg() string {
+	return jwt.SigningMethodHS256.Alg()
+}
+
+func (e *encoder) JWKS() *JWKS {
+	return &JWKS{Keys: make([]*JWK, 0)}
+}
+
+// keyEncoder signs with the active key of a keyring.
+type keyEncoder struct {
+	ring     *Keyring
+	issuer   string
+	audience string
+}
+
+func NewKeyEncoder(ring *Keyring) Encoder {
 	c := config.Get()
-	return &encoder{
-		secret: c.JWTSecret,
+	return &keyEncoder{
+		ring:     ring,
+		issuer:   c.JWTIssuer,
+		audience: c.JWTAudience,
 	}
 }
 
-func (e *encoder) Encode(tokenID string) (string, error) {
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id": tokenID,
-	})
+func (e *keyEncoder) Encode(token *models.Token) (string, error) {
+	return e.Sign(NewClaims(token, e.issuer, e.audience))
+}
 
-	tokenStr, err := jwtToken.SignedString(e.secret)
-	if err != nil {
-		return "", ErrTokenEncode.Wrap(err)
+func (e *keyEncoder) Sign(claims jwt.Claims) (string, error) {
+	key := e.ring.Active()
+	jwtToken := jwt.NewWithClaims(key.Method, claims)
+	jwtToken.Header["kid"] = key.ID
+	return encode(jwtToken, key.private)
+}
+
+func (e *keyEncoder) Alg() string {
+	return e.ring.Active().Method.Alg()
+}
+
+func (e *keyEncoder) Decode(tokenStr string) (*Claims, error) {
+	return decode(tokenStr, e.issuer, e.audience, e.key)
+}
+
+func (e *keyEncoder) Parse(tokenStr string, claims jwt.Claims) error {
+	return parse(tokenStr, claims, e.key)
+}
+
+// key picks the key by the kid header and only accepts its algorithm, so a
+// token cannot choose another one (e.g. HS256 keyed with the public key).
+func (e *keyEncoder) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := e.ring.Find(kid)
+	if !ok {
+		return nil, ErrTokenUnknownKey.C("kid", kid)
 	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrTokenSigningMethod
+	}
+	return key.Public(), nil
+}
 
-	return tokenStr, nil
+func (e *keyEncoder) JWKS() *JWKS {
+	keys := e.ring.Keys()
+	jwks := &JWKS{Keys: make([]*JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
 }
 
-func (e *encoder) Decode(tokenStr string) (string, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrTokenSigningMethod
-		}
-		return e.secret, nil
-	})
+func encode(jwtToken *jwt.Token, key interface{}) (string, error) {
+	tokenStr, err := jwtToken.SignedString(key)
 	if err != nil {
-		return "", ErrTokenDecode.Wrap(err)
+		return "", ErrTokenEncode.Wrap(err)
 	}
+	return tokenStr, nil
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", ErrTokenDecode
+// decode verifies tokenStr and its claims. An empty issuer or audience is
+// not checked.
+func decode(tokenStr, issuer, audience string, keyFunc jwt.Keyfunc) (*Claims, error) {
+	claims := &Claims{}
+	if err := parse(tokenStr, claims, keyFunc); err != nil {
+		return nil, err
 	}
 
-	id, ok := claims["id"].(string)
-	if !ok {
-		return "", ErrTokenDecode
+	if claims.Id == "" || claims.Subject == "" {
+		return nil, ErrTokenClaims.M("missing jti or sub")
+	}
+	if issuer != "" && !claims.VerifyIssuer(issuer, true) {
+		return nil, ErrTokenClaims.C("iss", claims.Issuer)
 	}
+	if audience != "" && !claims.VerifyAudience(audience, true) {
+		return nil, ErrTokenClaims.C("aud", claims.Audience)
+	}
+
+	return claims, nil
+}
 
-	return id, nil
+// parse verifies the signature and expiration of tokenStr.
+func parse(tokenStr string, claims jwt.Claims, keyFunc jwt.Keyfunc) error {
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc)
+	if err != nil {
+		if vErr, ok := err.(*jwt.ValidationError); ok && vErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return ErrTokenExpired.Wrap(err)
+		}
+		return ErrTokenDecode.Wrap(err)
+	}
+	if !token.Valid {
+		return ErrTokenDecode
+	}
+	return nil
 }
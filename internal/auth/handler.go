@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	enc Encoder
+}
+
+func NewHandler(enc Encoder) *Handler {
+	return &Handler{
+		enc: enc,
+	}
+}
+
+// Routes registers the public auth endpoints in r.
+func (h *Handler) Routes(r gin.IRouter) {
+	r.GET("/.well-known/jwks.json", h.jwks)
+}
+
+func (h *Handler) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.enc.JWKS())
+}
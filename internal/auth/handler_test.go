@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := NewKey(edKey)
+	require.Nil(t, err)
+
+	r := gin.New()
+	NewHandler(NewKeyEncoder(NewKeyring(key))).Routes(r)
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	assert.Equal(http.StatusOK, res.Code)
+	var jwks JWKS
+	require.Nil(t, json.NewDecoder(res.Body).Decode(&jwks))
+	assert.Equal([]*JWK{key.JWK()}, jwks.Keys)
+	assert.NotContains(res.Body.String(), `"d"`)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Keyring holds the key that signs new tokens and the keys that still verify
+// older ones. Every key is found by its ID, sent in the kid header.
+//
+// The keyring lives in memory and is built from the config on start, so keys
+// are rotated across instances by restarting them: first add the new key to
+// the verification keys everywhere, then make it the signing key, keeping the
+// old one as a verification key until the tokens it signed have expired.
+type Keyring struct {
+	mux     sync.RWMutex
+	active  *Key
+	verify  map[string]*Key
+	retired map[string]time.Time
+
+	now func() time.Time
+}
+
+// NewKeyring signs with active. verify are verification-only keys, kept until
+// they are rotated in; publishing a key before using it gives other services
+// time to fetch it.
+func NewKeyring(active *Key, verify ...*Key) *Keyring {
+	ring := &Keyring{
+		active:  active,
+		verify:  make(map[string]*Key),
+		retired: make(map[string]time.Time),
+		now:     time.Now,
+	}
+	for _, key := range verify {
+		if key.ID != active.ID {
+			ring.verify[key.ID] = key
+		}
+	}
+	return ring
+}
+
+// Active returns the signing key.
+func (r *Keyring) Active() *Key {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.active
+}
+
+// Find returns the key with id if it can still verify tokens.
+func (r *Keyring) Find(id string) (*Key, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if r.active.ID == id {
+		return r.active, true
+	}
+	key, ok := r.verify[id]
+	if !ok || r.expired(id) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Keys returns every key that can still verify tokens, the active one first.
+func (r *Keyring) Keys() []*Key {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	ids := make([]string, 0, len(r.verify))
+	for id := range r.verify {
+		if !r.expired(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	keys := []*Key{r.active}
+	for _, id := range ids {
+		keys = append(keys, r.verify[id])
+	}
+	return keys
+}
+
+// Rotate makes next the signing key. The previous one keeps verifying tokens
+// for grace, which should be at least the lifetime of the longest token it
+// signed. Keys whose grace period is over are dropped.
+func (r *Keyring) Rotate(next *Key, grace time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if next.ID == r.active.ID {
+		return
+	}
+
+	now := r.now()
+	for id, until := range r.retired {
+		if !now.Before(until) {
+			delete(r.verify, id)
+			delete(r.retired, id)
+		}
+	}
+
+	delete(r.verify, next.ID)
+	delete(r.retired, next.ID)
+
+	r.verify[r.active.ID] = r.active
+	r.retired[r.active.ID] = now.Add(grace)
+	r.active = next
+}
+
+func (r *Keyring) expired(id string) bool {
+	until, ok := r.retired[id]
+	return ok && !r.now().Before(until)
+}
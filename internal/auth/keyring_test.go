@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T) *Key {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := NewKey(private)
+	require.Nil(t, err)
+	return key
+}
+
+func TestKeyringRotate(t *testing.T) {
+	assert := assert.New(t)
+
+	first, second, third := newTestKey(t), newTestKey(t), newTestKey(t)
+	now := time.Now()
+	ring := NewKeyring(first)
+	ring.now = func() time.Time { return now }
+	enc := NewKeyEncoder(ring)
+
+	token := models.NewToken("user123")
+	token.Expires(24 * time.Hour)
+	oldTokenStr, err := enc.Encode(token)
+	require.Nil(t, err)
+
+	ring.Rotate(second, time.Hour)
+	assert.Equal(second, ring.Active())
+	assert.Equal([]*Key{second, first}, ring.Keys())
+
+	newTokenStr, err := enc.Encode(token)
+	require.Nil(t, err)
+	assert.NotEqual(oldTokenStr, newTokenStr)
+
+	// Within the grace period
+	now = now.Add(59 * time.Minute)
+	claims, err := enc.Decode(oldTokenStr)
+	assert.Nil(err)
+	assert.Equal(token.ID, claims.TokenID())
+
+	// After the grace period
+	now = now.Add(time.Minute)
+	claims, err = enc.Decode(oldTokenStr)
+	errors.Assert(t, ErrTokenDecode, err)
+	assert.Nil(claims)
+	assert.Equal([]*Key{second}, ring.Keys())
+	assert.Len(enc.JWKS().Keys, 1)
+
+	claims, err = enc.Decode(newTokenStr)
+	assert.Nil(err)
+	assert.Equal(token.ID, claims.TokenID())
+
+	// Retired keys are dropped on the next rotation
+	ring.Rotate(third, time.Hour)
+	_, ok := ring.Find(first.ID)
+	assert.False(ok)
+	_, ok = ring.Find(second.ID)
+	assert.True(ok)
+}
+
+func TestKeyringVerificationOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	active, next := newTestKey(t), newTestKey(t)
+	ring := NewKeyring(active, next, active)
+	enc := NewKeyEncoder(ring)
+
+	// Published before it signs anything
+	assert.Equal([]*Key{active, next}, ring.Keys())
+	assert.Len(enc.JWKS().Keys, 2)
+
+	// Tokens signed elsewhere with next are accepted
+	token := models.NewToken("user123")
+	tokenStr, err := NewKeyEncoder(NewKeyring(next)).Encode(token)
+	require.Nil(t, err)
+	claims, err := enc.Decode(tokenStr)
+	assert.Nil(err)
+	assert.Equal(token.ID, claims.TokenID())
+
+	// Rotating it in keeps the previous key without duplicating next
+	ring.Rotate(next, time.Hour)
+	assert.Equal([]*Key{next, active}, ring.Keys())
+
+	// Unknown key
+	tokenStr, err = NewKeyEncoder(NewKeyring(newTestKey(t))).Encode(token)
+	require.Nil(t, err)
+	_, err = enc.Decode(tokenStr)
+	errors.Assert(t, ErrTokenDecode, err)
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Errors
+var (
+	ErrKeyLoad        = errors.Internal.New("auth.key.load")
+	ErrKeyParse       = errors.Internal.New("auth.key.parse")
+	ErrKeyUnsupported = errors.Internal.New("auth.key.unsupported")
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519 (RFC 8037), which jwt-go
+// does not implement.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Key is an asymmetric signing key. ID is the RFC 7638 thumbprint of its
+// public part and is sent as the kid header.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	private crypto.Signer
+}
+
+// LoadKey reads a PEM encoded private key from path.
+func LoadKey(path string) (*Key, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, ErrKeyLoad.C("path", path).Wrap(err)
+	}
+	return ParseKey(b)
+}
+
+// ParseKey parses a PKCS#1 RSA, SEC 1 EC or PKCS#8 private key. The signing
+// method follows from the key type: RS256 for RSA, ES256/ES384/ES512 for the
+// P-256/P-384/P-521 curves and EdDSA for Ed25519.
+func ParseKey(pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, ErrKeyParse.M("no PEM block found")
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, ErrKeyUnsupported.C("type", block.Type)
+	}
+	if err != nil {
+		return nil, ErrKeyParse.Wrap(err)
+	}
+
+	return NewKey(private)
+}
+
+// NewKey wraps an *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey.
+func NewKey(private interface{}) (*Key, error) {
+	key := &Key{}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.private = private
+	case *ecdsa.PrivateKey:
+		switch private.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, ErrKeyUnsupported.C("curve", private.Curve.Params().Name)
+		}
+		key.private = private
+	case ed25519.PrivateKey:
+		key.Method = SigningMethodEdDSA
+		key.private = private
+	default:
+		return nil, ErrKeyUnsupported
+	}
+
+	id, err := thumbprint(key.JWK())
+	if err != nil {
+		return nil, ErrKeyParse.Wrap(err)
+	}
+	key.ID = id
+
+	return key, nil
+}
+
+// Public returns the verification key in the form jwt-go expects.
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JWK returns the public part of the key as a JSON Web Key.
+func (k *Key) JWK() *JWK {
+	jwk := &JWK{
+		Kid: k.ID,
+		Use: "sig",
+		Alg: k.Method.Alg(),
+	}
+
+	switch public := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBytes(public.N.Bytes())
+		jwk.E = encodeBytes(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = encodeBytes(padBytes(public.X.Bytes(), size))
+		jwk.Y = encodeBytes(padBytes(public.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeBytes(public)
+	}
+
+	return jwk
+}
+
+// JWK is a public JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the document other services fetch to verify tokens offline.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// thumbprint hashes the required members of jwk in lexicographic order, as
+// RFC 7638 specifies. encoding/json sorts map keys.
+func thumbprint(jwk *JWK) (string, error) {
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	default:
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return encodeBytes(sum[:]), nil
+}
+
+func encodeBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	rsaPKCS8, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+		alg   string
+		kty   string
+		err   error
+	}{{
+		"PKCS#1 RSA",
+		&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"RS256", "RSA",
+		nil,
+	}, {
+		"PKCS#8 RSA",
+		&pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8},
+		"RS256", "RSA",
+		nil,
+	}, {
+		"SEC 1 EC",
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER},
+		"ES384", "EC",
+		nil,
+	}, {
+		"PKCS#8 Ed25519",
+		&pem.Block{Type: "PRIVATE KEY", Bytes: edDER},
+		"EdDSA", "OKP",
+		nil,
+	}, {
+		"public key",
+		&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("key")},
+		"", "",
+		ErrKeyUnsupported,
+	}, {
+		"corrupted",
+		&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")},
+		"", "",
+		ErrKeyParse,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			key, err := ParseKey(pem.EncodeToMemory(test.block))
+
+			if test.err != nil {
+				if assert.NotNil(err) {
+					assert.True(err.(errors.Error).Equals(test.err.(errors.Error)))
+				}
+				assert.Nil(key)
+			} else {
+				require.Nil(t, err)
+				assert.Equal(test.alg, key.Method.Alg())
+				jwk := key.JWK()
+				assert.Equal(test.kty, jwk.Kty)
+				assert.Equal(key.ID, jwk.Kid)
+				assert.NotEmpty(key.ID)
+			}
+		})
+	}
+}
+
+func TestParseKeyNoPEM(t *testing.T) {
+	key, err := ParseKey([]byte("not a key"))
+	assert.Nil(t, key)
+	errors.Assert(t, ErrKeyParse, err)
+}
+
+// RFC 7638 section 3.1
+func TestThumbprint(t *testing.T) {
+	jwk := &JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+
+	id, err := thumbprint(jwk)
+	assert.Nil(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", id)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/server"
+	"github.com/gin-gonic/gin"
+)
+
+// Errors
+var (
+	ErrUnauthorized = errors.Status.New("auth.unauthorized").S(401)
+	ErrForbidden    = errors.Status.New("auth.forbidden").S(403)
+)
+
+const (
+	tokenKey         = "auth.token"
+	tokenStrKey      = "auth.token_str"
+	apiKeyContextKey = "auth.api_key"
+)
+
+// Middleware rejects requests without a valid bearer token and stores the
+// token in the request context. API keys are accepted too: they are stored
+// with a token of kind API_KEY standing for them. Machine tokens are not:
+// they stand for a client, not a user, and are meant for other services,
+// which check them with the JWKS or introspection.
+func Middleware(serv Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr := BearerToken(c)
+		if tokenStr == "" {
+			server.Error(c, ErrUnauthorized)
+			return
+		}
+
+		if IsAPIKey(tokenStr) {
+			key, err := serv.ValidateAPIKey(tokenStr)
+			if err != nil {
+				server.Error(c, err)
+				return
+			}
+			c.Set(apiKeyContextKey, key)
+			c.Set(tokenKey, apiKeyToken(key))
+			c.Set(tokenStrKey, tokenStr)
+			c.Next()
+			return
+		}
+
+		token, err := serv.Validate(tokenStr)
+		if err != nil {
+			server.Error(c, err)
+			return
+		}
+		if token.Machine {
+			server.Error(c, ErrForbidden.M("machine tokens are not allowed"))
+			return
+		}
+
+		c.Set(tokenKey, token)
+		c.Set(tokenStrKey, tokenStr)
+		c.Next()
+	}
+}
+
+// RequireRole only lets through requests whose token, validated by
+// Middleware, has one of roles.
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := Token(c)
+		if token != nil {
+			for _, role := range roles {
+				if token.Role == role {
+					c.Next()
+					return
+				}
+			}
+		}
+		server.Error(c, ErrForbidden)
+	}
+}
+
+// RequirePermission only lets through API keys granting perm on module and
+// OAuth client tokens granted its scope, PermissionScope. Tokens from a login
+// act with every permission of their user.
+func RequirePermission(module string, perm models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := APIKey(c); key != nil && !key.HasPermission(module, perm) {
+			server.Error(c, ErrForbidden.C("module", module).C("permission", string(perm)))
+			return
+		}
+		if token := Token(c); token != nil && token.ClientID != "" && !HasScope(token.Scope, PermissionScope(module, perm)) {
+			server.Error(c, ErrForbidden.C("module", module).C("permission", string(perm)))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireFirstParty is for endpoints only the user may use, with a token from
+// a login with this service: OAuth clients act for a user only within the
+// scopes granted to them, and machine tokens stand for no user at all.
+func RequireFirstParty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := Token(c); token != nil && token.ClientID != "" {
+			server.Error(c, ErrForbidden.M("OAuth client tokens are not allowed"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectAPIKeys is for endpoints that manage credentials, which an API key
+// must not be able to use to escalate.
+func RejectAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if APIKey(c) != nil {
+			server.Error(c, ErrForbidden.M("API keys are not allowed"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// permissionScopes names the scope of each permission in PermissionScope.
+var permissionScopes = map[models.Permission]string{
+	models.CREATE: "create",
+	models.READ:   "read",
+	models.UPDATE: "update",
+	models.DELETE: "delete",
+}
+
+// PermissionScope returns the scope granting perm on module to OAuth
+// clients, such as users:read.
+func PermissionScope(module string, perm models.Permission) string {
+	return module + ":" + permissionScopes[perm]
+}
+
+// HasScope reports whether the space separated scope includes s.
+func HasScope(scope, s string) bool {
+	for _, f := range strings.Fields(scope) {
+		if f == s {
+			return true
+		}
+	}
+	return false
+}
+
+// BearerToken extracts the token from the Authorization header.
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// Token returns the token validated by Middleware.
+func Token(c *gin.Context) *models.Token {
+	if v, ok := c.Get(tokenKey); ok {
+		if token, ok := v.(*models.Token); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// APIKey returns the API key validated by Middleware, if the request used
+// one.
+func APIKey(c *gin.Context) *models.APIKey {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		if key, ok := v.(*models.APIKey); ok {
+			return key
+		}
+	}
+	return nil
+}
+
+func apiKeyToken(key *models.APIKey) *models.Token {
+	return &models.Token{
+		ID:        key.ID,
+		UserID:    key.UserID,
+		Role:      key.Role,
+		Kind:      models.API_KEY,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+	}
+}
+
+// TokenStr returns the raw token validated by Middleware.
+func TokenStr(c *gin.Context) string {
+	return c.GetString(tokenStrKey)
+}
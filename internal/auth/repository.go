@@ -2,6 +2,7 @@ package auth
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/aboglioli/big-brother/pkg/cache"
 	"github.com/aboglioli/big-brother/pkg/errors"
@@ -13,13 +14,47 @@ var (
 	ErrRepositoryNotFound = errors.Internal.New("auth.repository.not_found")
 	ErrRepositoryInsert   = errors.Internal.New("auth.repository.insert")
 	ErrRepositoryDelete   = errors.Internal.New("auth.repository.delete")
+	ErrRepositoryExists   = errors.Internal.New("auth.repository.exists")
 )
 
 // Interfaces
 type Repository interface {
 	FindByID(tokenID string) (*models.Token, error)
+	// Insert stores the token, replacing any previous version of it.
 	Insert(token *models.Token) error
 	Delete(tokenID string) error
+	// MarkUsed records that the token was used, reporting false if it already
+	// was. Of concurrent calls for the same token only one gets true.
+	MarkUsed(token *models.Token) (bool, error)
+
+	FindSession(sessionID string) (*models.Session, error)
+	// FindSessionsByUser returns the active sessions of a user.
+	FindSessionsByUser(userID string) ([]*models.Session, error)
+	// InsertSession stores the session, replacing any previous version of it,
+	// and indexes it by user.
+	InsertSession(session *models.Session) error
+	// TouchSession sets when the session was last used, apart from the rest
+	// of it, so that it never overwrites a concurrent InsertSession.
+	TouchSession(session *models.Session, lastUsedAt int64) error
+	// RevokeSession marks the session revoked apart from the rest of it, like
+	// TouchSession, so that a concurrent InsertSession cannot undo it.
+	// FindSession reports the mark.
+	RevokeSession(session *models.Session) error
+	// SessionRevoked reports whether RevokeSession was called for the
+	// session. It is an error, not false, when that cannot be known.
+	SessionRevoked(sessionID string) (bool, error)
+
+	FindAPIKey(keyID string) (*models.APIKey, error)
+	// FindAPIKeysByUser returns the unexpired keys of a user.
+	FindAPIKeysByUser(userID string) ([]*models.APIKey, error)
+	// InsertAPIKey stores a new key and indexes it by user. It fails with
+	// ErrRepositoryExists, and leaves the stored key alone, if the ID is
+	// taken.
+	InsertAPIKey(key *models.APIKey) error
+	// TouchAPIKey sets when the key was last used, apart from the rest of it
+	// like TouchSession, so that it never brings back a deleted key.
+	TouchAPIKey(key *models.APIKey, lastUsedAt int64) error
+	DeleteAPIKey(key *models.APIKey) error
 }
 
 // Implementations
@@ -34,40 +69,331 @@ func NewRepository(cache cache.Cache) Repository {
 }
 
 func (r *repository) FindByID(tokenID string) (*models.Token, error) {
-	v, err := r.cache.Get(tokenID)
-	if v == nil || err != nil {
-		return nil, ErrRepositoryNotFound.Wrap(err)
+	token := &models.Token{}
+	if err := r.get(tokenID, token); err != nil {
+		return nil, err
 	}
+	return token, nil
+}
 
-	b, ok := v.([]byte)
-	if !ok {
-		return nil, ErrRepositoryNotFound.M("wrong conversion")
+func (r *repository) Insert(token *models.Token) error {
+	return r.set(token.ID, token, token.ExpiresAt, token.TTL())
+}
+
+func (r *repository) Delete(tokenID string) error {
+	err := r.cache.Delete(tokenID)
+	if err != nil {
+		return ErrRepositoryDelete.Wrap(err)
 	}
+	return nil
+}
 
-	token := &models.Token{}
-	if err := json.Unmarshal(b, token); err != nil {
-		return nil, ErrRepositoryNotFound.Wrap(err)
+func (r *repository) MarkUsed(token *models.Token) (bool, error) {
+	ttl := token.TTL()
+	if token.ExpiresAt == 0 {
+		ttl = cache.NoExpiration
+	} else if ttl <= 0 {
+		return false, ErrRepositoryInsert.M("%s already expired", token.ID)
 	}
 
-	return token, nil
+	ok, err := r.cache.Add(usedKey(token.ID), []byte("1"), ttl)
+	if err != nil {
+		return false, ErrRepositoryInsert.Wrap(err)
+	}
+	return ok, nil
 }
 
-func (r *repository) Insert(token *models.Token) error {
-	b, err := json.Marshal(token)
+func (r *repository) FindSession(sessionID string) (*models.Session, error) {
+	session := &models.Session{}
+	if err := r.get(sessionKey(sessionID), session); err != nil {
+		return nil, err
+	}
+
+	var lastUsedAt int64
+	if err := r.get(sessionLastUsedKey(sessionID), &lastUsedAt); err == nil && lastUsedAt > session.LastUsedAt {
+		session.LastUsedAt = lastUsedAt
+	}
+
+	revoked, err := r.SessionRevoked(sessionID)
 	if err != nil {
-		return ErrRepositoryInsert.Wrap(err)
+		return nil, err
+	}
+	if revoked {
+		session.Revoked = true
 	}
 
-	if err := r.cache.Set(token.ID, b, cache.NoExpiration); err != nil {
+	return session, nil
+}
+
+// FindSessionsByUser skips revoked and expired sessions and drops them from
+// the index.
+func (r *repository) FindSessionsByUser(userID string) ([]*models.Session, error) {
+	ids, err := r.index(userSessionsKey(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*models.Session, 0, len(ids))
+	active := make([]string, 0, len(ids))
+	for _, id := range ids {
+		session, err := r.FindSession(id)
+		if err != nil || session.Revoked || session.Expired() {
+			continue
+		}
+		sessions = append(sessions, session)
+		active = append(active, id)
+	}
+
+	if len(active) != len(ids) {
+		if err := r.set(userSessionsKey(userID), active, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return sessions, nil
+}
+
+// InsertSession updates the user index with a read-modify-write, so two
+// sessions created concurrently for the same user could lose an entry.
+func (r *repository) InsertSession(session *models.Session) error {
+	if err := r.set(sessionKey(session.ID), session, session.ExpiresAt, session.TTL()); err != nil {
+		return err
+	}
+
+	if session.Revoked {
+		return nil
+	}
+
+	ids, err := r.index(userSessionsKey(session.UserID))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == session.ID {
+			return nil
+		}
+	}
+
+	return r.set(userSessionsKey(session.UserID), append(ids, session.ID), 0, 0)
+}
+
+func (r *repository) TouchSession(session *models.Session, lastUsedAt int64) error {
+	return r.set(sessionLastUsedKey(session.ID), lastUsedAt, session.ExpiresAt, session.TTL())
+}
+
+// RevokeSession keeps the mark until the session expires. An expired
+// session is gone already and needs none.
+func (r *repository) RevokeSession(session *models.Session) error {
+	ttl := session.TTL()
+	if session.ExpiresAt == 0 {
+		ttl = cache.NoExpiration
+	} else if ttl <= 0 {
+		return nil
+	}
+
+	if err := r.cache.Set(sessionRevokedKey(session.ID), []byte("1"), ttl); err != nil {
 		return ErrRepositoryInsert.Wrap(err)
 	}
 	return nil
 }
 
-func (r *repository) Delete(tokenID string) error {
-	err := r.cache.Delete(tokenID)
+func (r *repository) SessionRevoked(sessionID string) (bool, error) {
+	_, err := r.cache.Get(sessionRevokedKey(sessionID))
+	if err == nil {
+		return true, nil
+	}
+	if cErr, ok := err.(errors.Error); ok && cErr.Equals(cache.ErrCacheNotFound) {
+		return false, nil
+	}
+	return false, ErrRepositoryNotFound.Wrap(err)
+}
+
+func (r *repository) FindAPIKey(keyID string) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	if err := r.get(apiKeyKey(keyID), key); err != nil {
+		return nil, err
+	}
+
+	var lastUsedAt int64
+	if err := r.get(apiKeyLastUsedKey(keyID), &lastUsedAt); err == nil && lastUsedAt > key.LastUsedAt {
+		key.LastUsedAt = lastUsedAt
+	}
+
+	return key, nil
+}
+
+// FindAPIKeysByUser drops expired keys from the index.
+func (r *repository) FindAPIKeysByUser(userID string) ([]*models.APIKey, error) {
+	ids, err := r.index(userAPIKeysKey(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*models.APIKey, 0, len(ids))
+	active := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key, err := r.FindAPIKey(id)
+		if err != nil || key.Expired() {
+			continue
+		}
+		keys = append(keys, key)
+		active = append(active, id)
+	}
+
+	if len(active) != len(ids) {
+		if err := r.set(userAPIKeysKey(userID), active, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// InsertAPIKey updates the user index like InsertSession.
+func (r *repository) InsertAPIKey(key *models.APIKey) error {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+
+	ttl := key.TTL()
+	if key.ExpiresAt == 0 {
+		ttl = cache.NoExpiration
+	} else if ttl <= 0 {
+		return ErrRepositoryInsert.M("%s already expired", key.ID)
+	}
+
+	ok, err := r.cache.Add(apiKeyKey(key.ID), b, ttl)
 	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	if !ok {
+		return ErrRepositoryExists.C("id", key.ID)
+	}
+
+	ids, err := r.index(userAPIKeysKey(key.UserID))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == key.ID {
+			return nil
+		}
+	}
+
+	return r.set(userAPIKeysKey(key.UserID), append(ids, key.ID), 0, 0)
+}
+
+func (r *repository) TouchAPIKey(key *models.APIKey, lastUsedAt int64) error {
+	return r.set(apiKeyLastUsedKey(key.ID), lastUsedAt, key.ExpiresAt, key.TTL())
+}
+
+func (r *repository) DeleteAPIKey(key *models.APIKey) error {
+	if err := r.cache.Delete(apiKeyKey(key.ID)); err != nil {
 		return ErrRepositoryDelete.Wrap(err)
 	}
+	if err := r.cache.Delete(apiKeyLastUsedKey(key.ID)); err != nil {
+		return ErrRepositoryDelete.Wrap(err)
+	}
+
+	ids, err := r.index(userAPIKeysKey(key.UserID))
+	if err != nil {
+		return err
+	}
+	active := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != key.ID {
+			active = append(active, id)
+		}
+	}
+
+	return r.set(userAPIKeysKey(key.UserID), active, 0, 0)
+}
+
+// index returns the IDs stored in key, or none if it does not exist.
+func (r *repository) index(key string) ([]string, error) {
+	ids := make([]string, 0)
+	if err := r.get(key, &ids); err != nil {
+		tErr, ok := err.(errors.Error)
+		if ok && tErr.Equals(ErrRepositoryNotFound) && tErr.Message == "" {
+			return ids, nil
+		}
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *repository) get(key string, dst interface{}) error {
+	v, err := r.cache.Get(key)
+	if v == nil || err != nil {
+		return ErrRepositoryNotFound.Wrap(err)
+	}
+
+	var b []byte
+	switch v := v.(type) {
+	case []byte:
+		b = v
+	case string: // Redis
+		b = []byte(v)
+	default:
+		return ErrRepositoryNotFound.M("wrong conversion")
+	}
+
+	if err := json.Unmarshal(b, dst); err != nil {
+		return ErrRepositoryNotFound.Wrap(err)
+	}
+
+	return nil
+}
+
+// set stores v until expiresAt, given as Unix nanoseconds with zero meaning
+// no expiration. ttl is the time left until then.
+func (r *repository) set(key string, v interface{}, expiresAt int64, ttl time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+
+	if expiresAt == 0 {
+		ttl = cache.NoExpiration
+	} else if ttl <= 0 {
+		return ErrRepositoryInsert.M("%s already expired", key)
+	}
+
+	if err := r.cache.Set(key, b, ttl); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
 	return nil
 }
+
+func usedKey(tokenID string) string {
+	return "used:" + tokenID
+}
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func sessionLastUsedKey(sessionID string) string {
+	return "session_last_used:" + sessionID
+}
+
+func sessionRevokedKey(sessionID string) string {
+	return "session_revoked:" + sessionID
+}
+
+func userSessionsKey(userID string) string {
+	return "user_sessions:" + userID
+}
+
+func apiKeyKey(keyID string) string {
+	return "api_key:" + keyID
+}
+
+func apiKeyLastUsedKey(keyID string) string {
+	return "api_key_last_used:" + keyID
+}
+
+func userAPIKeysKey(userID string) string {
+	return "user_api_keys:" + userID
+}
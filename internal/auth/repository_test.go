@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/aboglioli/big-brother/mocks"
 	"github.com/aboglioli/big-brother/pkg/cache"
@@ -43,6 +44,13 @@ func TestFindByID(t *testing.T) {
 		func(c *mocks.MockCache) {
 			c.On("Get", mToken.ID).Return(mBytes, nil)
 		},
+	}, {
+		"existing token stored as string",
+		mToken.ID,
+		nil,
+		func(c *mocks.MockCache) {
+			c.On("Get", mToken.ID).Return(string(mBytes), nil)
+		},
 	}}
 
 	for _, test := range tests {
@@ -74,9 +82,14 @@ func TestFindByID(t *testing.T) {
 
 func TestInsert(t *testing.T) {
 	mToken := models.NewToken("user123")
+	mToken.Expires(time.Hour)
 	mBytes, err := json.Marshal(mToken)
 	require.Nil(t, err)
 
+	expired := models.NewToken("user123")
+	expired.CreatedAt = time.Now().Add(-2 * time.Hour).UnixNano()
+	expired.Expires(time.Hour)
+
 	tests := []struct {
 		name  string
 		token *models.Token
@@ -89,6 +102,11 @@ func TestInsert(t *testing.T) {
 		func(c *mocks.MockCache) {
 			c.On("Set", mToken.ID, mBytes).Return(cache.ErrCacheSet)
 		},
+	}, {
+		"already expired",
+		expired,
+		ErrRepositoryInsert,
+		nil,
 	}, {
 		"success",
 		mToken,
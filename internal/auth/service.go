@@ -1,67 +1,194 @@
 package auth
 
 import (
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/config"
 	"github.com/aboglioli/big-brother/pkg/errors"
 	"github.com/aboglioli/big-brother/pkg/models"
 )
 
 // Errors
 var (
-	ErrCreate     = errors.Status.New("auth.service.create")
-	ErrValidate   = errors.Status.New("auth.service.validate")
-	ErrInvalidate = errors.Status.New("auth.service.invalidate")
+	ErrCreate       = errors.Status.New("auth.service.create").S(500)
+	ErrValidate     = errors.Status.New("auth.service.validate").S(401)
+	ErrInvalidate   = errors.Status.New("auth.service.invalidate").S(401)
+	ErrExpired      = errors.Status.New("auth.service.expired_token").S(401)
+	ErrRefresh      = errors.Status.New("auth.service.refresh").S(401)
+	ErrRefreshReuse = errors.Status.New("auth.service.refresh_reuse").S(401)
+	ErrSessions     = errors.Status.New("auth.service.sessions").S(500)
+	ErrRevoke       = errors.Status.New("auth.service.revoke").S(500)
+	ErrNoSession    = errors.Status.New("auth.service.session_not_found").S(404)
+	ErrRevoked      = errors.Status.New("auth.service.session_revoked").S(401)
 )
 
+// lastUsedInterval is how stale a session's LastUsedAt may get before
+// Validate writes it again, to avoid a write on every request.
+const lastUsedInterval = time.Minute
+
 // Interface
 type Service interface {
-	Create(userID string) (string, error)
+	Create(req *CreateRequest) (*TokenPair, error)
+	Refresh(req *RefreshRequest) (*TokenPair, error)
 	Validate(tokenStr string) (*models.Token, error)
 	Invalidate(tokenStr string) (*models.Token, error)
+
+	ListSessions(userID string) ([]*models.Session, error)
+	RevokeSession(userID, sessionID string) error
+	// RevokeAll revokes every session of the user except the given ones.
+	RevokeAll(userID string, except ...string) error
+
+	// CreateAPIKey returns the key and its plain value, which is not stored
+	// and cannot be recovered.
+	CreateAPIKey(req *CreateAPIKeyRequest) (*models.APIKey, string, error)
+	ListAPIKeys(userID string) ([]*models.APIKey, error)
+	RevokeAPIKey(userID, keyID string) error
+	// RevokeAPIKeys revokes every API key of the user.
+	RevokeAPIKeys(userID string) error
+	// ValidateAPIKey is the Validate of API keys.
+	ValidateAPIKey(keyStr string) (*models.APIKey, error)
+
+	// SignAction returns a token for a single kind of action of a user.
+	SignAction(req *ActionRequest) (string, error)
+	VerifyAction(tokenStr, action string) (*ActionClaims, error)
+}
+
+// CreateRequest describes the login that starts a session.
+type CreateRequest struct {
+	UserID    string
+	Role      models.Role
+	IP        string
+	UserAgent string
+
+	// ClientID and Scope are set when an OAuth client starts the session.
+	ClientID string
+	Scope    string
+	// Machine requests a token for the client itself, without UserID.
+	Machine bool
+}
+
+// RefreshRequest carries the client refreshing the session, which must be the
+// one that created it. Sessions started with a password have none.
+type RefreshRequest struct {
+	RefreshToken string
+	ClientID     string
+}
+
+// TokenPair is what a client gets on login and on every refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	// IDToken is set by OpenID Connect flows.
+	IDToken string `json:"id_token,omitempty"`
 }
 
 // Implementation
 type service struct {
-	repo Repository
-	enc  Encoder
+	repo       Repository
+	enc        Encoder
+	ttl        time.Duration
+	refreshTTL time.Duration
 }
 
-func NewService(repo Repository) Service {
-	enc := NewEncoder()
+func NewService(repo Repository, enc Encoder) Service {
+	c := config.Get()
 	return &service{
-		repo: repo,
-		enc:  enc,
+		repo:       repo,
+		enc:        enc,
+		ttl:        time.Duration(c.AccessTokenTTL) * time.Second,
+		refreshTTL: time.Duration(c.RefreshTokenTTL) * time.Second,
 	}
 }
 
-func (s *service) Create(userID string) (string, error) {
-	if userID == "" {
-		return "", ErrCreate
+// Create starts a session with an access and a refresh token. Machine
+// tokens get neither session nor refresh token: the client authenticates
+// again when the token expires.
+func (s *service) Create(req *CreateRequest) (*TokenPair, error) {
+	if req == nil {
+		return nil, ErrCreate
+	}
+	if req.Machine {
+		return s.createMachine(req)
+	}
+	if req.UserID == "" {
+		return nil, ErrCreate
 	}
 
-	token := models.NewToken(userID)
-	tokenStr, err := s.enc.Encode(token.ID)
-	if tokenStr == "" || err != nil {
-		return "", ErrCreate.Wrap(err)
+	session := models.NewSession(req.UserID)
+	session.Role = req.Role
+	session.ClientID = req.ClientID
+	session.Scope = req.Scope
+	session.IP = req.IP
+	session.UserAgent = req.UserAgent
+	session.Expires(s.refreshTTL)
+
+	pair, err := s.issue(session)
+	if err != nil {
+		return nil, ErrCreate.Wrap(err)
 	}
 
-	if err := s.repo.Insert(token); err != nil {
-		return "", ErrCreate.Wrap(err)
+	return pair, nil
+}
+
+// Refresh rotates the refresh token: it can only be used once and every use
+// returns a new pair. Presenting a used refresh token again means it leaked,
+// so the whole session is revoked. Marking the token used is atomic, so of
+// concurrent refreshes with the same token only one succeeds.
+func (s *service) Refresh(req *RefreshRequest) (*TokenPair, error) {
+	token, err := s.decode(req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.Kind != models.REFRESH {
+		return nil, ErrRefresh.C("id", token.ID)
+	}
+
+	session, err := s.repo.FindSession(token.SessionID)
+	if err != nil {
+		return nil, ErrRefresh.Wrap(err)
+	}
+	if session.Revoked {
+		return nil, ErrRefresh.C("session", session.ID)
+	}
+	if session.ClientID != req.ClientID {
+		return nil, ErrRefresh.C("session", session.ID).C("client", req.ClientID)
+	}
+
+	first, err := s.repo.MarkUsed(token)
+	if err != nil {
+		return nil, ErrRefresh.Wrap(err)
+	}
+	if !first {
+		if err := s.revoke(session); err != nil {
+			return nil, ErrRefreshReuse.Wrap(err)
+		}
+		return nil, ErrRefreshReuse.C("session", session.ID)
+	}
+
+	session.LastUsedAt = time.Now().UnixNano()
+
+	pair, err := s.issue(session)
+	if err != nil {
+		return nil, ErrRefresh.Wrap(err)
 	}
 
-	return tokenStr, nil
+	return pair, nil
 }
 
 func (s *service) Validate(tokenStr string) (*models.Token, error) {
-	tokenID, err := s.enc.Decode(tokenStr)
-	if tokenID == "" || err != nil {
-		return nil, ErrValidate.Wrap(err)
+	token, err := s.decode(tokenStr)
+	if err != nil {
+		return nil, err
 	}
-
-	token, err := s.repo.FindByID(tokenID)
-	if token == nil || err != nil {
-		return nil, ErrValidate.Wrap(err)
+	if token.Kind == models.REFRESH {
+		return nil, ErrValidate.C("id", token.ID)
 	}
 
+	s.touch(token.SessionID)
+
 	return token, nil
 }
 
@@ -75,5 +202,242 @@ func (s *service) Invalidate(tokenStr string) (*models.Token, error) {
 		return nil, ErrInvalidate.Wrap(err)
 	}
 
+	if token.SessionID != "" {
+		session, err := s.repo.FindSession(token.SessionID)
+		if err == nil {
+			if err := s.revoke(session); err != nil {
+				return nil, ErrInvalidate.Wrap(err)
+			}
+		}
+	}
+
 	return token, nil
 }
+
+func (s *service) ListSessions(userID string) ([]*models.Session, error) {
+	sessions, err := s.repo.FindSessionsByUser(userID)
+	if err != nil {
+		return nil, ErrSessions.C("user", userID).Wrap(err)
+	}
+	return sessions, nil
+}
+
+func (s *service) RevokeSession(userID, sessionID string) error {
+	session, err := s.repo.FindSession(sessionID)
+	if err != nil || session.UserID != userID || session.Revoked {
+		return ErrNoSession.C("id", sessionID).Wrap(err)
+	}
+
+	if err := s.revoke(session); err != nil {
+		return ErrRevoke.C("id", sessionID).Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *service) RevokeAll(userID string, except ...string) error {
+	sessions, err := s.repo.FindSessionsByUser(userID)
+	if err != nil {
+		return ErrRevoke.C("user", userID).Wrap(err)
+	}
+
+	keep := make(map[string]bool)
+	for _, id := range except {
+		keep[id] = true
+	}
+
+	for _, session := range sessions {
+		if keep[session.ID] {
+			continue
+		}
+		if err := s.revoke(session); err != nil {
+			return ErrRevoke.C("user", userID).Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// touch records that the session was used. It is best effort: failing to
+// update LastUsedAt must not reject an otherwise valid token. Only the time
+// is written, so a refresh or revocation running meanwhile is kept.
+func (s *service) touch(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+
+	session, err := s.repo.FindSession(sessionID)
+	if err != nil || session.Revoked {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	if now-session.LastUsedAt < int64(lastUsedInterval) {
+		return
+	}
+
+	_ = s.repo.TouchSession(session, now)
+}
+
+func (s *service) createMachine(req *CreateRequest) (*TokenPair, error) {
+	if req.ClientID == "" || req.UserID != "" {
+		return nil, ErrCreate
+	}
+
+	token := models.NewToken(req.ClientID)
+	token.ClientID = req.ClientID
+	token.Scope = req.Scope
+	token.Machine = true
+	token.Expires(s.ttl)
+
+	tokenStr, err := s.enc.Encode(token)
+	if tokenStr == "" || err != nil {
+		return nil, ErrCreate.Wrap(err)
+	}
+	if err := s.repo.Insert(token); err != nil {
+		return nil, ErrCreate.Wrap(err)
+	}
+
+	return &TokenPair{
+		AccessToken: tokenStr,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.ttl / time.Second),
+		Scope:       req.Scope,
+	}, nil
+}
+
+// decode returns the stored, unexpired token behind tokenStr.
+func (s *service) decode(tokenStr string) (*models.Token, error) {
+	claims, err := s.enc.Decode(tokenStr)
+	if isExpired(err) {
+		return nil, ErrExpired.Wrap(err)
+	}
+	if claims == nil || err != nil {
+		return nil, ErrValidate.Wrap(err)
+	}
+
+	token, err := s.repo.FindByID(claims.TokenID())
+	if token == nil || err != nil {
+		return nil, ErrValidate.Wrap(err)
+	}
+	if token.UserID != claims.UserID() {
+		return nil, ErrValidate.C("id", token.ID)
+	}
+
+	if token.Expired() {
+		return nil, ErrExpired.C("id", token.ID)
+	}
+
+	// Tokens issued while the session was being revoked may have outlived it
+	if token.SessionID != "" {
+		revoked, err := s.repo.SessionRevoked(token.SessionID)
+		if err != nil {
+			return nil, ErrValidate.Wrap(err)
+		}
+		if revoked {
+			return nil, ErrRevoked.C("id", token.ID).C("session", token.SessionID)
+		}
+	}
+
+	return token, nil
+}
+
+// issue adds a new access and refresh token to session. It refuses revoked
+// sessions, and takes the tokens back if the session is revoked while they
+// are written: that revocation may have read the session before them.
+func (s *service) issue(session *models.Session) (*TokenPair, error) {
+	if err := s.checkNotRevoked(session); err != nil {
+		return nil, err
+	}
+
+	access := models.NewToken(session.UserID)
+	access.Role = session.Role
+	access.ClientID = session.ClientID
+	access.Scope = session.Scope
+	access.SessionID = session.ID
+	access.Expires(s.ttl)
+
+	refresh := models.NewToken(session.UserID)
+	refresh.Role = session.Role
+	refresh.ClientID = session.ClientID
+	refresh.Scope = session.Scope
+	refresh.Kind = models.REFRESH
+	refresh.SessionID = session.ID
+	refresh.ExpiresAt = session.ExpiresAt
+
+	accessStr, err := s.enc.Encode(access)
+	if accessStr == "" || err != nil {
+		return nil, err
+	}
+	refreshStr, err := s.enc.Encode(refresh)
+	if refreshStr == "" || err != nil {
+		return nil, err
+	}
+
+	session.TokenIDs = append(session.TokenIDs, access.ID, refresh.ID)
+	if err := s.repo.InsertSession(session); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Insert(access); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Insert(refresh); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNotRevoked(session); err != nil {
+		_ = s.repo.Delete(access.ID)
+		_ = s.repo.Delete(refresh.ID)
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessStr,
+		RefreshToken: refreshStr,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.ttl / time.Second),
+		Scope:        session.Scope,
+	}, nil
+}
+
+// revoke marks session revoked until it expires and deletes its tokens. The
+// mark goes first and the session is read again after it, so a concurrent
+// issue either sees the mark or has its tokens deleted here.
+func (s *service) revoke(session *models.Session) error {
+	if err := s.repo.RevokeSession(session); err != nil {
+		return err
+	}
+
+	tokenIDs := append([]string(nil), session.TokenIDs...)
+	if current, err := s.repo.FindSession(session.ID); err == nil {
+		tokenIDs = append(tokenIDs, current.TokenIDs...)
+	}
+
+	for _, tokenID := range tokenIDs {
+		if err := s.repo.Delete(tokenID); err != nil {
+			return err
+		}
+	}
+
+	session.Revoked = true
+	return nil
+}
+
+func (s *service) checkNotRevoked(session *models.Session) error {
+	if session.Revoked {
+		return ErrRevoked.C("session", session.ID)
+	}
+	revoked, err := s.repo.SessionRevoked(session.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrRevoked.C("session", session.ID)
+	}
+	return nil
+}
+
+func isExpired(err error) bool {
+	tErr, ok := err.(errors.Error)
+	return ok && tErr.Equals(ErrTokenExpired)
+}
@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"time"
+
 	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -10,16 +13,42 @@ type mockEncoder struct {
 	mock.Mock
 }
 
-func (m *mockEncoder) Encode(tokenID string) (string, error) {
-	args := m.Called(tokenID)
+func (m *mockEncoder) Encode(token *models.Token) (string, error) {
+	args := m.Called(token)
 	return args.String(0), args.Error(1)
 }
 
-func (m *mockEncoder) Decode(tokenStr string) (string, error) {
+func (m *mockEncoder) Decode(tokenStr string) (*Claims, error) {
 	args := m.Called(tokenStr)
+	if claims, ok := args.Get(0).(*Claims); ok {
+		return claims, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockEncoder) Sign(claims jwt.Claims) (string, error) {
+	args := m.Called(claims)
 	return args.String(0), args.Error(1)
 }
 
+func (m *mockEncoder) Parse(tokenStr string, claims jwt.Claims) error {
+	args := m.Called(tokenStr, claims)
+	return args.Error(0)
+}
+
+func (m *mockEncoder) Alg() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *mockEncoder) JWKS() *JWKS {
+	args := m.Called()
+	if jwks, ok := args.Get(0).(*JWKS); ok {
+		return jwks
+	}
+	return nil
+}
+
 // Repository
 type mockRepository struct {
 	mock.Mock
@@ -43,6 +72,78 @@ func (m *mockRepository) Delete(tokenID string) error {
 	return args.Error(0)
 }
 
+func (m *mockRepository) MarkUsed(token *models.Token) (bool, error) {
+	args := m.Called(token)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockRepository) FindSession(sessionID string) (*models.Session, error) {
+	args := m.Called(sessionID)
+	if session, ok := args.Get(0).(*models.Session); ok {
+		return session, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockRepository) FindSessionsByUser(userID string) ([]*models.Session, error) {
+	args := m.Called(userID)
+	if sessions, ok := args.Get(0).([]*models.Session); ok {
+		return sessions, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockRepository) InsertSession(session *models.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *mockRepository) TouchSession(session *models.Session, lastUsedAt int64) error {
+	args := m.Called(session, lastUsedAt)
+	return args.Error(0)
+}
+
+func (m *mockRepository) RevokeSession(session *models.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *mockRepository) SessionRevoked(sessionID string) (bool, error) {
+	args := m.Called(sessionID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockRepository) FindAPIKey(keyID string) (*models.APIKey, error) {
+	args := m.Called(keyID)
+	if key, ok := args.Get(0).(*models.APIKey); ok {
+		return key, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockRepository) FindAPIKeysByUser(userID string) ([]*models.APIKey, error) {
+	args := m.Called(userID)
+	if keys, ok := args.Get(0).([]*models.APIKey); ok {
+		return keys, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockRepository) InsertAPIKey(key *models.APIKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *mockRepository) TouchAPIKey(key *models.APIKey, lastUsedAt int64) error {
+	args := m.Called(key, lastUsedAt)
+	return args.Error(0)
+}
+
+func (m *mockRepository) DeleteAPIKey(key *models.APIKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
 // Service
 type mockService struct {
 	*service
@@ -54,8 +155,10 @@ func newMockService() *mockService {
 	repo := &mockRepository{}
 	enc := &mockEncoder{}
 	serv := &service{
-		repo: repo,
-		enc:  enc,
+		repo:       repo,
+		enc:        enc,
+		ttl:        time.Hour,
+		refreshTTL: 24 * time.Hour,
 	}
 	return &mockService{serv, repo, enc}
 }
@@ -1,12 +1,16 @@
 package auth
 
 import (
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aboglioli/big-brother/pkg/cache"
 	"github.com/aboglioli/big-brother/pkg/errors"
 	"github.com/aboglioli/big-brother/pkg/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateToken(t *testing.T) {
@@ -22,20 +26,45 @@ func TestCreateToken(t *testing.T) {
 		"",
 		ErrCreate,
 		nil,
+	}, {
+		"session error",
+		"user123",
+		ErrCreate.Wrap(ErrRepositoryInsert),
+		func(s *mockService) {
+			s.enc.On("Encode", mock.Anything).Return(mTokenStr, nil)
+			s.repo.On("SessionRevoked", mock.AnythingOfType("string")).Return(false, nil)
+			s.repo.On("InsertSession", mock.AnythingOfType("*models.Session")).Return(ErrRepositoryInsert)
+		},
 	}, {
 		"repo error",
 		"user123",
 		ErrCreate.Wrap(ErrRepositoryInsert),
 		func(s *mockService) {
 			s.enc.On("Encode", mock.Anything).Return(mTokenStr, nil)
+			s.repo.On("SessionRevoked", mock.AnythingOfType("string")).Return(false, nil)
+			s.repo.On("InsertSession", mock.AnythingOfType("*models.Session")).Return(nil)
 			s.repo.On("Insert", mock.AnythingOfType("*models.Token")).Return(ErrRepositoryInsert)
 		},
+	}, {
+		"revoked meanwhile",
+		"user123",
+		ErrCreate.Wrap(ErrRevoked),
+		func(s *mockService) {
+			s.enc.On("Encode", mock.Anything).Return(mTokenStr, nil)
+			s.repo.On("SessionRevoked", mock.AnythingOfType("string")).Return(false, nil).Once()
+			s.repo.On("InsertSession", mock.AnythingOfType("*models.Session")).Return(nil)
+			s.repo.On("Insert", mock.AnythingOfType("*models.Token")).Return(nil)
+			s.repo.On("SessionRevoked", mock.AnythingOfType("string")).Return(true, nil).Once()
+			s.repo.On("Delete", mock.AnythingOfType("string")).Return(nil).Twice()
+		},
 	}, {
 		"user123",
 		"user123",
 		nil,
 		func(s *mockService) {
 			s.enc.On("Encode", mock.Anything).Return(mTokenStr, nil)
+			s.repo.On("SessionRevoked", mock.AnythingOfType("string")).Return(false, nil)
+			s.repo.On("InsertSession", mock.AnythingOfType("*models.Session")).Return(nil)
 			s.repo.On("Insert", mock.AnythingOfType("*models.Token")).Return(nil)
 		},
 	}}
@@ -48,21 +77,48 @@ func TestCreateToken(t *testing.T) {
 				test.mock(serv)
 			}
 
-			tokenStr, err := serv.Create(test.userID)
+			pair, err := serv.Create(&CreateRequest{
+				UserID:    test.userID,
+				Role:      models.ADMIN,
+				IP:        "10.0.0.1",
+				UserAgent: "curl/7.68.0",
+			})
 
 			if test.err != nil { // Error
 				if assert.NotNil(err) {
 					errors.Assert(t, test.err, err)
 				}
-				assert.Empty(tokenStr)
+				assert.Nil(pair)
 			} else { // OK
 				assert.Nil(err)
-				if assert.NotEmpty(tokenStr) {
-					assert.Equal(mTokenStr, tokenStr)
+				if assert.NotNil(pair) {
+					assert.Equal(mTokenStr, pair.AccessToken)
+					assert.Equal(mTokenStr, pair.RefreshToken)
+					assert.Equal(int64(3600), pair.ExpiresIn)
 				}
-				t, ok := serv.repo.Calls[0].Arguments[0].(*models.Token)
+				session, ok := serv.repo.Calls[1].Arguments[0].(*models.Session)
+				assert.True(ok)
+				access, ok := serv.repo.Calls[2].Arguments[0].(*models.Token)
 				assert.True(ok)
-				assert.Equal(test.userID, t.UserID)
+				refresh, ok := serv.repo.Calls[3].Arguments[0].(*models.Token)
+				assert.True(ok)
+
+				assert.Equal(test.userID, session.UserID)
+				assert.Equal("10.0.0.1", session.IP)
+				assert.Equal("curl/7.68.0", session.UserAgent)
+				assert.Equal([]string{access.ID, refresh.ID}, session.TokenIDs)
+
+				assert.Equal(test.userID, access.UserID)
+				assert.Equal(models.ACCESS, access.Kind)
+				assert.Equal(models.ADMIN, access.Role)
+				assert.Equal(session.ID, access.SessionID)
+				assert.Equal(access.CreatedAt+int64(time.Hour), access.ExpiresAt)
+
+				assert.Equal(test.userID, refresh.UserID)
+				assert.Equal(models.REFRESH, refresh.Kind)
+				assert.Equal(models.ADMIN, refresh.Role)
+				assert.Equal(session.ID, refresh.SessionID)
+				assert.Equal(session.ExpiresAt, refresh.ExpiresAt)
 			}
 			serv.enc.AssertExpectations(t)
 			serv.repo.AssertExpectations(t)
@@ -70,9 +126,436 @@ func TestCreateToken(t *testing.T) {
 	}
 }
 
+func TestCreateMachineToken(t *testing.T) {
+	mTokenStr := "encoded.token"
+
+	tests := []struct {
+		name string
+		req  *CreateRequest
+		err  error
+		mock func(*mockService)
+	}{{
+		"without client",
+		&CreateRequest{Machine: true},
+		ErrCreate,
+		nil,
+	}, {
+		"with user",
+		&CreateRequest{UserID: "user123", ClientID: "client123", Machine: true},
+		ErrCreate,
+		nil,
+	}, {
+		"repo error",
+		&CreateRequest{ClientID: "client123", Machine: true},
+		ErrCreate.Wrap(ErrRepositoryInsert),
+		func(s *mockService) {
+			s.enc.On("Encode", mock.Anything).Return(mTokenStr, nil)
+			s.repo.On("Insert", mock.AnythingOfType("*models.Token")).Return(ErrRepositoryInsert)
+		},
+	}, {
+		"client123",
+		&CreateRequest{ClientID: "client123", Scope: "users:read", Machine: true},
+		nil,
+		func(s *mockService) {
+			s.enc.On("Encode", mock.Anything).Return(mTokenStr, nil)
+			s.repo.On("Insert", mock.AnythingOfType("*models.Token")).Return(nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := newMockService()
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			pair, err := serv.Create(test.req)
+
+			if test.err != nil { // Error
+				if assert.NotNil(err) {
+					errors.Assert(t, test.err, err)
+				}
+				assert.Nil(pair)
+			} else { // OK
+				assert.Nil(err)
+				if assert.NotNil(pair) {
+					assert.Equal(mTokenStr, pair.AccessToken)
+					assert.Empty(pair.RefreshToken)
+					assert.Equal("users:read", pair.Scope)
+				}
+				// No session is created
+				assert.Len(serv.repo.Calls, 1)
+				token, ok := serv.repo.Calls[0].Arguments[0].(*models.Token)
+				assert.True(ok)
+				assert.True(token.Machine)
+				assert.Equal("client123", token.UserID)
+				assert.Equal("client123", token.ClientID)
+				assert.Equal("users:read", token.Scope)
+				assert.Equal(models.ACCESS, token.Kind)
+				assert.Empty(token.SessionID)
+			}
+			serv.enc.AssertExpectations(t)
+			serv.repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	newServ := func() *service {
+		return &service{
+			repo:       NewRepository(cache.NewInMemory("auth")),
+			enc:        &encoder{secret: []byte("my_secret")},
+			ttl:        time.Hour,
+			refreshTTL: 24 * time.Hour,
+		}
+	}
+
+	t.Run("rotation", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		first, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+
+		second, err := serv.Refresh(&RefreshRequest{RefreshToken: first.RefreshToken})
+		require.Nil(t, err)
+		assert.NotEqual(first.AccessToken, second.AccessToken)
+		assert.NotEqual(first.RefreshToken, second.RefreshToken)
+
+		token, err := serv.Validate(second.AccessToken)
+		assert.Nil(err)
+		if assert.NotNil(token) {
+			assert.Equal("user123", token.UserID)
+		}
+
+		third, err := serv.Refresh(&RefreshRequest{RefreshToken: second.RefreshToken})
+		assert.Nil(err)
+		assert.NotNil(third)
+	})
+
+	t.Run("access token as refresh token", func(t *testing.T) {
+		serv := newServ()
+
+		pair, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: pair.AccessToken})
+		errors.Assert(t, ErrRefresh, err)
+	})
+
+	t.Run("refresh token as access token", func(t *testing.T) {
+		serv := newServ()
+
+		pair, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+
+		_, err = serv.Validate(pair.RefreshToken)
+		errors.Assert(t, ErrValidate, err)
+	})
+
+	t.Run("reuse revokes the session", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		first, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+		second, err := serv.Refresh(&RefreshRequest{RefreshToken: first.RefreshToken})
+		require.Nil(t, err)
+
+		// Leaked refresh token used again
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: first.RefreshToken})
+		errors.Assert(t, ErrRefreshReuse, err)
+
+		_, err = serv.Validate(first.AccessToken)
+		assert.NotNil(err)
+		_, err = serv.Validate(second.AccessToken)
+		assert.NotNil(err)
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: second.RefreshToken})
+		assert.NotNil(err)
+	})
+
+	t.Run("concurrent reuse", func(t *testing.T) {
+		serv := newServ()
+
+		pair, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+
+		const n = 10
+		errs := make(chan error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		// The rest are reuse, or find the session revoked by one
+		rotated := 0
+		for err := range errs {
+			if err == nil {
+				rotated++
+			}
+		}
+		assert.Equal(t, 1, rotated)
+	})
+
+	t.Run("logout revokes the session", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		pair, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+
+		_, err = serv.Invalidate(pair.AccessToken)
+		require.Nil(t, err)
+
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken})
+		assert.NotNil(err)
+	})
+
+	t.Run("client sessions", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		pair, err := serv.Create(&CreateRequest{UserID: "user123", ClientID: "client123", Scope: "openid"})
+		require.Nil(t, err)
+		assert.Equal("openid", pair.Scope)
+
+		token, err := serv.Validate(pair.AccessToken)
+		require.Nil(t, err)
+		assert.Equal("client123", token.ClientID)
+		assert.Equal("openid", token.Scope)
+
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken})
+		errors.Assert(t, ErrRefresh, err)
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken, ClientID: "other"})
+		errors.Assert(t, ErrRefresh, err)
+
+		second, err := serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken, ClientID: "client123"})
+		assert.Nil(err)
+		if assert.NotNil(second) {
+			assert.Equal("openid", second.Scope)
+		}
+	})
+
+	t.Run("other sessions are untouched", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		first, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+		other, err := serv.Create(&CreateRequest{UserID: "user123"})
+		require.Nil(t, err)
+
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: first.RefreshToken})
+		require.Nil(t, err)
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: first.RefreshToken})
+		errors.Assert(t, ErrRefreshReuse, err)
+
+		_, err = serv.Validate(other.AccessToken)
+		assert.Nil(err)
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: other.RefreshToken})
+		assert.Nil(err)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	newServ := func() *service {
+		return &service{
+			repo:       NewRepository(cache.NewInMemory("auth")),
+			enc:        &encoder{secret: []byte("my_secret")},
+			ttl:        time.Hour,
+			refreshTTL: 24 * time.Hour,
+		}
+	}
+	login := &CreateRequest{UserID: "user123", IP: "10.0.0.1", UserAgent: "curl/7.68.0"}
+
+	t.Run("list", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		_, err := serv.Create(login)
+		require.Nil(t, err)
+		_, err = serv.Create(login)
+		require.Nil(t, err)
+		_, err = serv.Create(&CreateRequest{UserID: "other"})
+		require.Nil(t, err)
+
+		sessions, err := serv.ListSessions("user123")
+		assert.Nil(err)
+		if assert.Len(sessions, 2) {
+			assert.Equal("10.0.0.1", sessions[0].IP)
+			assert.Equal("curl/7.68.0", sessions[0].UserAgent)
+		}
+	})
+
+	t.Run("last use", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		pair, err := serv.Create(login)
+		require.Nil(t, err)
+		sessions, err := serv.ListSessions("user123")
+		require.Nil(t, err)
+		session := sessions[0]
+		session.LastUsedAt -= int64(2 * lastUsedInterval)
+		require.Nil(t, serv.repo.InsertSession(session))
+
+		_, err = serv.Validate(pair.AccessToken)
+		require.Nil(t, err)
+
+		found, err := serv.repo.FindSession(session.ID)
+		require.Nil(t, err)
+		assert.True(found.LastUsedAt > session.LastUsedAt)
+	})
+
+	t.Run("last use keeps a concurrent revocation", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		_, err := serv.Create(login)
+		require.Nil(t, err)
+		sessions, err := serv.ListSessions("user123")
+		require.Nil(t, err)
+		stale := sessions[0]
+
+		require.Nil(t, serv.RevokeSession("user123", stale.ID))
+		now := time.Now().UnixNano()
+		require.Nil(t, serv.repo.TouchSession(stale, now))
+
+		found, err := serv.repo.FindSession(stale.ID)
+		require.Nil(t, err)
+		assert.True(found.Revoked)
+		assert.Equal(now, found.LastUsedAt)
+	})
+
+	t.Run("revocation keeps a concurrent refresh", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		pair, err := serv.Create(login)
+		require.Nil(t, err)
+		sessions, err := serv.ListSessions("user123")
+		require.Nil(t, err)
+		stale := sessions[0]
+
+		// The refresh read the session before the revocation and writes it
+		// back after
+		require.Nil(t, serv.RevokeSession("user123", stale.ID))
+		_, err = serv.issue(stale)
+		errors.Assert(t, ErrRevoked, err)
+		require.Nil(t, serv.repo.InsertSession(stale))
+
+		found, err := serv.repo.FindSession(stale.ID)
+		require.Nil(t, err)
+		assert.True(found.Revoked)
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken})
+		assert.NotNil(err)
+	})
+
+	t.Run("concurrent refresh and revocation", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			serv := newServ()
+
+			pair, err := serv.Create(login)
+			require.Nil(t, err)
+			token, err := serv.Validate(pair.AccessToken)
+			require.Nil(t, err)
+
+			var (
+				wg        sync.WaitGroup
+				refreshed *TokenPair
+			)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				refreshed, _ = serv.Refresh(&RefreshRequest{RefreshToken: pair.RefreshToken})
+			}()
+			go func() {
+				defer wg.Done()
+				assert.Nil(t, serv.RevokeSession("user123", token.SessionID))
+			}()
+			wg.Wait()
+
+			// Whatever the order, nothing of the session outlives it
+			if refreshed != nil {
+				_, err = serv.Validate(refreshed.AccessToken)
+				assert.NotNil(t, err)
+				_, err = serv.Refresh(&RefreshRequest{RefreshToken: refreshed.RefreshToken})
+				assert.NotNil(t, err)
+			}
+		}
+	})
+
+	t.Run("revoke one", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		first, err := serv.Create(login)
+		require.Nil(t, err)
+		second, err := serv.Create(login)
+		require.Nil(t, err)
+		token, err := serv.Validate(first.AccessToken)
+		require.Nil(t, err)
+
+		err = serv.RevokeSession("other", token.SessionID)
+		errors.Assert(t, ErrNoSession, err)
+
+		assert.Nil(serv.RevokeSession("user123", token.SessionID))
+		_, err = serv.Validate(first.AccessToken)
+		assert.NotNil(err)
+		_, err = serv.Refresh(&RefreshRequest{RefreshToken: first.RefreshToken})
+		assert.NotNil(err)
+		_, err = serv.Validate(second.AccessToken)
+		assert.Nil(err)
+
+		err = serv.RevokeSession("user123", token.SessionID)
+		errors.Assert(t, ErrNoSession, err)
+
+		sessions, err := serv.ListSessions("user123")
+		assert.Nil(err)
+		assert.Len(sessions, 1)
+	})
+
+	t.Run("revoke all but current", func(t *testing.T) {
+		assert := assert.New(t)
+		serv := newServ()
+
+		current, err := serv.Create(login)
+		require.Nil(t, err)
+		other, err := serv.Create(login)
+		require.Nil(t, err)
+		token, err := serv.Validate(current.AccessToken)
+		require.Nil(t, err)
+
+		assert.Nil(serv.RevokeAll("user123", token.SessionID))
+		_, err = serv.Validate(other.AccessToken)
+		assert.NotNil(err)
+		_, err = serv.Validate(current.AccessToken)
+		assert.Nil(err)
+
+		assert.Nil(serv.RevokeAll("user123"))
+		_, err = serv.Validate(current.AccessToken)
+		assert.NotNil(err)
+
+		sessions, err := serv.ListSessions("user123")
+		assert.Nil(err)
+		assert.Empty(sessions)
+	})
+}
+
 func TestValidate(t *testing.T) {
 	mToken := models.NewToken("user123")
+	mClaims := NewClaims(mToken, "", "")
 	mTokenStr := "encoded.token"
+	mSessionToken := *mToken
+	mSessionToken.SessionID = "session123"
 
 	tests := []struct {
 		name     string
@@ -84,29 +567,74 @@ func TestValidate(t *testing.T) {
 		"",
 		ErrValidate.Wrap(ErrTokenDecode),
 		func(s *mockService) {
-			s.enc.On("Decode", "").Return("", ErrTokenDecode)
+			s.enc.On("Decode", "").Return(nil, ErrTokenDecode)
 		},
 	}, {
 		"invalid token",
 		"eyJ.eyJj.fxg",
 		ErrValidate.Wrap(ErrTokenDecode),
 		func(s *mockService) {
-			s.enc.On("Decode", "eyJ.eyJj.fxg").Return("", ErrTokenDecode)
+			s.enc.On("Decode", "eyJ.eyJj.fxg").Return(nil, ErrTokenDecode)
 		},
 	}, {
 		"not saved and valid token",
 		mTokenStr,
 		ErrValidate.Wrap(ErrRepositoryNotFound),
 		func(s *mockService) {
-			s.enc.On("Decode", mTokenStr).Return("token123", nil)
-			s.repo.On("FindByID", "token123").Return(nil, ErrRepositoryNotFound)
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
+			s.repo.On("FindByID", mToken.ID).Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"expired token",
+		mTokenStr,
+		ErrExpired.Wrap(ErrTokenExpired),
+		func(s *mockService) {
+			s.enc.On("Decode", mTokenStr).Return(nil, ErrTokenExpired)
+		},
+	}, {
+		"expired saved token",
+		mTokenStr,
+		ErrExpired,
+		func(s *mockService) {
+			expired := *mToken
+			expired.ExpiresAt = time.Now().Add(-time.Minute).UnixNano()
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
+			s.repo.On("FindByID", mToken.ID).Return(&expired, nil)
+		},
+	}, {
+		"token of another user",
+		mTokenStr,
+		ErrValidate.C("id", mToken.ID),
+		func(s *mockService) {
+			other := *mToken
+			other.UserID = "user456"
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
+			s.repo.On("FindByID", mToken.ID).Return(&other, nil)
+		},
+	}, {
+		"token of a revoked session",
+		mTokenStr,
+		ErrRevoked,
+		func(s *mockService) {
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
+			s.repo.On("FindByID", mToken.ID).Return(&mSessionToken, nil)
+			s.repo.On("SessionRevoked", "session123").Return(true, nil)
+		},
+	}, {
+		"unknown revocation",
+		mTokenStr,
+		ErrValidate.Wrap(ErrRepositoryNotFound),
+		func(s *mockService) {
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
+			s.repo.On("FindByID", mToken.ID).Return(&mSessionToken, nil)
+			s.repo.On("SessionRevoked", "session123").Return(false, ErrRepositoryNotFound)
 		},
 	}, {
 		"valid token",
 		mTokenStr,
 		nil,
 		func(s *mockService) {
-			s.enc.On("Decode", mTokenStr).Return(mToken.ID, nil)
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
 			s.repo.On("FindByID", mToken.ID).Return(mToken, nil)
 		},
 	}}
@@ -141,6 +669,7 @@ func TestValidate(t *testing.T) {
 
 func TestInvalidate(t *testing.T) {
 	mToken := models.NewToken("user123")
+	mClaims := NewClaims(mToken, "", "")
 	mTokenStr := "encoded.token"
 
 	tests := []struct {
@@ -153,21 +682,21 @@ func TestInvalidate(t *testing.T) {
 		"",
 		ErrInvalidate.Wrap(ErrValidate.Wrap(ErrTokenDecode)),
 		func(s *mockService) {
-			s.enc.On("Decode", "").Return("", ErrTokenDecode)
+			s.enc.On("Decode", "").Return(nil, ErrTokenDecode)
 		},
 	}, {
 		"invalid tokenStr",
 		"asd.zxc.ey88",
 		ErrInvalidate.Wrap(ErrValidate.Wrap(ErrTokenDecode)),
 		func(s *mockService) {
-			s.enc.On("Decode", "asd.zxc.ey88").Return("", ErrTokenDecode)
+			s.enc.On("Decode", "asd.zxc.ey88").Return(nil, ErrTokenDecode)
 		},
 	}, {
 		"not saved and valid tokenStr",
 		mTokenStr,
 		ErrInvalidate.Wrap(ErrValidate.Wrap(ErrRepositoryNotFound)),
 		func(s *mockService) {
-			s.enc.On("Decode", mTokenStr).Return(mToken.ID, nil)
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
 			s.repo.On("FindByID", mToken.ID).Return(nil, ErrRepositoryNotFound)
 		},
 	}, {
@@ -175,7 +704,7 @@ func TestInvalidate(t *testing.T) {
 		mTokenStr,
 		ErrInvalidate.Wrap(ErrRepositoryDelete),
 		func(s *mockService) {
-			s.enc.On("Decode", mTokenStr).Return(mToken.ID, nil)
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
 			s.repo.On("FindByID", mToken.ID).Return(mToken, nil)
 			s.repo.On("Delete", mToken.ID).Return(ErrRepositoryDelete)
 		},
@@ -184,7 +713,7 @@ func TestInvalidate(t *testing.T) {
 		mTokenStr,
 		nil,
 		func(s *mockService) {
-			s.enc.On("Decode", mTokenStr).Return(mToken.ID, nil)
+			s.enc.On("Decode", mTokenStr).Return(mClaims, nil)
 			s.repo.On("FindByID", mToken.ID).Return(mToken, nil)
 			s.repo.On("Delete", mToken.ID).Return(nil)
 		},
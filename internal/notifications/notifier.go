@@ -0,0 +1,150 @@
+package notifications
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/mailer"
+)
+
+// Errors
+var (
+	ErrConsume      = errors.Internal.New("notifications.consume")
+	ErrInvalidEvent = errors.Internal.New("notifications.invalid_event")
+)
+
+// Interfaces
+type Notifier interface {
+	// Start consumes user events in the background and mails the users
+	// about the ones they must hear of.
+	Start() error
+}
+
+// Implementations
+type notifier struct {
+	events events.Manager
+	mailer mailer.Mailer
+
+	from            string
+	locale          string
+	verificationURI string
+	resetURI        string
+	magicLinkURI    string
+}
+
+func NewNotifier(events events.Manager, m mailer.Mailer) Notifier {
+	c := config.Get()
+	return &notifier{
+		events: events,
+		mailer: m,
+
+		from:            c.MailFrom,
+		locale:          c.MailLocale,
+		verificationURI: c.EmailVerificationURI,
+		resetURI:        c.PasswordResetURI,
+		magicLinkURI:    c.MagicLinkURI,
+	}
+}
+
+// Start shares the notifications queue with other instances, so each event
+// is mailed once. Messages are acknowledged even when sending fails: a
+// broken message would otherwise block the queue, and users can ask again.
+func (n *notifier) Start() error {
+	msgs, err := n.events.Consume(&events.Options{
+		Exchange: "user",
+		Route:    "user.*",
+		Queue:    "notifications",
+	})
+	if err != nil {
+		return ErrConsume.Wrap(err)
+	}
+
+	go func() {
+		for msg := range msgs {
+			if err := n.handle(msg); err != nil {
+				log.Printf("notifications: %s", err)
+			}
+			msg.Ack()
+		}
+	}()
+
+	return nil
+}
+
+// handle mails the user of msg, if its event is one to tell them about.
+func (n *notifier) handle(msg events.Message) error {
+	var e users.TokenEvent
+	if err := json.Unmarshal(msg.Body(), &e); err != nil {
+		return ErrInvalidEvent.Wrap(err)
+	}
+
+	var name, uri string
+	switch e.Type {
+	case "VerificationRequested":
+		name, uri = VerifyEmail, n.verificationURI
+	case "PasswordResetRequested":
+		name, uri = ResetPassword, n.resetURI
+	case "PasswordReset":
+		name = PasswordChanged
+	case "MagicLinkRequested":
+		name, uri = MagicLink, n.magicLinkURI
+	default:
+		return nil
+	}
+
+	if e.User == nil || e.User.Email == "" {
+		return ErrInvalidEvent.C("type", e.Type)
+	}
+
+	data := &Data{
+		Name:     e.User.Name,
+		Username: e.User.Username,
+		Email:    e.User.Email,
+	}
+	if uri != "" {
+		link, err := withToken(uri, e.Token)
+		if err != nil {
+			return ErrInvalidEvent.C("type", e.Type).Wrap(err)
+		}
+		data.URL = link
+	}
+
+	locale := e.User.Locale
+	if locale == "" {
+		locale = n.locale
+	}
+
+	mail, err := Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+
+	return n.mailer.Send(&mailer.Message{
+		From:    n.from,
+		To:      []string{e.User.Email},
+		Subject: mail.Subject,
+		Text:    mail.Text,
+		HTML:    mail.HTML,
+	})
+}
+
+// withToken adds token to the query of uri.
+func withToken(uri, token string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidEvent.M("missing token")
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,181 @@
+package notifications
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/mocks"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/mailer"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockMessage struct {
+	body  []byte
+	acked chan struct{}
+}
+
+func newMockMessage(t *testing.T, v interface{}) *mockMessage {
+	b, err := json.Marshal(v)
+	require.Nil(t, err)
+	return &mockMessage{body: b, acked: make(chan struct{}, 1)}
+}
+
+func (m *mockMessage) Body() []byte {
+	return m.body
+}
+
+func (m *mockMessage) Event() events.Event {
+	var e events.Event
+	json.Unmarshal(m.body, &e)
+	return e
+}
+
+func (m *mockMessage) Ack() {
+	m.acked <- struct{}{}
+}
+
+func mockUser() *models.User {
+	user := models.NewUser()
+	user.Username = "user"
+	user.Email = "user@user.com"
+	user.Name = "Name"
+	user.Lastname = "Lastname"
+	return user
+}
+
+func newTestNotifier() (*notifier, *mailer.InMemory) {
+	m := mailer.NewInMemory()
+	return &notifier{
+		events: mocks.NewMockEventManager(),
+		mailer: m,
+
+		from:            "Big Brother <no-reply@localhost>",
+		locale:          "es",
+		verificationURI: "https://example.com/verify-email",
+		resetURI:        "https://example.com/reset-password?lang=es",
+		magicLinkURI:    "https://example.com/magic-link",
+	}, m
+}
+
+func TestHandle(t *testing.T) {
+	mUser := mockUser()
+	enUser := mockUser()
+	enUser.Locale = "en-US"
+
+	tests := []struct {
+		name    string
+		event   interface{}
+		err     error
+		subject string
+		url     string
+	}{{
+		"verification",
+		users.NewTokenEvent(mUser, "VerificationRequested", "verification.token"),
+		nil,
+		"Verificá tu email",
+		"https://example.com/verify-email?token=verification.token",
+	}, {
+		"password reset",
+		users.NewTokenEvent(mUser, "PasswordResetRequested", "reset+token"),
+		nil,
+		"Restablecé tu contraseña",
+		"https://example.com/reset-password?lang=es&token=reset%2Btoken",
+	}, {
+		"password changed",
+		users.NewUserEvent(mUser, "PasswordReset"),
+		nil,
+		"Tu contraseña cambió",
+		"",
+	}, {
+		"magic link",
+		users.NewTokenEvent(mUser, "MagicLinkRequested", "magic.token"),
+		nil,
+		"Tu enlace para ingresar",
+		"https://example.com/magic-link?token=magic.token",
+	}, {
+		"locale of the user",
+		users.NewTokenEvent(enUser, "VerificationRequested", "verification.token"),
+		nil,
+		"Verify your email",
+		"https://example.com/verify-email?token=verification.token",
+	}, {
+		"event users do not hear of",
+		users.NewUserEvent(mUser, "UserUpdated"),
+		nil,
+		"",
+		"",
+	}, {
+		"token missing",
+		users.NewUserEvent(mUser, "VerificationRequested"),
+		ErrInvalidEvent,
+		"",
+		"",
+	}, {
+		"user missing",
+		users.NewTokenEvent(nil, "PasswordResetRequested", "reset.token"),
+		ErrInvalidEvent,
+		"",
+		"",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			n, m := newTestNotifier()
+
+			err := n.handle(newMockMessage(t, test.event))
+
+			if test.err != nil {
+				errors.Assert(t, test.err, err)
+			} else {
+				assert.Nil(err)
+			}
+
+			messages := m.Messages()
+			if test.subject == "" {
+				assert.Empty(messages)
+				return
+			}
+			require.Len(t, messages, 1)
+			assert.Equal([]string{mUser.Email}, messages[0].To)
+			assert.Equal(n.from, messages[0].From)
+			assert.Equal(test.subject, messages[0].Subject)
+			if test.url != "" {
+				assert.Contains(messages[0].Text, test.url)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	n, m := newTestNotifier()
+	msgs := make(chan events.Message)
+	n.events.(*mocks.MockEventManager).On("Consume", &events.Options{
+		Exchange: "user",
+		Route:    "user.*",
+		Queue:    "notifications",
+	}).Return((<-chan events.Message)(msgs), nil)
+
+	require.Nil(t, n.Start())
+
+	invalid := &mockMessage{body: []byte("not json"), acked: make(chan struct{}, 1)}
+	msgs <- invalid
+	verification := newMockMessage(t, users.NewTokenEvent(mockUser(), "VerificationRequested", "token"))
+	msgs <- verification
+	close(msgs)
+
+	for _, msg := range []*mockMessage{invalid, verification} {
+		select {
+		case <-msg.acked:
+		case <-time.After(time.Second):
+			t.Fatal("message not acknowledged")
+		}
+	}
+	assert.Len(t, m.Messages(), 1)
+}
@@ -0,0 +1,246 @@
+package notifications
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+// Templates of every transactional message.
+const (
+	VerifyEmail     = "verify_email"
+	ResetPassword   = "reset_password"
+	PasswordChanged = "password_changed"
+	MagicLink       = "magic_link"
+)
+
+// DefaultLocale has every template, so it is the last fallback.
+const DefaultLocale = "en"
+
+// Errors
+var (
+	ErrTemplateNotFound = errors.Internal.New("notifications.template_not_found")
+	ErrRender           = errors.Internal.New("notifications.render")
+)
+
+// Data is what templates can show.
+type Data struct {
+	Name     string
+	Username string
+	Email    string
+	// URL is the link the message asks to follow, if any.
+	URL string
+}
+
+// Mail is a rendered template.
+type Mail struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type source struct {
+	subject string
+	text    string
+	html    string
+}
+
+// sources are the templates by locale and name.
+var sources = map[string]map[string]source{
+	"en": {
+		VerifyEmail: {
+			subject: "Verify your email",
+			text: `Hi {{.Name}},
+
+Please confirm {{.Email}} is your email address by following this link:
+
+{{.URL}}
+
+The link expires in 24 hours. If you did not create an account, ignore this message.
+`,
+			html: `<p>Hi {{.Name}},</p>
+<p>Please confirm {{.Email}} is your email address by following this link:</p>
+<p><a href="{{.URL}}">Verify email</a></p>
+<p>The link expires in 24 hours. If you did not create an account, ignore this message.</p>
+`,
+		},
+		ResetPassword: {
+			subject: "Reset your password",
+			text: `Hi {{.Name}},
+
+Someone asked to reset the password of {{.Username}}. To choose a new one, follow this link:
+
+{{.URL}}
+
+The link expires in one hour and works once. If you did not ask for it, ignore this message: your password has not changed.
+`,
+			html: `<p>Hi {{.Name}},</p>
+<p>Someone asked to reset the password of {{.Username}}. To choose a new one, follow this link:</p>
+<p><a href="{{.URL}}">Reset password</a></p>
+<p>The link expires in one hour and works once. If you did not ask for it, ignore this message: your password has not changed.</p>
+`,
+		},
+		PasswordChanged: {
+			subject: "Your password was changed",
+			text: `Hi {{.Name}},
+
+The password of {{.Username}} was just reset and every session was logged out.
+
+If it was not you, reset your password again right away.
+`,
+			html: `<p>Hi {{.Name}},</p>
+<p>The password of {{.Username}} was just reset and every session was logged out.</p>
+<p>If it was not you, reset your password again right away.</p>
+`,
+		},
+		MagicLink: {
+			subject: "Your login link",
+			text: `Hi {{.Name}},
+
+To log in as {{.Username}}, follow this link:
+
+{{.URL}}
+
+The link expires in 15 minutes and works once. If you did not ask for it, ignore this message.
+`,
+			html: `<p>Hi {{.Name}},</p>
+<p>To log in as {{.Username}}, follow this link:</p>
+<p><a href="{{.URL}}">Log in</a></p>
+<p>The link expires in 15 minutes and works once. If you did not ask for it, ignore this message.</p>
+`,
+		},
+	},
+	"es": {
+		VerifyEmail: {
+			subject: "Verificá tu email",
+			text: `Hola {{.Name}},
+
+Confirmá que {{.Email}} es tu dirección de email siguiendo este enlace:
+
+{{.URL}}
+
+El enlace vence en 24 horas. Si no creaste una cuenta, ignorá este mensaje.
+`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Confirmá que {{.Email}} es tu dirección de email siguiendo este enlace:</p>
+<p><a href="{{.URL}}">Verificar email</a></p>
+<p>El enlace vence en 24 horas. Si no creaste una cuenta, ignorá este mensaje.</p>
+`,
+		},
+		ResetPassword: {
+			subject: "Restablecé tu contraseña",
+			text: `Hola {{.Name}},
+
+Alguien pidió restablecer la contraseña de {{.Username}}. Para elegir una nueva, seguí este enlace:
+
+{{.URL}}
+
+El enlace vence en una hora y funciona una sola vez. Si no lo pediste, ignorá este mensaje: tu contraseña no cambió.
+`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Alguien pidió restablecer la contraseña de {{.Username}}. Para elegir una nueva, seguí este enlace:</p>
+<p><a href="{{.URL}}">Restablecer contraseña</a></p>
+<p>El enlace vence en una hora y funciona una sola vez. Si no lo pediste, ignorá este mensaje: tu contraseña no cambió.</p>
+`,
+		},
+		PasswordChanged: {
+			subject: "Tu contraseña cambió",
+			text: `Hola {{.Name}},
+
+La contraseña de {{.Username}} se acaba de restablecer y se cerraron todas las sesiones.
+
+Si no fuiste vos, restablecé tu contraseña de nuevo cuanto antes.
+`,
+			html: `<p>Hola {{.Name}},</p>
+<p>La contraseña de {{.Username}} se acaba de restablecer y se cerraron todas las sesiones.</p>
+<p>Si no fuiste vos, restablecé tu contraseña de nuevo cuanto antes.</p>
+`,
+		},
+		MagicLink: {
+			subject: "Tu enlace para ingresar",
+			text: `Hola {{.Name}},
+
+Para ingresar como {{.Username}}, seguí este enlace:
+
+{{.URL}}
+
+El enlace vence en 15 minutos y funciona una sola vez. Si no lo pediste, ignorá este mensaje.
+`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Para ingresar como {{.Username}}, seguí este enlace:</p>
+<p><a href="{{.URL}}">Ingresar</a></p>
+<p>El enlace vence en 15 minutos y funciona una sola vez. Si no lo pediste, ignorá este mensaje.</p>
+`,
+		},
+	},
+}
+
+type compiled struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// templates are parsed once: a broken source panics on start up.
+var templates = compile()
+
+func compile() map[string]map[string]*compiled {
+	all := make(map[string]map[string]*compiled, len(sources))
+	for locale, byName := range sources {
+		all[locale] = make(map[string]*compiled, len(byName))
+		for name, src := range byName {
+			id := locale + "/" + name
+			all[locale][name] = &compiled{
+				subject: template.Must(template.New(id + "/subject").Parse(src.subject)),
+				text:    template.Must(template.New(id + "/text").Parse(src.text)),
+				html:    htmltemplate.Must(htmltemplate.New(id + "/html").Parse(src.html)),
+			}
+		}
+	}
+	return all
+}
+
+// Render renders the name template in locale. A regional locale like es-AR
+// falls back to its language and then to DefaultLocale.
+func Render(name, locale string, data *Data) (*Mail, error) {
+	t := lookup(name, locale)
+	if t == nil {
+		return nil, ErrTemplateNotFound.C("name", name).C("locale", locale)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, ErrRender.C("name", name).Wrap(err)
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, ErrRender.C("name", name).Wrap(err)
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, ErrRender.C("name", name).Wrap(err)
+	}
+
+	return &Mail{
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func lookup(name, locale string) *compiled {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	candidates := []string{locale}
+	if i := strings.Index(locale, "-"); i > 0 {
+		candidates = append(candidates, locale[:i])
+	}
+	candidates = append(candidates, DefaultLocale)
+
+	for _, l := range candidates {
+		if t, ok := templates[l][name]; ok {
+			return t
+		}
+	}
+	return nil
+}
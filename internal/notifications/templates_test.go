@@ -0,0 +1,60 @@
+package notifications
+
+import (
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates(t *testing.T) {
+	// Every locale translates every template
+	for name := range sources[DefaultLocale] {
+		for locale := range sources {
+			t.Run(locale+"/"+name, func(t *testing.T) {
+				_, ok := sources[locale][name]
+				assert.True(t, ok)
+
+				mail, err := Render(name, locale, &Data{
+					Name:     "Ada",
+					Username: "ada",
+					Email:    "ada@example.com",
+					URL:      "https://example.com/x?token=abc&b=<c>",
+				})
+				require.Nil(t, err)
+				assert.NotEmpty(t, mail.Subject)
+				assert.Contains(t, mail.Text, "Ada")
+				assert.Contains(t, mail.HTML, "Ada")
+				if name != PasswordChanged {
+					assert.Contains(t, mail.Text, "https://example.com/x?token=abc&b=<c>")
+					assert.NotContains(t, mail.HTML, "<c>")
+				}
+			})
+		}
+	}
+}
+
+func TestRenderLocale(t *testing.T) {
+	tests := []struct {
+		locale  string
+		subject string
+	}{
+		{"es", "Verificá tu email"},
+		{"es-AR", "Verificá tu email"},
+		{"es_ar", "Verificá tu email"},
+		{"fr", "Verify your email"},
+		{"", "Verify your email"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.locale, func(t *testing.T) {
+			mail, err := Render(VerifyEmail, test.locale, &Data{})
+			require.Nil(t, err)
+			assert.Equal(t, test.subject, mail.Subject)
+		})
+	}
+
+	_, err := Render("unknown", "en", &Data{})
+	errors.Assert(t, ErrTemplateNotFound, err)
+}
@@ -0,0 +1,289 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// codeTTL is how long an authorization code can be exchanged (RFC 6749
+// recommends at most 10 minutes).
+const codeTTL = time.Minute
+
+// pkcePattern matches both code verifiers and S256 challenges (RFC 7636).
+var pkcePattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+}
+
+// Authorization is a request to be shown to the user for consent.
+type Authorization struct {
+	Client        *models.Client
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+	Nonce         string
+}
+
+// RedirectURL adds params and the state to the redirect URI.
+func (a *Authorization) RedirectURL(params url.Values) string {
+	u, err := url.Parse(a.RedirectURI)
+	if err != nil {
+		return a.RedirectURI
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if a.State != "" {
+		query.Set("state", a.State)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// ValidateAuthorization requires PKCE with S256 from every client, and an
+// exact match of a registered redirect URI.
+func (s *service) ValidateAuthorization(req *AuthorizeRequest) (*Authorization, error) {
+	if req.ClientID == "" {
+		return nil, ErrInvalidRequest.F("client_id", "required")
+	}
+	client, err := s.repo.FindClient(req.ClientID)
+	if err != nil {
+		return nil, ErrInvalidClient.C("id", req.ClientID).Wrap(err)
+	}
+	if req.RedirectURI == "" || !client.HasRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidRequest.F("redirect_uri", "invalid")
+	}
+
+	authz := &Authorization{
+		Client:        client,
+		RedirectURI:   req.RedirectURI,
+		State:         req.State,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+	}
+
+	if req.ResponseType != "code" {
+		return authz, ErrUnsupportedResponseType
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return authz, ErrInvalidRequest.F("code_challenge_method", "invalid").M("PKCE with S256 is required")
+	}
+	if !pkcePattern.MatchString(req.CodeChallenge) {
+		return authz, ErrInvalidRequest.F("code_challenge", "invalid")
+	}
+
+	scope, err := grantedScope(client, req.Scope)
+	if err != nil {
+		return authz, err
+	}
+	authz.Scope = scope
+
+	return authz, nil
+}
+
+func (s *service) Authorize(authz *Authorization, token *models.Token) (string, error) {
+	if token == nil || token.ClientID != "" {
+		return "", ErrAccessDenied.M("authorization requires a user login")
+	}
+
+	code, err := newSecret()
+	if err != nil {
+		return "", ErrServerError.Wrap(err)
+	}
+
+	authCode := models.NewAuthorizationCode(code, codeTTL)
+	authCode.ClientID = authz.Client.ID
+	authCode.UserID = token.UserID
+	authCode.Role = token.Role
+	authCode.RedirectURI = authz.RedirectURI
+	authCode.Scope = authz.Scope
+	authCode.CodeChallenge = authz.CodeChallenge
+	authCode.Nonce = authz.Nonce
+
+	if err := s.repo.InsertCode(authCode); err != nil {
+		return "", ErrServerError.Wrap(err)
+	}
+
+	return code, nil
+}
+
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Scope        string `form:"scope"`
+
+	IP        string `form:"-"`
+	UserAgent string `form:"-"`
+}
+
+func (s *service) Token(client *models.Client, req *TokenRequest) (*auth.TokenPair, error) {
+	switch req.GrantType {
+	case "authorization_code":
+		return s.exchangeCode(client, req)
+	case "refresh_token":
+		return s.refresh(client, req)
+	case "client_credentials":
+		return s.clientCredentials(client, req)
+	case DeviceCodeGrant:
+		return s.pollDevice(client, req)
+	case "":
+		return nil, ErrInvalidRequest.F("grant_type", "required")
+	}
+
+	return nil, ErrUnsupportedGrantType.C("grant_type", req.GrantType)
+}
+
+// exchangeCode takes the code out of the repository before checking it, so
+// it can only be tried once, even by concurrent requests.
+func (s *service) exchangeCode(client *models.Client, req *TokenRequest) (*auth.TokenPair, error) {
+	if req.Code == "" {
+		return nil, ErrInvalidRequest.F("code", "required")
+	}
+
+	code, err := s.repo.TakeCode(req.Code)
+	if err != nil {
+		return nil, ErrInvalidGrant.Wrap(err)
+	}
+
+	if code.Expired() || code.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+	if code.RedirectURI != req.RedirectURI {
+		return nil, ErrInvalidGrant.M("redirect_uri does not match")
+	}
+	if !verifyChallenge(code.CodeChallenge, req.CodeVerifier) {
+		return nil, ErrInvalidGrant.M("invalid code_verifier")
+	}
+
+	var idToken string
+	if auth.HasScope(code.Scope, ScopeOpenID) {
+		if idToken, err = s.idToken(code.UserID, client.ID, code.Scope, code.Nonce); err != nil {
+			return nil, ErrServerError.Wrap(err)
+		}
+	}
+
+	pair, err := s.authServ.Create(&auth.CreateRequest{
+		UserID:    code.UserID,
+		Role:      code.Role,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+		ClientID:  client.ID,
+		Scope:     code.Scope,
+	})
+	if err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+	pair.IDToken = idToken
+
+	return pair, nil
+}
+
+func (s *service) refresh(client *models.Client, req *TokenRequest) (*auth.TokenPair, error) {
+	if req.RefreshToken == "" {
+		return nil, ErrInvalidRequest.F("refresh_token", "required")
+	}
+
+	pair, err := s.authServ.Refresh(&auth.RefreshRequest{
+		RefreshToken: req.RefreshToken,
+		ClientID:     client.ID,
+	})
+	if err != nil {
+		return nil, ErrInvalidGrant.Wrap(err)
+	}
+
+	return pair, nil
+}
+
+// clientCredentials issues a machine token for the client itself (RFC 6749
+// 4.4), without refresh token. Public clients cannot keep a secret, so
+// they cannot use it.
+func (s *service) clientCredentials(client *models.Client, req *TokenRequest) (*auth.TokenPair, error) {
+	if client.Public {
+		return nil, ErrUnauthorizedClient.M("public clients cannot use client_credentials")
+	}
+
+	scope, err := grantedScope(client, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := s.authServ.Create(&auth.CreateRequest{
+		ClientID: client.ID,
+		Scope:    scope,
+		Machine:  true,
+	})
+	if err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+
+	return pair, nil
+}
+
+// grantedScope checks the requested scope against the client's. An empty
+// request grants every scope of the client.
+func grantedScope(client *models.Client, requested string) (string, error) {
+	if requested == "" {
+		return strings.Join(client.Scopes, " "), nil
+	}
+
+	scopes := strings.Fields(requested)
+	for _, scope := range scopes {
+		if !client.HasScope(scope) {
+			return "", ErrInvalidScope.C("scope", scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+func verifyChallenge(challenge, verifier string) bool {
+	if !pkcePattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// validRedirectURI accepts absolute URIs without fragment. Plain http is only
+// allowed for loopback addresses, and the only other schemes are the
+// private-use ones of native apps, which are reverse domain names and so
+// contain a dot (RFC 8252 §7). Schemes like javascript: or data: are not.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || strings.Contains(uri, "#") {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return strings.Contains(u.Scheme, ".")
+}
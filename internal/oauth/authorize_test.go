@@ -0,0 +1,401 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func challengeOf(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func mockPublicClient() *models.Client {
+	client := models.NewClient()
+	client.Name = "SPA"
+	client.Public = true
+	client.RedirectURIs = []string{"https://app.example.com/callback", "com.example.app:/callback"}
+	client.Scopes = []string{"openid", "profile", "email"}
+	return client
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"https://app.example.com/callback?from=login", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://[::1]:8080/callback", true},
+		{"com.example.app:/callback", true},
+		{"http://app.example.com/callback", false},
+		{"javascript:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"file:///etc/passwd", false},
+		{"vbscript:msgbox(1)", false},
+		{"myapp:/callback", false},
+		{"https://app.example.com/callback#fragment", false},
+		{"https:///callback", false},
+		{"/callback", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.valid, validRedirectURI(test.uri), test.uri)
+	}
+}
+
+func TestValidateAuthorization(t *testing.T) {
+	mClient := mockPublicClient()
+	challenge := challengeOf(mVerifier)
+
+	genReq := func(cb func(req *AuthorizeRequest)) *AuthorizeRequest {
+		req := &AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            mClient.ID,
+			RedirectURI:         "https://app.example.com/callback",
+			State:               "xyz",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+		}
+		if cb != nil {
+			cb(req)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		req      *AuthorizeRequest
+		err      error
+		redirect bool
+		scope    string
+	}{{
+		"unknown client",
+		genReq(func(req *AuthorizeRequest) { req.ClientID = "unknown" }),
+		ErrInvalidClient.C("id", "unknown").Wrap(ErrRepositoryNotFound),
+		false, "",
+	}, {
+		"unregistered redirect URI",
+		genReq(func(req *AuthorizeRequest) { req.RedirectURI = "https://app.example.com/callback/" }),
+		ErrInvalidRequest.F("redirect_uri", "invalid"),
+		false, "",
+	}, {
+		"missing redirect URI",
+		genReq(func(req *AuthorizeRequest) { req.RedirectURI = "" }),
+		ErrInvalidRequest.F("redirect_uri", "invalid"),
+		false, "",
+	}, {
+		"unsupported response type",
+		genReq(func(req *AuthorizeRequest) { req.ResponseType = "token" }),
+		ErrUnsupportedResponseType,
+		true, "",
+	}, {
+		"without PKCE",
+		genReq(func(req *AuthorizeRequest) {
+			req.CodeChallenge = ""
+			req.CodeChallengeMethod = ""
+		}),
+		ErrInvalidRequest.F("code_challenge_method", "invalid"),
+		true, "",
+	}, {
+		"plain PKCE",
+		genReq(func(req *AuthorizeRequest) { req.CodeChallengeMethod = "plain" }),
+		ErrInvalidRequest.F("code_challenge_method", "invalid"),
+		true, "",
+	}, {
+		"short challenge",
+		genReq(func(req *AuthorizeRequest) { req.CodeChallenge = "abc" }),
+		ErrInvalidRequest.F("code_challenge", "invalid"),
+		true, "",
+	}, {
+		"scope not allowed",
+		genReq(func(req *AuthorizeRequest) { req.Scope = "openid admin" }),
+		ErrInvalidScope.C("scope", "admin"),
+		true, "",
+	}, {
+		"default scope",
+		genReq(nil),
+		nil,
+		false, "openid profile email",
+	}, {
+		"requested scope",
+		genReq(func(req *AuthorizeRequest) { req.Scope = "openid" }),
+		nil,
+		false, "openid",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := newMockService()
+			require.Nil(t, serv.repo.InsertClient(mClient))
+
+			authz, err := serv.ValidateAuthorization(test.req)
+
+			if test.err != nil {
+				if assert.NotNil(err) {
+					assert.True(err.(errors.Error).Equals(test.err.(errors.Error)), err.Error())
+					assert.Equal(test.err.(errors.Error).Fields, err.(errors.Error).Fields)
+				}
+				if test.redirect {
+					if assert.NotNil(authz) {
+						redirect, _ := url.Parse(authz.RedirectURL(url.Values{"error": {"e"}}))
+						assert.Equal("app.example.com", redirect.Host)
+						assert.Equal("xyz", redirect.Query().Get("state"))
+					}
+				} else {
+					assert.Nil(authz)
+				}
+			} else {
+				assert.Nil(err)
+				if assert.NotNil(authz) {
+					assert.Equal(mClient.ID, authz.Client.ID)
+					assert.Equal(test.scope, authz.Scope)
+					assert.Equal(challenge, authz.CodeChallenge)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	enc, err := auth.NewEncoder()
+	require.Nil(t, err)
+	authServ := auth.NewService(auth.NewRepository(cache.NewInMemory("auth")), enc)
+
+	serv := newMockService()
+	serv.service.authServ = authServ
+	serv.service.enc = enc
+	serv.usersServ.On("GetEnabled", "user123").Return(mockUser(), nil)
+
+	mClient := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(mClient))
+	other := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(other))
+
+	login, err := authServ.Create(&auth.CreateRequest{UserID: "user123", Role: models.USER})
+	require.Nil(t, err)
+	user, err := authServ.Validate(login.AccessToken)
+	require.Nil(t, err)
+
+	authorize := func(t *testing.T) string {
+		authz, err := serv.ValidateAuthorization(&AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            mClient.ID,
+			RedirectURI:         "https://app.example.com/callback",
+			Scope:               "openid",
+			CodeChallenge:       challengeOf(mVerifier),
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, err)
+		code, err := serv.Authorize(authz, user)
+		require.Nil(t, err)
+		return code
+	}
+	exchange := func(client *models.Client, code, redirectURI, verifier string) (*auth.TokenPair, error) {
+		return serv.Token(client, &TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         code,
+			RedirectURI:  redirectURI,
+			CodeVerifier: verifier,
+		})
+	}
+
+	t.Run("wrong verifier consumes the code", func(t *testing.T) {
+		code := authorize(t)
+
+		_, err := exchange(mClient, code, "https://app.example.com/callback", strings.Repeat("a", 43))
+		errors.Assert(t, ErrInvalidGrant, err)
+
+		_, err = exchange(mClient, code, "https://app.example.com/callback", mVerifier)
+		errors.Assert(t, ErrInvalidGrant, err)
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		_, err := exchange(other, authorize(t), "https://app.example.com/callback", mVerifier)
+		errors.Assert(t, ErrInvalidGrant, err)
+	})
+
+	t.Run("other redirect URI", func(t *testing.T) {
+		_, err := exchange(mClient, authorize(t), "com.example.app:/callback", mVerifier)
+		errors.Assert(t, ErrInvalidGrant, err)
+	})
+
+	t.Run("exchange and refresh", func(t *testing.T) {
+		assert := assert.New(t)
+		code := authorize(t)
+
+		pair, err := exchange(mClient, code, "https://app.example.com/callback", mVerifier)
+		require.Nil(t, err)
+		assert.Equal("openid", pair.Scope)
+		assert.NotEmpty(pair.IDToken)
+
+		token, err := authServ.Validate(pair.AccessToken)
+		require.Nil(t, err)
+		assert.Equal("user123", token.UserID)
+		assert.Equal(mClient.ID, token.ClientID)
+		assert.Equal("openid", token.Scope)
+
+		// Codes are single use
+		_, err = exchange(mClient, code, "https://app.example.com/callback", mVerifier)
+		errors.Assert(t, ErrInvalidGrant, err)
+
+		_, err = serv.Token(other, &TokenRequest{GrantType: "refresh_token", RefreshToken: pair.RefreshToken})
+		errors.Assert(t, ErrInvalidGrant, err)
+
+		refreshed, err := serv.Token(mClient, &TokenRequest{GrantType: "refresh_token", RefreshToken: pair.RefreshToken})
+		assert.Nil(err)
+		assert.NotNil(refreshed)
+	})
+
+	t.Run("concurrent exchanges", func(t *testing.T) {
+		code := authorize(t)
+
+		const n = 10
+		errs := make(chan error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := exchange(mClient, code, "https://app.example.com/callback", mVerifier)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		exchanged := 0
+		for err := range errs {
+			if err == nil {
+				exchanged++
+				continue
+			}
+			errors.Assert(t, ErrInvalidGrant, err)
+		}
+		assert.Equal(t, 1, exchanged)
+	})
+
+	t.Run("tokens of clients cannot authorize", func(t *testing.T) {
+		code := authorize(t)
+		pair, err := exchange(mClient, code, "https://app.example.com/callback", mVerifier)
+		require.Nil(t, err)
+		clientToken, err := authServ.Validate(pair.AccessToken)
+		require.Nil(t, err)
+
+		authz, err := serv.ValidateAuthorization(&AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            other.ID,
+			RedirectURI:         "https://app.example.com/callback",
+			CodeChallenge:       challengeOf(mVerifier),
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, err)
+		_, err = serv.Authorize(authz, clientToken)
+		errors.Assert(t, ErrAccessDenied, err)
+	})
+
+	t.Run("unsupported grant type", func(t *testing.T) {
+		_, err := serv.Token(mClient, &TokenRequest{GrantType: "password"})
+		errors.Assert(t, ErrUnsupportedGrantType, err)
+	})
+}
+
+func TestClientCredentials(t *testing.T) {
+	enc, err := auth.NewEncoder()
+	require.Nil(t, err)
+	authServ := auth.NewService(auth.NewRepository(cache.NewInMemory("auth")), enc)
+
+	serv := newMockService()
+	serv.service.authServ = authServ
+
+	mClient := models.NewClient()
+	mClient.Name = "Worker"
+	mClient.Scopes = []string{"users:read", "users:write"}
+
+	tests := []struct {
+		name   string
+		client *models.Client
+		scope  string
+		err    error
+	}{{
+		"public client",
+		mockPublicClient(),
+		"",
+		ErrUnauthorizedClient,
+	}, {
+		"scope not allowed",
+		mClient,
+		"users:read admin",
+		ErrInvalidScope,
+	}, {
+		"every scope",
+		mClient,
+		"",
+		nil,
+	}, {
+		"requested scope",
+		mClient,
+		"users:read",
+		nil,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			pair, err := serv.Token(test.client, &TokenRequest{
+				GrantType: "client_credentials",
+				Scope:     test.scope,
+			})
+
+			if test.err != nil {
+				errors.Assert(t, test.err, err)
+				assert.Nil(pair)
+				return
+			}
+
+			require.Nil(t, err)
+			assert.Empty(pair.RefreshToken)
+			expectedScope := test.scope
+			if expectedScope == "" {
+				expectedScope = "users:read users:write"
+			}
+			assert.Equal(expectedScope, pair.Scope)
+
+			claims, err := enc.Decode(pair.AccessToken)
+			require.Nil(t, err)
+			assert.True(claims.Machine)
+			assert.Equal(mClient.ID, claims.UserID())
+			assert.Equal(mClient.ID, claims.ClientID)
+			assert.Equal(expectedScope, claims.Scope)
+			assert.Empty(claims.Role)
+
+			token, err := authServ.Validate(pair.AccessToken)
+			require.Nil(t, err)
+			assert.True(token.Machine)
+			assert.Empty(token.SessionID)
+
+			introspection := serv.Introspect(pair.AccessToken)
+			assert.True(introspection.Active)
+			assert.True(introspection.Machine)
+			assert.Equal(mClient.ID, introspection.Sub)
+		})
+	}
+}
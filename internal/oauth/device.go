@@ -0,0 +1,283 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// DeviceCodeGrant is the grant type devices poll the token endpoint with.
+const DeviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// deviceCodeTTL is how long the user has to enter the code.
+	deviceCodeTTL = 10 * time.Minute
+	// deviceInterval is the minimum time between polls, increased by the
+	// same amount on every slow_down.
+	deviceInterval = 5 * time.Second
+	// deviceLimit is how many user codes a user may try every deviceWindow,
+	// so that codes cannot be guessed.
+	deviceLimit  = 10
+	deviceWindow = 15 * time.Minute
+)
+
+// userCodeAlphabet has no vowels, so codes do not spell words, and no digits
+// to be confused with letters (RFC 8628 6.1). 8 characters give about 34
+// bits of entropy.
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+type DeviceRequest struct {
+	Scope string `form:"scope"`
+}
+
+// DeviceAuthorization is the RFC 8628 3.2 response. The device shows the
+// user code and verification URI, and polls with the device code.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// Device is a pending device authorization to be shown to the user.
+type Device struct {
+	Client   *models.Client
+	UserCode string
+	Scope    string
+}
+
+func (s *service) AuthorizeDevice(client *models.Client, req *DeviceRequest) (*DeviceAuthorization, error) {
+	scope, err := grantedScope(client, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := newSecret()
+	if err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+
+	code := models.NewDeviceCode(deviceCode, userCode, deviceCodeTTL, deviceInterval)
+	code.ClientID = client.ID
+	code.Scope = scope
+
+	if err := s.repo.InsertDeviceCode(code); err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         s.verificationURI,
+		VerificationURIComplete: s.verificationURL(formatUserCode(userCode)),
+		ExpiresIn:               int64(deviceCodeTTL / time.Second),
+		Interval:                int64(deviceInterval / time.Second),
+	}, nil
+}
+
+func (s *service) FindDevice(userCode string, token *models.Token) (*Device, error) {
+	if token == nil || token.ClientID != "" {
+		return nil, ErrAccessDenied.M("authorization requires a user login")
+	}
+
+	code, err := s.findPendingDevice(userCode, token)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.repo.FindClient(code.ClientID)
+	if err != nil {
+		return nil, ErrInvalidClient.C("id", code.ClientID).Wrap(err)
+	}
+
+	return &Device{
+		Client:   client,
+		UserCode: formatUserCode(code.UserCode),
+		Scope:    code.Scope,
+	}, nil
+}
+
+func (s *service) ApproveDevice(userCode string, token *models.Token, approve bool) error {
+	if token == nil || token.ClientID != "" {
+		return ErrAccessDenied.M("authorization requires a user login")
+	}
+
+	code, err := s.findPendingDevice(userCode, token)
+	if err != nil {
+		return err
+	}
+
+	if approve {
+		code.Approved = true
+		code.UserID = token.UserID
+		code.Role = token.Role
+	} else {
+		code.Denied = true
+	}
+
+	if err := s.repo.InsertDeviceCode(code); err != nil {
+		return ErrServerError.Wrap(err)
+	}
+	return nil
+}
+
+// findPendingDevice accepts the user code as typed: in any case, with or
+// without separators. Every code tried counts towards the limit of the user
+// behind token.
+func (s *service) findPendingDevice(userCode string, token *models.Token) (*models.DeviceCode, error) {
+	userCode = normalizeUserCode(userCode)
+	if userCode == "" {
+		return nil, ErrInvalidRequest.F("user_code", "required")
+	}
+
+	ok, err := s.deviceLimiter.Allow(token.UserID)
+	if err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+	if !ok {
+		return nil, ErrTooManyRequests.C("user", token.UserID)
+	}
+
+	code, err := s.repo.FindDeviceCodeByUserCode(userCode)
+	if err != nil {
+		return nil, ErrInvalidRequest.F("user_code", "invalid").Wrap(err)
+	}
+	if code.Expired() || !code.Pending() {
+		return nil, ErrInvalidRequest.F("user_code", "invalid")
+	}
+
+	return code, nil
+}
+
+// pollDevice answers authorization_pending until the user decides. A
+// device polling faster than its interval gets slow_down and a longer
+// interval. The code is deleted once the user decides. Polls only write
+// their own state, so they never undo a decision made meanwhile.
+func (s *service) pollDevice(client *models.Client, req *TokenRequest) (*auth.TokenPair, error) {
+	if req.DeviceCode == "" {
+		return nil, ErrInvalidRequest.F("device_code", "required")
+	}
+
+	code, err := s.repo.FindDeviceCode(req.DeviceCode)
+	if err != nil {
+		// The cache drops codes when they expire
+		return nil, ErrExpiredToken.Wrap(err)
+	}
+	if code.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+	if code.Expired() {
+		return nil, ErrExpiredToken
+	}
+
+	if code.Pending() {
+		now := time.Now().UnixNano()
+		tooSoon := now-code.LastPolledAt < int64(code.Interval)
+		code.LastPolledAt = now
+		if tooSoon {
+			code.Interval += deviceInterval
+		}
+		if err := s.repo.UpdateDevicePoll(code); err != nil {
+			return nil, ErrServerError.Wrap(err)
+		}
+
+		if tooSoon {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	}
+
+	// Only one of concurrent polls gets the decided code
+	if code, err = s.repo.TakeDeviceCode(req.DeviceCode); err != nil {
+		return nil, ErrExpiredToken.Wrap(err)
+	}
+	if code.Denied {
+		return nil, ErrAccessDenied
+	}
+
+	var idToken string
+	if auth.HasScope(code.Scope, ScopeOpenID) {
+		if idToken, err = s.idToken(code.UserID, client.ID, code.Scope, ""); err != nil {
+			return nil, ErrServerError.Wrap(err)
+		}
+	}
+
+	pair, err := s.authServ.Create(&auth.CreateRequest{
+		UserID:    code.UserID,
+		Role:      code.Role,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+		ClientID:  client.ID,
+		Scope:     code.Scope,
+	})
+	if err != nil {
+		return nil, ErrServerError.Wrap(err)
+	}
+	pair.IDToken = idToken
+
+	return pair, nil
+}
+
+// verificationURL adds the user code to the verification URI, so the user
+// does not have to type it.
+func (s *service) verificationURL(userCode string) string {
+	u, err := url.Parse(s.verificationURI)
+	if err != nil {
+		return s.verificationURI
+	}
+
+	query := u.Query()
+	query.Set("user_code", userCode)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// newUserCode draws every character uniformly: bytes past the last multiple
+// of the alphabet length are discarded.
+func newUserCode() (string, error) {
+	max := 256 - 256%len(userCodeAlphabet)
+	code := make([]byte, 0, userCodeLength)
+	b := make([]byte, userCodeLength)
+	for len(code) < userCodeLength {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			if int(c) < max && len(code) < userCodeLength {
+				code = append(code, userCodeAlphabet[int(c)%len(userCodeAlphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits the code in two halves: BCDF-GHJK.
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
@@ -0,0 +1,212 @@
+package oauth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserCode(t *testing.T) {
+	assert := assert.New(t)
+
+	code, err := newUserCode()
+	require.Nil(t, err)
+	assert.Len(code, userCodeLength)
+	for _, c := range code {
+		assert.True(strings.ContainsRune(userCodeAlphabet, c), string(c))
+	}
+
+	assert.Equal("BCDF-GHJK", formatUserCode("BCDFGHJK"))
+	assert.Equal("BCDFGHJK", normalizeUserCode("bcdf-ghjk"))
+	assert.Equal("BCDFGHJK", normalizeUserCode(" BCDF GHJK "))
+}
+
+func TestDeviceFlow(t *testing.T) {
+	enc, err := auth.NewEncoder()
+	require.Nil(t, err)
+	authServ := auth.NewService(auth.NewRepository(cache.NewInMemory("auth")), enc)
+
+	serv := newMockService()
+	serv.service.authServ = authServ
+	serv.service.enc = enc
+	serv.service.verificationURI = "https://auth.example.com/device"
+	serv.usersServ.On("GetEnabled", "user123").Return(mockUser(), nil)
+
+	mClient := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(mClient))
+	other := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(other))
+
+	user := &models.Token{UserID: "user123", Role: models.USER}
+
+	start := func(t *testing.T) *DeviceAuthorization {
+		authz, err := serv.AuthorizeDevice(mClient, &DeviceRequest{Scope: "openid profile"})
+		require.Nil(t, err)
+		return authz
+	}
+	poll := func(client *models.Client, deviceCode string) (*auth.TokenPair, error) {
+		return serv.Token(client, &TokenRequest{GrantType: DeviceCodeGrant, DeviceCode: deviceCode})
+	}
+	// rewind pretends the last poll was an interval ago
+	rewind := func(t *testing.T, deviceCode string) {
+		code, err := serv.repo.FindDeviceCode(deviceCode)
+		require.Nil(t, err)
+		code.LastPolledAt -= int64(code.Interval)
+		require.Nil(t, serv.repo.UpdateDevicePoll(code))
+	}
+
+	t.Run("device authorization", func(t *testing.T) {
+		assert := assert.New(t)
+		authz := start(t)
+
+		assert.NotEmpty(authz.DeviceCode)
+		assert.Regexp(`^[A-Z]{4}-[A-Z]{4}$`, authz.UserCode)
+		assert.Equal("https://auth.example.com/device", authz.VerificationURI)
+		assert.Equal("https://auth.example.com/device?user_code="+authz.UserCode, authz.VerificationURIComplete)
+		assert.Equal(int64(600), authz.ExpiresIn)
+		assert.Equal(int64(5), authz.Interval)
+
+		device, err := serv.FindDevice(strings.ToLower(authz.UserCode), user)
+		require.Nil(t, err)
+		assert.Equal(mClient.ID, device.Client.ID)
+		assert.Equal(authz.UserCode, device.UserCode)
+		assert.Equal("openid profile", device.Scope)
+	})
+
+	t.Run("scope not allowed", func(t *testing.T) {
+		_, err := serv.AuthorizeDevice(mClient, &DeviceRequest{Scope: "admin"})
+		errors.Assert(t, ErrInvalidScope, err)
+	})
+
+	t.Run("unknown codes", func(t *testing.T) {
+		_, err := serv.FindDevice("BCDF-GHJK", user)
+		errors.Assert(t, ErrInvalidRequest, err)
+		err = serv.ApproveDevice("BCDF-GHJK", user, true)
+		errors.Assert(t, ErrInvalidRequest, err)
+		_, err = poll(mClient, "unknown")
+		errors.Assert(t, ErrExpiredToken, err)
+	})
+
+	t.Run("user codes tried are limited", func(t *testing.T) {
+		authz := start(t)
+		guesser := &models.Token{UserID: "guesser", Role: models.USER}
+		for i := 0; i < deviceLimit; i++ {
+			_, err := serv.FindDevice("BCDF-GHJK", guesser)
+			errors.Assert(t, ErrInvalidRequest, err)
+		}
+
+		_, err := serv.FindDevice(authz.UserCode, guesser)
+		errors.Assert(t, ErrTooManyRequests, err)
+		err = serv.ApproveDevice(authz.UserCode, guesser, true)
+		errors.Assert(t, ErrTooManyRequests, err)
+
+		// Other users are not
+		_, err = serv.FindDevice(authz.UserCode, user)
+		assert.Nil(t, err)
+	})
+
+	t.Run("tokens of clients cannot look codes up", func(t *testing.T) {
+		authz := start(t)
+		_, err := serv.FindDevice(authz.UserCode, &models.Token{UserID: "user123", ClientID: other.ID})
+		errors.Assert(t, ErrAccessDenied, err)
+	})
+
+	t.Run("pending and slow down", func(t *testing.T) {
+		authz := start(t)
+
+		_, err := poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrAuthorizationPending, err)
+
+		_, err = poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrSlowDown, err)
+
+		code, err := serv.repo.FindDeviceCode(authz.DeviceCode)
+		require.Nil(t, err)
+		assert.Equal(t, 10*time.Second, code.Interval)
+
+		rewind(t, authz.DeviceCode)
+		_, err = poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrAuthorizationPending, err)
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		authz := start(t)
+		_, err := poll(other, authz.DeviceCode)
+		errors.Assert(t, ErrInvalidGrant, err)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		authz := start(t)
+		require.Nil(t, serv.ApproveDevice(authz.UserCode, user, false))
+
+		_, err := poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrAccessDenied, err)
+
+		_, err = poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrExpiredToken, err)
+	})
+
+	t.Run("tokens of clients cannot approve", func(t *testing.T) {
+		authz := start(t)
+		err := serv.ApproveDevice(authz.UserCode, &models.Token{UserID: "user123", ClientID: other.ID}, true)
+		errors.Assert(t, ErrAccessDenied, err)
+	})
+
+	t.Run("approved", func(t *testing.T) {
+		assert := assert.New(t)
+		authz := start(t)
+
+		_, err := poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrAuthorizationPending, err)
+
+		require.Nil(t, serv.ApproveDevice(authz.UserCode, user, true))
+
+		// Decided codes cannot be approved again
+		err = serv.ApproveDevice(authz.UserCode, user, true)
+		errors.Assert(t, ErrInvalidRequest, err)
+
+		rewind(t, authz.DeviceCode)
+		pair, err := poll(mClient, authz.DeviceCode)
+		require.Nil(t, err)
+		assert.Equal("openid profile", pair.Scope)
+		assert.NotEmpty(pair.RefreshToken)
+		assert.NotEmpty(pair.IDToken)
+
+		token, err := authServ.Validate(pair.AccessToken)
+		require.Nil(t, err)
+		assert.Equal("user123", token.UserID)
+		assert.Equal(models.USER, token.Role)
+		assert.Equal(mClient.ID, token.ClientID)
+
+		// Device codes are single use
+		_, err = poll(mClient, authz.DeviceCode)
+		errors.Assert(t, ErrExpiredToken, err)
+	})
+
+	t.Run("poll during approval", func(t *testing.T) {
+		authz := start(t)
+
+		// Read by a poll before the approval, written after it
+		pending, err := serv.repo.FindDeviceCode(authz.DeviceCode)
+		require.Nil(t, err)
+		require.Nil(t, serv.ApproveDevice(authz.UserCode, user, true))
+		pending.LastPolledAt = time.Now().UnixNano()
+		require.Nil(t, serv.repo.UpdateDevicePoll(pending))
+
+		code, err := serv.repo.FindDeviceCode(authz.DeviceCode)
+		require.Nil(t, err)
+		assert.True(t, code.Approved)
+		assert.Equal(t, "user123", code.UserID)
+		assert.Equal(t, pending.LastPolledAt, code.LastPolledAt)
+
+		_, err = poll(mClient, authz.DeviceCode)
+		assert.Nil(t, err)
+	})
+}
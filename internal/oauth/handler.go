@@ -0,0 +1,343 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+const clientContextKey = "oauth.client"
+
+type Handler struct {
+	serv     Service
+	authServ auth.Service
+}
+
+func NewHandler(serv Service, authServ auth.Service) *Handler {
+	return &Handler{
+		serv:     serv,
+		authServ: authServ,
+	}
+}
+
+// Routes registers the OAuth endpoints in r.
+//
+// Consent is shown by a separate front-end where the user is logged in, so
+// /oauth/authorize answers JSON and leaves the redirect to it. The same
+// front-end serves the device verification page on top of /oauth/device.
+func (h *Handler) Routes(r gin.IRouter) {
+	r.GET("/oauth/authorize", h.consent)
+	r.POST("/oauth/authorize", auth.Middleware(h.authServ), auth.RejectAPIKeys(), auth.RequireFirstParty(), h.authorize)
+	r.POST("/oauth/token", h.clientAuth, h.token)
+	r.POST("/oauth/introspect", h.clientAuth, h.confidential, h.introspect)
+
+	r.POST("/oauth/device_authorization", h.clientAuth, h.authorizeDevice)
+	r.GET("/oauth/device", auth.Middleware(h.authServ), auth.RejectAPIKeys(), auth.RequireFirstParty(), h.device)
+	r.POST("/oauth/device", auth.Middleware(h.authServ), auth.RejectAPIKeys(), auth.RequireFirstParty(), h.approveDevice)
+
+	r.GET("/.well-known/openid-configuration", h.discovery)
+	r.GET("/userinfo", auth.Middleware(h.authServ), h.userinfo)
+	r.POST("/userinfo", auth.Middleware(h.authServ), h.userinfo)
+
+	admin := r.Group("/oauth", auth.Middleware(h.authServ), auth.RejectAPIKeys(), auth.RequireFirstParty(), auth.RequireRole(models.ADMIN))
+	admin.POST("/clients", h.registerClient)
+}
+
+type registerClientResponse struct {
+	*models.Client
+	Secret string `json:"secret"`
+}
+
+func (h *Handler) registerClient(c *gin.Context) {
+	var req RegisterClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	client, secret, err := h.serv.RegisterClient(&req)
+	if err != nil {
+		Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, &registerClientResponse{client, secret})
+}
+
+type ConsentResponse struct {
+	ClientID    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+}
+
+type RedirectResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// consent describes the authorization request to ask the user for consent.
+func (h *Handler) consent(c *gin.Context) {
+	authz, ok := h.validateAuthorization(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, &ConsentResponse{
+		ClientID:    authz.Client.ID,
+		ClientName:  authz.Client.Name,
+		RedirectURI: authz.RedirectURI,
+		Scopes:      strings.Fields(authz.Scope),
+	})
+}
+
+// authorize records the decision of the logged-in user, sent as approve.
+func (h *Handler) authorize(c *gin.Context) {
+	authz, ok := h.validateAuthorization(c)
+	if !ok {
+		return
+	}
+
+	if c.PostForm("approve") != "true" {
+		h.redirect(c, authz, ErrAccessDenied)
+		return
+	}
+
+	code, err := h.serv.Authorize(authz, auth.Token(c))
+	if err != nil {
+		h.redirect(c, authz, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, &RedirectResponse{
+		RedirectTo: authz.RedirectURL(url.Values{"code": {code}}),
+	})
+}
+
+// validateAuthorization renders the error itself when it returns false.
+func (h *Handler) validateAuthorization(c *gin.Context) (*Authorization, bool) {
+	var req AuthorizeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return nil, false
+	}
+
+	authz, err := h.serv.ValidateAuthorization(&req)
+	if err != nil {
+		if authz != nil {
+			h.redirect(c, authz, err)
+		} else {
+			Error(c, err)
+		}
+		return nil, false
+	}
+
+	return authz, true
+}
+
+// redirect sends err back to the client through the user agent.
+func (h *Handler) redirect(c *gin.Context, authz *Authorization, err error) {
+	c.Error(err)
+
+	res := errorResponse(err)
+	params := url.Values{"error": {res.Error}}
+	if res.ErrorDescription != "" {
+		params.Set("error_description", res.ErrorDescription)
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, &RedirectResponse{
+		RedirectTo: authz.RedirectURL(params),
+	})
+}
+
+func (h *Handler) token(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	pair, err := h.serv.Token(Client(c), &req)
+	if err != nil {
+		Error(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, pair)
+}
+
+func (h *Handler) authorizeDevice(c *gin.Context) {
+	var req DeviceRequest
+	if err := c.ShouldBind(&req); err != nil {
+		Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	authz, err := h.serv.AuthorizeDevice(Client(c), &req)
+	if err != nil {
+		Error(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, authz)
+}
+
+type DeviceConsentResponse struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	UserCode   string   `json:"user_code"`
+	Scopes     []string `json:"scopes"`
+}
+
+// device describes the device authorization of user_code to ask the user
+// for consent.
+func (h *Handler) device(c *gin.Context) {
+	device, err := h.serv.FindDevice(c.Query("user_code"), auth.Token(c))
+	if err != nil {
+		Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, &DeviceConsentResponse{
+		ClientID:   device.Client.ID,
+		ClientName: device.Client.Name,
+		UserCode:   device.UserCode,
+		Scopes:     strings.Fields(device.Scope),
+	})
+}
+
+// approveDevice records the decision of the logged-in user, sent as approve.
+func (h *Handler) approveDevice(c *gin.Context) {
+	approve := c.PostForm("approve") == "true"
+	if err := h.serv.ApproveDevice(c.PostForm("user_code"), auth.Token(c), approve); err != nil {
+		Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) introspect(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		Error(c, ErrInvalidRequest.F("token", "required"))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, h.serv.Introspect(token))
+}
+
+func (h *Handler) discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.serv.Discovery())
+}
+
+func (h *Handler) userinfo(c *gin.Context) {
+	info, err := h.serv.UserInfo(auth.Token(c))
+	if err != nil {
+		if tErr, ok := err.(errors.Error); ok && isOAuthError(tErr) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, tErr.Code))
+		}
+		Error(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// clientAuth authenticates the client with HTTP Basic (client_secret_basic)
+// or with client_id and client_secret in the form (client_secret_post).
+// Public clients only send client_id.
+func (h *Handler) clientAuth(c *gin.Context) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		// RFC 6749 2.3.1: both are form-urlencoded before encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	client, err := h.serv.Authenticate(clientID, secret)
+	if err != nil {
+		if ok {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		Error(c, err)
+		return
+	}
+
+	c.Set(clientContextKey, client)
+	c.Next()
+}
+
+// confidential rejects public clients, which anybody can impersonate.
+func (h *Handler) confidential(c *gin.Context) {
+	if client := Client(c); client == nil || client.Public {
+		Error(c, ErrUnauthorizedClient.M("public clients are not allowed"))
+		return
+	}
+	c.Next()
+}
+
+// Client returns the client authenticated by the handler.
+func Client(c *gin.Context) *models.Client {
+	if v, ok := c.Get(clientContextKey); ok {
+		if client, ok := v.(*models.Client); ok {
+			return client
+		}
+	}
+	return nil
+}
+
+// ErrorResponse is the RFC 6749 5.2 error body.
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Error aborts the request with an RFC 6749 error. Errors not defined by this
+// package are reported as server_error.
+func Error(c *gin.Context, err error) {
+	c.Error(err)
+
+	status := errors.StatusCode(err)
+	res := errorResponse(err)
+	if res.Error == ErrServerError.Code {
+		status = http.StatusInternalServerError
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.AbortWithStatusJSON(status, res)
+}
+
+func errorResponse(err error) *ErrorResponse {
+	res := &ErrorResponse{Error: ErrServerError.Code}
+	if tErr, ok := err.(errors.Error); ok && isOAuthError(tErr) {
+		res.Error = tErr.Code
+		res.ErrorDescription = tErr.Message
+	}
+	return res
+}
+
+func isOAuthError(err errors.Error) bool {
+	for _, e := range oauthErrors {
+		if err.Equals(e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,403 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter serves the handler with a real auth service backed by memory.
+func newTestRouter(t *testing.T) (*gin.Engine, *mockService, auth.Service) {
+	gin.SetMode(gin.TestMode)
+
+	enc, err := auth.NewEncoder()
+	require.Nil(t, err)
+	authServ := auth.NewService(auth.NewRepository(cache.NewInMemory("auth")), enc)
+
+	serv := newMockService()
+	serv.service.authServ = authServ
+	serv.service.enc = enc
+
+	r := gin.New()
+	NewHandler(serv, authServ).Routes(r)
+	return r, serv, authServ
+}
+
+func TestIntrospectHandler(t *testing.T) {
+	r, serv, authServ := newTestRouter(t)
+
+	mClient := models.NewClient()
+	mClient.Secret = "hashed.secret"
+	require.Nil(t, serv.repo.InsertClient(mClient))
+	serv.crypt.On("Compare", "hashed.secret", "secret").Return(true)
+	serv.crypt.On("Compare", "hashed.secret", "wrong").Return(false)
+
+	pair, err := authServ.Create(&auth.CreateRequest{UserID: "user123"})
+	require.Nil(t, err)
+
+	introspect := func(form url.Values, basic bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/oauth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basic {
+			req.SetBasicAuth(mClient.ID, "secret")
+		}
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+	decode := func(res *httptest.ResponseRecorder) map[string]interface{} {
+		body := make(map[string]interface{})
+		require.Nil(t, json.NewDecoder(res.Body).Decode(&body))
+		return body
+	}
+
+	t.Run("without client", func(t *testing.T) {
+		res := introspect(url.Values{"token": {pair.AccessToken}}, false)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "invalid_client", decode(res)["error"])
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		res := introspect(url.Values{
+			"token":         {pair.AccessToken},
+			"client_id":     {mClient.ID},
+			"client_secret": {"wrong"},
+		}, false)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("without token", func(t *testing.T) {
+		res := introspect(url.Values{}, true)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "invalid_request", decode(res)["error"])
+	})
+
+	t.Run("active", func(t *testing.T) {
+		res := introspect(url.Values{"token": {pair.AccessToken}}, true)
+		assert.Equal(t, http.StatusOK, res.Code)
+		body := decode(res)
+		assert.Equal(t, true, body["active"])
+		assert.Equal(t, "user123", body["sub"])
+		assert.NotZero(t, body["exp"])
+	})
+
+	t.Run("client_secret_post", func(t *testing.T) {
+		res := introspect(url.Values{
+			"token":         {pair.AccessToken},
+			"client_id":     {mClient.ID},
+			"client_secret": {"secret"},
+		}, false)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, true, decode(res)["active"])
+	})
+
+	t.Run("refresh token", func(t *testing.T) {
+		res := introspect(url.Values{"token": {pair.RefreshToken}}, true)
+		assert.Equal(t, map[string]interface{}{"active": false}, decode(res))
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		_, err := authServ.Invalidate(pair.AccessToken)
+		require.Nil(t, err)
+
+		res := introspect(url.Values{"token": {pair.AccessToken}}, true)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, map[string]interface{}{"active": false}, decode(res))
+	})
+}
+
+func TestRegisterClientHandler(t *testing.T) {
+	r, serv, authServ := newTestRouter(t)
+	serv.crypt.On("Hash", mock.AnythingOfType("string")).Return("hashed.secret", nil)
+
+	register := func(role models.Role, clientID string) *httptest.ResponseRecorder {
+		pair, err := authServ.Create(&auth.CreateRequest{UserID: "user123", Role: role, ClientID: clientID})
+		require.Nil(t, err)
+
+		req := httptest.NewRequest("POST", "/oauth/clients", strings.NewReader(`{"name":"Gateway"}`))
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+
+	res := register(models.USER, "")
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	// Delegated by an admin to an OAuth client
+	res = register(models.ADMIN, "client123")
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	// With an API key of an admin
+	_, keyStr, err := authServ.CreateAPIKey(&auth.CreateAPIKeyRequest{
+		UserID:      "user123",
+		Role:        models.ADMIN,
+		Name:        "ci",
+		Permissions: map[string][]models.Permission{"oauth": {models.CREATE}},
+	})
+	require.Nil(t, err)
+	req := httptest.NewRequest("POST", "/oauth/clients", strings.NewReader(`{"name":"Gateway"}`))
+	req.Header.Set("Authorization", "Bearer "+keyStr)
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = register(models.ADMIN, "")
+	assert.Equal(t, http.StatusCreated, res.Code)
+	body := make(map[string]interface{})
+	require.Nil(t, json.NewDecoder(res.Body).Decode(&body))
+	assert.Equal(t, "Gateway", body["name"])
+	assert.Len(t, body["secret"], 43)
+	assert.NotContains(t, res.Body.String(), "hashed.secret")
+}
+
+func TestAuthorizationCodeHandler(t *testing.T) {
+	r, serv, authServ := newTestRouter(t)
+
+	mClient := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(mClient))
+	serv.usersServ.On("GetEnabled", "user123").Return(mockUser(), nil)
+
+	login, err := authServ.Create(&auth.CreateRequest{UserID: "user123"})
+	require.Nil(t, err)
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {mClient.ID},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challengeOf(mVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		body := make(map[string]interface{})
+		json.Unmarshal(res.Body.Bytes(), &body)
+		return res, body
+	}
+	authorize := func(approve string, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		form := url.Values{"approve": {approve}}
+		req := httptest.NewRequest("POST", "/oauth/authorize?"+params.Encode(), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(req)
+	}
+	redirectQuery := func(body map[string]interface{}) url.Values {
+		u, err := url.Parse(body["redirect_to"].(string))
+		require.Nil(t, err)
+		assert.Equal(t, "app.example.com", u.Host)
+		return u.Query()
+	}
+
+	t.Run("consent", func(t *testing.T) {
+		res, body := serve(httptest.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "SPA", body["client_name"])
+		assert.Equal(t, []interface{}{"openid"}, body["scopes"])
+	})
+
+	t.Run("consent with unregistered redirect URI", func(t *testing.T) {
+		bad := url.Values{}
+		for k, v := range params {
+			bad[k] = v
+		}
+		bad.Set("redirect_uri", "https://evil.example.com/callback")
+		res, body := serve(httptest.NewRequest("GET", "/oauth/authorize?"+bad.Encode(), nil))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Nil(t, body["redirect_to"])
+	})
+
+	t.Run("not logged in", func(t *testing.T) {
+		res, _ := authorize("true", "")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		res, body := authorize("false", login.AccessToken)
+		assert.Equal(t, http.StatusOK, res.Code)
+		query := redirectQuery(body)
+		assert.Equal(t, "access_denied", query.Get("error"))
+		assert.Equal(t, "xyz", query.Get("state"))
+	})
+
+	t.Run("approved", func(t *testing.T) {
+		res, body := authorize("true", login.AccessToken)
+		assert.Equal(t, http.StatusOK, res.Code)
+		query := redirectQuery(body)
+		assert.Equal(t, "xyz", query.Get("state"))
+		code := query.Get("code")
+		require.NotEmpty(t, code)
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {mClient.ID},
+			"code":          {code},
+			"redirect_uri":  {"https://app.example.com/callback"},
+			"code_verifier": {mVerifier},
+		}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res, body = serve(req)
+		assert.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		assert.Equal(t, "no-store", res.Header().Get("Cache-Control"))
+		assert.NotEmpty(t, body["access_token"])
+		assert.Equal(t, "Bearer", body["token_type"])
+		assert.Equal(t, "openid", body["scope"])
+		assert.NotEmpty(t, body["id_token"])
+
+		// Replayed code
+		req = httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res, body = serve(req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("public clients cannot introspect", func(t *testing.T) {
+		form := url.Values{"client_id": {mClient.ID}, "token": {login.AccessToken}}
+		req := httptest.NewRequest("POST", "/oauth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res, body := serve(req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "unauthorized_client", body["error"])
+	})
+}
+
+func TestOIDCHandler(t *testing.T) {
+	r, serv, authServ := newTestRouter(t)
+	serv.usersServ.On("GetEnabled", "user123").Return(mockUser(), nil)
+
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		body := make(map[string]interface{})
+		json.Unmarshal(res.Body.Bytes(), &body)
+		return res, body
+	}
+	userinfo := func(scope string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		pair, err := authServ.Create(&auth.CreateRequest{UserID: "user123", ClientID: "client123", Scope: scope})
+		require.Nil(t, err)
+		req := httptest.NewRequest("GET", "/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		return serve(req)
+	}
+
+	t.Run("discovery", func(t *testing.T) {
+		res, body := serve(httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "https://auth.example.com", body["issuer"])
+		assert.Equal(t, "https://auth.example.com/userinfo", body["userinfo_endpoint"])
+		assert.Equal(t, []interface{}{"HS256"}, body["id_token_signing_alg_values_supported"])
+	})
+
+	t.Run("userinfo without token", func(t *testing.T) {
+		res, _ := serve(httptest.NewRequest("GET", "/userinfo", nil))
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("userinfo without openid", func(t *testing.T) {
+		res, body := userinfo("profile")
+		assert.Equal(t, http.StatusForbidden, res.Code)
+		assert.Equal(t, "insufficient_scope", body["error"])
+		assert.Equal(t, `Bearer error="insufficient_scope"`, res.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("userinfo", func(t *testing.T) {
+		res, body := userinfo("openid email")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, map[string]interface{}{
+			"sub":            "user123",
+			"email":          "user@example.com",
+			"email_verified": true,
+		}, body)
+	})
+}
+
+func TestDeviceHandler(t *testing.T) {
+	r, serv, authServ := newTestRouter(t)
+
+	mClient := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(mClient))
+
+	login, err := authServ.Create(&auth.CreateRequest{UserID: "user123"})
+	require.Nil(t, err)
+
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		body := make(map[string]interface{})
+		json.Unmarshal(res.Body.Bytes(), &body)
+		return res, body
+	}
+	post := func(path string, form url.Values, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(req)
+	}
+
+	res, body := post("/oauth/device_authorization", url.Values{
+		"client_id": {mClient.ID},
+		"scope":     {"profile"},
+	}, "")
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	deviceCode := body["device_code"].(string)
+	userCode := body["user_code"].(string)
+
+	pollForm := url.Values{
+		"grant_type":  {DeviceCodeGrant},
+		"client_id":   {mClient.ID},
+		"device_code": {deviceCode},
+	}
+
+	t.Run("pending", func(t *testing.T) {
+		res, body := post("/oauth/token", pollForm, "")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "authorization_pending", body["error"])
+	})
+
+	t.Run("consent", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/oauth/device?user_code="+userCode, nil)
+		res, _ := serve(req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		res, body := serve(req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "SPA", body["client_name"])
+		assert.Equal(t, userCode, body["user_code"])
+		assert.Equal(t, []interface{}{"profile"}, body["scopes"])
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		res, _ := post("/oauth/device", url.Values{"user_code": {userCode}, "approve": {"true"}}, login.AccessToken)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+
+		code, err := serv.repo.FindDeviceCode(deviceCode)
+		require.Nil(t, err)
+		code.LastPolledAt = 0
+		require.Nil(t, serv.repo.UpdateDevicePoll(code))
+
+		res, body := post("/oauth/token", pollForm, "")
+		assert.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		assert.NotEmpty(t, body["access_token"])
+		assert.Equal(t, "profile", body["scope"])
+	})
+}
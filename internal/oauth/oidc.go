@@ -0,0 +1,149 @@
+package oauth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Scopes defined by OpenID Connect. openid turns an authorization into an
+// OIDC one; profile and email release the claims about the user.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// idTokenTTL is how long an ID token is accepted. Clients check it right
+// after the code exchange.
+const idTokenTTL = time.Hour
+
+// UserClaims are the standard claims about the user released by the granted
+// scopes.
+type UserClaims struct {
+	Name              string `json:"name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// NewUserClaims filters the user by scope: profile for the names and email
+// for the address and whether it was validated.
+func NewUserClaims(user *users.UserDTO, scope string) UserClaims {
+	var claims UserClaims
+	if auth.HasScope(scope, ScopeProfile) {
+		claims.Name = user.Name
+		claims.FamilyName = user.Lastname
+		claims.PreferredUsername = user.Username
+	}
+	if auth.HasScope(scope, ScopeEmail) {
+		verified := user.Validated
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	return claims
+}
+
+// UserInfo is the response of the userinfo endpoint.
+type UserInfo struct {
+	Sub string `json:"sub"`
+	UserClaims
+}
+
+// IDClaims is the payload of an ID token, whose audience is the client.
+type IDClaims struct {
+	jwt.StandardClaims
+	Nonce string `json:"nonce,omitempty"`
+	UserClaims
+}
+
+// Discovery is the OpenID Provider metadata.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Discovery describes the endpoints under the issuer URL. ID tokens are
+// signed like access tokens: with HS256 only this service can verify them,
+// so OIDC needs a private key configured.
+func (s *service) Discovery() *Discovery {
+	base := strings.TrimSuffix(s.issuer, "/")
+	return &Discovery{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		DeviceAuthorizationEndpoint:       base + "/oauth/device_authorization",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserinfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", DeviceCodeGrant},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.enc.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "nonce",
+			"name", "family_name", "preferred_username", "email", "email_verified",
+		},
+	}
+}
+
+// UserInfo returns the claims released to token, which must have been
+// granted the openid scope.
+func (s *service) UserInfo(token *models.Token) (*UserInfo, error) {
+	if token == nil || token.Machine || !auth.HasScope(token.Scope, ScopeOpenID) {
+		return nil, ErrInsufficientScope.M("the openid scope is required")
+	}
+
+	user, err := s.usersServ.GetEnabled(token.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken.C("sub", token.UserID).Wrap(err)
+	}
+
+	dto := users.NewDTO(user)
+	return &UserInfo{
+		Sub:        dto.ID,
+		UserClaims: NewUserClaims(dto, token.Scope),
+	}, nil
+}
+
+// idToken signs the ID token of userID for clientID.
+func (s *service) idToken(userID, clientID, scope, nonce string) (string, error) {
+	user, err := s.usersServ.GetEnabled(userID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return s.enc.Sign(&IDClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    s.issuer,
+			Subject:   user.ID,
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(idTokenTTL).Unix(),
+		},
+		Nonce:      nonce,
+		UserClaims: NewUserClaims(users.NewDTO(user), scope),
+	})
+}
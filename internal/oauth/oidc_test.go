@@ -0,0 +1,200 @@
+package oauth
+
+import (
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockUser() *models.User {
+	user := models.NewUser()
+	user.ID = "user123"
+	user.Username = "admin"
+	user.Email = "user@example.com"
+	user.Name = "Ada"
+	user.Lastname = "Lovelace"
+	user.Validated = true
+	return user
+}
+
+func TestNewUserClaims(t *testing.T) {
+	verified := true
+	dto := users.NewDTO(mockUser())
+
+	tests := []struct {
+		name     string
+		scope    string
+		expected UserClaims
+	}{{
+		"openid",
+		"openid",
+		UserClaims{},
+	}, {
+		"profile",
+		"openid profile",
+		UserClaims{Name: "Ada", FamilyName: "Lovelace", PreferredUsername: "admin"},
+	}, {
+		"email",
+		"openid email",
+		UserClaims{Email: "user@example.com", EmailVerified: &verified},
+	}, {
+		"profile and email",
+		"email openid profile",
+		UserClaims{
+			Name:              "Ada",
+			FamilyName:        "Lovelace",
+			PreferredUsername: "admin",
+			Email:             "user@example.com",
+			EmailVerified:     &verified,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, NewUserClaims(dto, test.scope))
+		})
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	assert := assert.New(t)
+
+	enc, err := auth.NewEncoder()
+	require.Nil(t, err)
+	authServ := auth.NewService(auth.NewRepository(cache.NewInMemory("auth")), enc)
+
+	serv := newMockService()
+	serv.service.authServ = authServ
+	serv.service.enc = enc
+	serv.usersServ.On("GetEnabled", "user123").Return(mockUser(), nil)
+
+	mClient := mockPublicClient()
+	require.Nil(t, serv.repo.InsertClient(mClient))
+
+	exchange := func(scope string) *auth.TokenPair {
+		authz, err := serv.ValidateAuthorization(&AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            mClient.ID,
+			RedirectURI:         "https://app.example.com/callback",
+			Scope:               scope,
+			CodeChallenge:       challengeOf(mVerifier),
+			CodeChallengeMethod: "S256",
+			Nonce:               "n-0S6_WzA2Mj",
+		})
+		require.Nil(t, err)
+		code, err := serv.Authorize(authz, &models.Token{UserID: "user123", Role: models.USER})
+		require.Nil(t, err)
+		pair, err := serv.Token(mClient, &TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         code,
+			RedirectURI:  "https://app.example.com/callback",
+			CodeVerifier: mVerifier,
+		})
+		require.Nil(t, err)
+		return pair
+	}
+
+	// OAuth only
+	pair := exchange("profile")
+	assert.Empty(pair.IDToken)
+
+	pair = exchange("openid profile")
+	claims := &IDClaims{}
+	_, err = jwt.ParseWithClaims(pair.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+		return config.Get().JWTSecret, nil
+	})
+	require.Nil(t, err)
+
+	assert.Equal("https://auth.example.com", claims.Issuer)
+	assert.Equal("user123", claims.Subject)
+	assert.Equal(mClient.ID, claims.Audience)
+	assert.Equal("n-0S6_WzA2Mj", claims.Nonce)
+	assert.Equal(claims.IssuedAt+3600, claims.ExpiresAt)
+	assert.Equal("Ada", claims.Name)
+	assert.Equal("Lovelace", claims.FamilyName)
+	assert.Empty(claims.Email)
+	assert.Nil(claims.EmailVerified)
+}
+
+func TestUserInfo(t *testing.T) {
+	verified, unverified := true, false
+
+	tests := []struct {
+		name     string
+		token    *models.Token
+		expected *UserInfo
+		err      error
+		mock     func(*mockService)
+	}{{
+		"without openid",
+		&models.Token{UserID: "user123", Scope: "profile email"},
+		nil,
+		ErrInsufficientScope,
+		nil,
+	}, {
+		"machine token",
+		&models.Token{UserID: "client123", Scope: "openid", Machine: true},
+		nil,
+		ErrInsufficientScope,
+		nil,
+	}, {
+		"user not found",
+		&models.Token{UserID: "user123", Scope: "openid"},
+		nil,
+		ErrInvalidToken,
+		func(s *mockService) {
+			s.usersServ.On("GetEnabled", "user123").Return(nil, users.ErrNotFound)
+		},
+	}, {
+		"email",
+		&models.Token{UserID: "user123", Scope: "openid email"},
+		&UserInfo{
+			Sub:        "user123",
+			UserClaims: UserClaims{Email: "user@example.com", EmailVerified: &verified},
+		},
+		nil,
+		func(s *mockService) {
+			s.usersServ.On("GetEnabled", "user123").Return(mockUser(), nil)
+		},
+	}, {
+		"email not verified",
+		&models.Token{UserID: "user123", Scope: "openid email"},
+		&UserInfo{
+			Sub:        "user123",
+			UserClaims: UserClaims{Email: "user@example.com", EmailVerified: &unverified},
+		},
+		nil,
+		func(s *mockService) {
+			u := mockUser()
+			u.Validated = false
+			s.usersServ.On("GetEnabled", "user123").Return(u, nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := newMockService()
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			info, err := serv.UserInfo(test.token)
+
+			if test.err != nil {
+				errors.Assert(t, test.err, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.expected, info)
+			serv.usersServ.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package oauth
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// Errors
+var (
+	ErrRepositoryNotFound = errors.Internal.New("oauth.repository.not_found")
+	ErrRepositoryInsert   = errors.Internal.New("oauth.repository.insert")
+	ErrRepositoryDelete   = errors.Internal.New("oauth.repository.delete")
+)
+
+// Interfaces
+type Repository interface {
+	FindClient(clientID string) (*models.Client, error)
+	// InsertClient stores the client, replacing any previous version of it.
+	// Clients never expire.
+	InsertClient(client *models.Client) error
+	DeleteClient(clientID string) error
+
+	// TakeCode finds and deletes the code at once, so of concurrent calls
+	// only one gets it.
+	TakeCode(code string) (*models.AuthorizationCode, error)
+	// InsertCode stores the code until it expires.
+	InsertCode(code *models.AuthorizationCode) error
+
+	FindDeviceCode(deviceCode string) (*models.DeviceCode, error)
+	FindDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error)
+	// InsertDeviceCode stores the code, and its user code, until it expires.
+	InsertDeviceCode(code *models.DeviceCode) error
+	// UpdateDevicePoll stores the Interval and LastPolledAt of the code apart
+	// from the rest of it, so that a poll never overwrites the decision of
+	// the user.
+	UpdateDevicePoll(code *models.DeviceCode) error
+	// TakeDeviceCode finds and deletes the code at once, so of concurrent
+	// calls only one gets it.
+	TakeDeviceCode(deviceCode string) (*models.DeviceCode, error)
+}
+
+// Implementations
+type repository struct {
+	cache cache.Cache
+}
+
+// NewRepository stores clients in cache without expiration, so cache must be
+// one of cache.NewPersistent: evicting a client would make it unknown.
+func NewRepository(cache cache.Cache) Repository {
+	return &repository{
+		cache: cache,
+	}
+}
+
+func (r *repository) FindClient(clientID string) (*models.Client, error) {
+	client := &models.Client{}
+	if err := r.get(clientKey(clientID), client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *repository) InsertClient(client *models.Client) error {
+	b, err := json.Marshal(client)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+
+	if err := r.cache.Set(clientKey(client.ID), b, cache.NoExpiration); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	return nil
+}
+
+func (r *repository) DeleteClient(clientID string) error {
+	if err := r.cache.Delete(clientKey(clientID)); err != nil {
+		return ErrRepositoryDelete.Wrap(err)
+	}
+	return nil
+}
+
+func (r *repository) TakeCode(code string) (*models.AuthorizationCode, error) {
+	v, err := r.cache.Take(codeKey(code))
+	if v == nil || err != nil {
+		return nil, ErrRepositoryNotFound.Wrap(err)
+	}
+
+	authCode := &models.AuthorizationCode{}
+	if err := decode(v, authCode); err != nil {
+		return nil, err
+	}
+	return authCode, nil
+}
+
+func (r *repository) InsertCode(code *models.AuthorizationCode) error {
+	ttl := code.TTL()
+	if ttl <= 0 {
+		return ErrRepositoryInsert.M("code already expired")
+	}
+
+	b, err := json.Marshal(code)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+
+	if err := r.cache.Set(codeKey(code.Code), b, ttl); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	return nil
+}
+
+// devicePoll is the part of a device code its polls update.
+type devicePoll struct {
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt int64         `json:"last_polled_at"`
+}
+
+func (r *repository) FindDeviceCode(deviceCode string) (*models.DeviceCode, error) {
+	code := &models.DeviceCode{}
+	if err := r.get(deviceCodeKey(deviceCode), code); err != nil {
+		return nil, err
+	}
+	r.applyDevicePoll(code)
+	return code, nil
+}
+
+func (r *repository) FindDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error) {
+	var deviceCode string
+	if err := r.get(userCodeKey(userCode), &deviceCode); err != nil {
+		return nil, err
+	}
+	return r.FindDeviceCode(deviceCode)
+}
+
+func (r *repository) InsertDeviceCode(code *models.DeviceCode) error {
+	ttl := code.TTL()
+	if ttl <= 0 {
+		return ErrRepositoryInsert.M("device code already expired")
+	}
+
+	b, err := json.Marshal(code)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	index, err := json.Marshal(code.DeviceCode)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+
+	if err := r.cache.Set(deviceCodeKey(code.DeviceCode), b, ttl); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	if err := r.cache.Set(userCodeKey(code.UserCode), index, ttl); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	return nil
+}
+
+func (r *repository) UpdateDevicePoll(code *models.DeviceCode) error {
+	ttl := code.TTL()
+	if ttl <= 0 {
+		return ErrRepositoryInsert.M("device code already expired")
+	}
+
+	b, err := json.Marshal(&devicePoll{Interval: code.Interval, LastPolledAt: code.LastPolledAt})
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+
+	if err := r.cache.Set(devicePollKey(code.DeviceCode), b, ttl); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	return nil
+}
+
+func (r *repository) TakeDeviceCode(deviceCode string) (*models.DeviceCode, error) {
+	v, err := r.cache.Take(deviceCodeKey(deviceCode))
+	if v == nil || err != nil {
+		return nil, ErrRepositoryNotFound.Wrap(err)
+	}
+
+	code := &models.DeviceCode{}
+	if err := decode(v, code); err != nil {
+		return nil, err
+	}
+	r.applyDevicePoll(code)
+
+	if err := r.cache.Delete(userCodeKey(code.UserCode)); err != nil {
+		return nil, ErrRepositoryDelete.Wrap(err)
+	}
+	if err := r.cache.Delete(devicePollKey(code.DeviceCode)); err != nil {
+		return nil, ErrRepositoryDelete.Wrap(err)
+	}
+	return code, nil
+}
+
+// applyDevicePoll sets the last poll of code, if it was polled.
+func (r *repository) applyDevicePoll(code *models.DeviceCode) {
+	poll := &devicePoll{}
+	if err := r.get(devicePollKey(code.DeviceCode), poll); err != nil {
+		return
+	}
+	code.Interval = poll.Interval
+	code.LastPolledAt = poll.LastPolledAt
+}
+
+func (r *repository) get(key string, dst interface{}) error {
+	v, err := r.cache.Get(key)
+	if v == nil || err != nil {
+		return ErrRepositoryNotFound.Wrap(err)
+	}
+	return decode(v, dst)
+}
+
+// decode unmarshals a value read from the cache into dst.
+func decode(v interface{}, dst interface{}) error {
+	var b []byte
+	switch v := v.(type) {
+	case []byte:
+		b = v
+	case string: // Redis
+		b = []byte(v)
+	default:
+		return ErrRepositoryNotFound.M("wrong conversion")
+	}
+
+	if err := json.Unmarshal(b, dst); err != nil {
+		return ErrRepositoryNotFound.Wrap(err)
+	}
+
+	return nil
+}
+
+func clientKey(clientID string) string {
+	return "client:" + clientID
+}
+
+func codeKey(code string) string {
+	return "code:" + code
+}
+
+func deviceCodeKey(deviceCode string) string {
+	return "device_code:" + deviceCode
+}
+
+func devicePollKey(deviceCode string) string {
+	return "device_poll:" + deviceCode
+}
+
+func userCodeKey(userCode string) string {
+	return "user_code:" + userCode
+}
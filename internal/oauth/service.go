@@ -0,0 +1,254 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/ratelimit"
+)
+
+// Errors use the RFC 6749 error codes, which are rendered as they are.
+var (
+	ErrInvalidRequest          = errors.Validation.New("invalid_request").S(400)
+	ErrInvalidClient           = errors.Status.New("invalid_client").S(401)
+	ErrInvalidGrant            = errors.Status.New("invalid_grant").S(400)
+	ErrInvalidScope            = errors.Validation.New("invalid_scope").S(400)
+	ErrUnauthorizedClient      = errors.Status.New("unauthorized_client").S(400)
+	ErrUnsupportedGrantType    = errors.Status.New("unsupported_grant_type").S(400)
+	ErrUnsupportedResponseType = errors.Status.New("unsupported_response_type").S(400)
+	ErrAccessDenied            = errors.Status.New("access_denied").S(403)
+	ErrServerError             = errors.Status.New("server_error").S(500)
+
+	// RFC 8628 errors of the device code grant
+	ErrAuthorizationPending = errors.Status.New("authorization_pending").S(400)
+	ErrSlowDown             = errors.Status.New("slow_down").S(400)
+	ErrExpiredToken         = errors.Status.New("expired_token").S(400)
+	// Not in RFC 8628: too many user codes tried on the verification page
+	ErrTooManyRequests = errors.Status.New("too_many_requests").S(429)
+
+	// RFC 6750 errors of the userinfo endpoint
+	ErrInvalidToken      = errors.Status.New("invalid_token").S(401)
+	ErrInsufficientScope = errors.Status.New("insufficient_scope").S(403)
+)
+
+var oauthErrors = []errors.Error{
+	ErrInvalidRequest,
+	ErrInvalidClient,
+	ErrInvalidGrant,
+	ErrInvalidScope,
+	ErrUnauthorizedClient,
+	ErrUnsupportedGrantType,
+	ErrUnsupportedResponseType,
+	ErrAccessDenied,
+	ErrServerError,
+	ErrAuthorizationPending,
+	ErrSlowDown,
+	ErrExpiredToken,
+	ErrTooManyRequests,
+	ErrInvalidToken,
+	ErrInsufficientScope,
+}
+
+// Interfaces
+type Service interface {
+	// RegisterClient returns the client and its secret in plain text, which
+	// is not stored and cannot be recovered. Public clients get no secret.
+	RegisterClient(req *RegisterClientRequest) (*models.Client, string, error)
+	// Authenticate checks the credentials of a client. Public clients are
+	// identified by their ID alone.
+	Authenticate(clientID, secret string) (*models.Client, error)
+	// Introspect describes a token to an authenticated client (RFC 7662).
+	Introspect(tokenStr string) *Introspection
+
+	// ValidateAuthorization checks an authorization request. When the
+	// client and redirect URI are valid the Authorization is returned even
+	// with an error, which must then be sent to the redirect URI.
+	ValidateAuthorization(req *AuthorizeRequest) (*Authorization, error)
+	// Authorize issues a code for the user behind token, which must come
+	// from a password login.
+	Authorize(authz *Authorization, token *models.Token) (string, error)
+	// Token implements the token endpoint for an authenticated client.
+	Token(client *models.Client, req *TokenRequest) (*auth.TokenPair, error)
+
+	// AuthorizeDevice starts a device authorization for the client.
+	AuthorizeDevice(client *models.Client, req *DeviceRequest) (*DeviceAuthorization, error)
+	// FindDevice returns the pending device authorization of a user code to
+	// the user behind token, which must come from a password login.
+	FindDevice(userCode string, token *models.Token) (*Device, error)
+	// ApproveDevice records the decision of the user behind token, which
+	// must come from a password login. Like FindDevice, it counts towards
+	// the user codes the user may try.
+	ApproveDevice(userCode string, token *models.Token, approve bool) error
+
+	// Discovery returns the OpenID Connect provider metadata.
+	Discovery() *Discovery
+	// UserInfo returns the claims about the user that token was granted.
+	UserInfo(token *models.Token) (*UserInfo, error)
+}
+
+// Introspection is the RFC 7662 response. Only Active is set for inactive
+// tokens.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	// Machine is set for client_credentials tokens, whose sub is the client.
+	Machine bool `json:"machine,omitempty"`
+}
+
+// Implementations
+type service struct {
+	repo      Repository
+	authServ  auth.Service
+	usersServ users.Service
+	enc       auth.Encoder
+	crypt     users.PasswordCrypt
+	issuer    string
+
+	verificationURI string
+	deviceLimiter   ratelimit.Limiter
+}
+
+// NewService signs ID tokens with enc, the encoder of the access tokens, and
+// keeps rate limit counters in c.
+func NewService(repo Repository, authServ auth.Service, usersServ users.Service, enc auth.Encoder, c cache.Cache) Service {
+	return &service{
+		repo:      repo,
+		authServ:  authServ,
+		usersServ: usersServ,
+		enc:       enc,
+		crypt:     users.NewBcryptCrypt(),
+		issuer:    config.Get().OIDCIssuer,
+
+		verificationURI: config.Get().DeviceVerificationURI,
+		deviceLimiter:   ratelimit.New(c, "device", deviceLimit, deviceWindow),
+	}
+}
+
+type RegisterClientRequest struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+}
+
+func (s *service) RegisterClient(req *RegisterClientRequest) (*models.Client, string, error) {
+	if req.Name == "" {
+		return nil, "", ErrInvalidRequest.F("name", "required")
+	}
+	for _, scope := range req.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \"\\") {
+			return nil, "", ErrInvalidScope.F("scopes", "invalid").C("scope", scope)
+		}
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, "", ErrInvalidRequest.F("redirect_uris", "invalid").C("uri", uri)
+		}
+	}
+	if req.Public && len(req.RedirectURIs) == 0 {
+		return nil, "", ErrInvalidRequest.F("redirect_uris", "required")
+	}
+
+	client := models.NewClient()
+	client.Name = req.Name
+	client.Public = req.Public
+	if req.RedirectURIs != nil {
+		client.RedirectURIs = req.RedirectURIs
+	}
+	if req.Scopes != nil {
+		client.Scopes = req.Scopes
+	}
+
+	var secret string
+	if !client.Public {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			return nil, "", ErrServerError.Wrap(err)
+		}
+		hash, err := s.crypt.Hash(secret)
+		if err != nil {
+			return nil, "", ErrServerError.Wrap(err)
+		}
+		client.Secret = hash
+	}
+
+	if err := s.repo.InsertClient(client); err != nil {
+		return nil, "", ErrServerError.Wrap(err)
+	}
+
+	return client, secret, nil
+}
+
+func (s *service) Authenticate(clientID, secret string) (*models.Client, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := s.repo.FindClient(clientID)
+	if err != nil {
+		return nil, ErrInvalidClient.C("id", clientID).Wrap(err)
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, ErrInvalidClient.C("id", clientID)
+		}
+		return client, nil
+	}
+	if secret == "" || !s.crypt.Compare(client.Secret, secret) {
+		return nil, ErrInvalidClient.C("id", clientID)
+	}
+
+	return client, nil
+}
+
+// Introspect reports every token auth.Service.Validate rejects as inactive:
+// malformed, expired, revoked and refresh tokens.
+func (s *service) Introspect(tokenStr string) *Introspection {
+	token, err := s.authServ.Validate(tokenStr)
+	if err != nil {
+		return &Introspection{Active: false}
+	}
+
+	return &Introspection{
+		Active:    true,
+		Sub:       token.UserID,
+		Exp:       seconds(token.ExpiresAt),
+		Iat:       seconds(token.CreatedAt),
+		Jti:       token.ID,
+		Scope:     token.Scope,
+		ClientID:  token.ClientID,
+		TokenType: "Bearer",
+		Machine:   token.Machine,
+	}
+}
+
+// newSecret returns 32 random bytes encoded for use in URLs and headers.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func seconds(unixNano int64) int64 {
+	if unixNano == 0 {
+		return 0
+	}
+	return time.Unix(0, unixNano).Unix()
+}
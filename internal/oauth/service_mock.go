@@ -0,0 +1,338 @@
+package oauth
+
+import (
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/ratelimit"
+	"github.com/aboglioli/big-brother/pkg/webauthn"
+	"github.com/stretchr/testify/mock"
+)
+
+// Auth service
+type mockAuthService struct {
+	mock.Mock
+}
+
+func (s *mockAuthService) Create(req *auth.CreateRequest) (*auth.TokenPair, error) {
+	args := s.Called(req)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) Refresh(req *auth.RefreshRequest) (*auth.TokenPair, error) {
+	args := s.Called(req)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) Validate(tokenStr string) (*models.Token, error) {
+	args := s.Called(tokenStr)
+	if token, ok := args.Get(0).(*models.Token); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) Invalidate(tokenStr string) (*models.Token, error) {
+	args := s.Called(tokenStr)
+	if token, ok := args.Get(0).(*models.Token); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) ListSessions(userID string) ([]*models.Session, error) {
+	args := s.Called(userID)
+	if sessions, ok := args.Get(0).([]*models.Session); ok {
+		return sessions, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) RevokeSession(userID, sessionID string) error {
+	args := s.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) RevokeAll(userID string, except ...string) error {
+	args := s.Called(userID, except)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) CreateAPIKey(req *auth.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	args := s.Called(req)
+	if key, ok := args.Get(0).(*models.APIKey); ok {
+		return key, args.String(1), args.Error(2)
+	}
+	return nil, args.String(1), args.Error(2)
+}
+
+func (s *mockAuthService) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	args := s.Called(userID)
+	if keys, ok := args.Get(0).([]*models.APIKey); ok {
+		return keys, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) RevokeAPIKey(userID, keyID string) error {
+	args := s.Called(userID, keyID)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) RevokeAPIKeys(userID string) error {
+	args := s.Called(userID)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) ValidateAPIKey(keyStr string) (*models.APIKey, error) {
+	args := s.Called(keyStr)
+	if key, ok := args.Get(0).(*models.APIKey); ok {
+		return key, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) SignAction(req *auth.ActionRequest) (string, error) {
+	args := s.Called(req)
+	return args.String(0), args.Error(1)
+}
+
+func (s *mockAuthService) VerifyAction(tokenStr, action string) (*auth.ActionClaims, error) {
+	args := s.Called(tokenStr, action)
+	if claims, ok := args.Get(0).(*auth.ActionClaims); ok {
+		return claims, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Users service
+type mockUsersService struct {
+	mock.Mock
+}
+
+func (s *mockUsersService) GetByID(id string) (*models.User, error) {
+	args := s.Called(id)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) GetEnabled(id string) (*models.User, error) {
+	args := s.Called(id)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) Register(req *users.RegisterRequest) (*models.User, error) {
+	args := s.Called(req)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) Update(id string, req *users.UpdateRequest) (*models.User, error) {
+	args := s.Called(id, req)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) Delete(id string) error {
+	args := s.Called(id)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) Login(req *users.LoginRequest) (*users.LoginResponse, error) {
+	args := s.Called(req)
+	if res, ok := args.Get(0).(*users.LoginResponse); ok {
+		return res, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) Refresh(refreshTokenStr string) (*auth.TokenPair, error) {
+	args := s.Called(refreshTokenStr)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) Logout(tokenStr string) error {
+	args := s.Called(tokenStr)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) VerifyEmail(tokenStr string) (*models.User, error) {
+	args := s.Called(tokenStr)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) ResendVerification(email string) error {
+	args := s.Called(email)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) RequestPasswordReset(email string) error {
+	args := s.Called(email)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) ResetPassword(tokenStr, password string) error {
+	args := s.Called(tokenStr, password)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) RequestMagicLink(email string) error {
+	args := s.Called(email)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) LoginWithMagicLink(req *users.MagicLinkLoginRequest) (*users.LoginResponse, error) {
+	args := s.Called(req)
+	if res, ok := args.Get(0).(*users.LoginResponse); ok {
+		return res, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) EnrollTOTP(userID string) (*users.TOTPEnrollment, error) {
+	args := s.Called(userID)
+	if enrollment, ok := args.Get(0).(*users.TOTPEnrollment); ok {
+		return enrollment, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) ConfirmTOTP(userID, code string) ([]string, error) {
+	args := s.Called(userID, code)
+	if codes, ok := args.Get(0).([]string); ok {
+		return codes, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) DisableTOTP(userID, code string) error {
+	args := s.Called(userID, code)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) LoginMFA(req *users.MFALoginRequest) (*auth.TokenPair, error) {
+	args := s.Called(req)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) BeginWebAuthnRegistration(userID string) (*webauthn.CreationOptions, error) {
+	args := s.Called(userID)
+	if opts, ok := args.Get(0).(*webauthn.CreationOptions); ok {
+		return opts, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) FinishWebAuthnRegistration(userID string, req *users.WebAuthnRegistrationRequest) (*models.WebAuthnCredential, error) {
+	args := s.Called(userID, req)
+	if cred, ok := args.Get(0).(*models.WebAuthnCredential); ok {
+		return cred, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) ListWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error) {
+	args := s.Called(userID)
+	if creds, ok := args.Get(0).([]*models.WebAuthnCredential); ok {
+		return creds, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) DeleteWebAuthnCredential(userID, id string) error {
+	args := s.Called(userID, id)
+	return args.Error(0)
+}
+
+func (s *mockUsersService) BeginWebAuthnLogin(mfaToken string) (*webauthn.RequestOptions, error) {
+	args := s.Called(mfaToken)
+	if opts, ok := args.Get(0).(*webauthn.RequestOptions); ok {
+		return opts, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockUsersService) FinishWebAuthnLogin(req *users.WebAuthnLoginRequest) (*auth.TokenPair, error) {
+	args := s.Called(req)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Crypt
+type mockPasswordCrypt struct {
+	mock.Mock
+}
+
+func (m *mockPasswordCrypt) Hash(pwd string) (string, error) {
+	args := m.Called(pwd)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockPasswordCrypt) Compare(hashedPwd, pwd string) bool {
+	args := m.Called(hashedPwd, pwd)
+	return args.Bool(0)
+}
+
+// Service
+type mockService struct {
+	*service
+	repo      Repository
+	authServ  *mockAuthService
+	usersServ *mockUsersService
+	crypt     *mockPasswordCrypt
+}
+
+// newMockService stores clients in memory. Tests issuing ID tokens set the
+// encoder.
+func newMockService() *mockService {
+	c := cache.NewInMemory("oauth")
+	repo := NewRepository(c)
+	authServ := &mockAuthService{}
+	usersServ := &mockUsersService{}
+	crypt := &mockPasswordCrypt{}
+
+	serv := &service{
+		repo:      repo,
+		authServ:  authServ,
+		usersServ: usersServ,
+		crypt:     crypt,
+		issuer:    "https://auth.example.com",
+
+		deviceLimiter: ratelimit.New(c, "device", deviceLimit, deviceWindow),
+	}
+
+	return &mockService{
+		service:   serv,
+		repo:      repo,
+		authServ:  authServ,
+		usersServ: usersServ,
+		crypt:     crypt,
+	}
+}
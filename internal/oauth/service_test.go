@@ -0,0 +1,241 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterClient(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *RegisterClientRequest
+		err  error
+		mock func(s *mockService)
+	}{{
+		"without name",
+		&RegisterClientRequest{},
+		ErrInvalidRequest.F("name", "required"),
+		nil,
+	}, {
+		"invalid scope",
+		&RegisterClientRequest{Name: "Gateway", Scopes: []string{"read write"}},
+		ErrInvalidScope.F("scopes", "invalid").C("scope", "read write"),
+		nil,
+	}, {
+		"invalid redirect URI",
+		&RegisterClientRequest{Name: "SPA", RedirectURIs: []string{"http://app.example.com/callback"}},
+		ErrInvalidRequest.F("redirect_uris", "invalid").C("uri", "http://app.example.com/callback"),
+		nil,
+	}, {
+		"public without redirect URIs",
+		&RegisterClientRequest{Name: "SPA", Public: true},
+		ErrInvalidRequest.F("redirect_uris", "required"),
+		nil,
+	}, {
+		"hash error",
+		&RegisterClientRequest{Name: "Gateway"},
+		ErrServerError,
+		func(s *mockService) {
+			s.crypt.On("Hash", mock.AnythingOfType("string")).Return("", errors.Internal.New("hash"))
+		},
+	}, {
+		"valid",
+		&RegisterClientRequest{Name: "Gateway", Scopes: []string{"introspect"}},
+		nil,
+		func(s *mockService) {
+			s.crypt.On("Hash", mock.AnythingOfType("string")).Return("hashed.secret", nil)
+		},
+	}, {
+		"public",
+		&RegisterClientRequest{
+			Name:         "SPA",
+			Public:       true,
+			RedirectURIs: []string{"https://app.example.com/callback"},
+			Scopes:       []string{"openid"},
+		},
+		nil,
+		nil,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := newMockService()
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			client, secret, err := serv.RegisterClient(test.req)
+
+			if test.err != nil {
+				if assert.NotNil(err) {
+					assert.True(err.(errors.Error).Equals(test.err.(errors.Error)))
+					assert.Equal(test.err.(errors.Error).Fields, err.(errors.Error).Fields)
+				}
+				assert.Nil(client)
+				assert.Empty(secret)
+			} else {
+				assert.Nil(err)
+				if test.req.Public {
+					assert.Empty(secret)
+				} else {
+					assert.Len(secret, 43)
+					serv.crypt.AssertCalled(t, "Hash", secret)
+				}
+				if assert.NotNil(client) {
+					assert.Equal(test.req.Public, client.Public)
+					assert.Equal(test.req.Scopes, client.Scopes)
+
+					stored, err := serv.repo.FindClient(client.ID)
+					assert.Nil(err)
+					assert.Equal(client.ID, stored.ID)
+					assert.Equal(client.Secret, stored.Secret)
+				}
+			}
+			serv.crypt.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	mClient := models.NewClient()
+	mClient.Secret = "hashed.secret"
+	mPublic := mockPublicClient()
+
+	tests := []struct {
+		name     string
+		clientID string
+		secret   string
+		err      error
+		mock     func(s *mockService)
+	}{{
+		"empty credentials",
+		"", "",
+		ErrInvalidClient,
+		nil,
+	}, {
+		"unknown client",
+		"unknown", "secret",
+		ErrInvalidClient.C("id", "unknown").Wrap(ErrRepositoryNotFound),
+		nil,
+	}, {
+		"wrong secret",
+		mClient.ID, "wrong",
+		ErrInvalidClient.C("id", mClient.ID),
+		func(s *mockService) {
+			s.crypt.On("Compare", "hashed.secret", "wrong").Return(false)
+		},
+	}, {
+		"confidential without secret",
+		mClient.ID, "",
+		ErrInvalidClient.C("id", mClient.ID),
+		nil,
+	}, {
+		"public with secret",
+		mPublic.ID, "secret",
+		ErrInvalidClient.C("id", mPublic.ID),
+		nil,
+	}, {
+		"public",
+		mPublic.ID, "",
+		nil,
+		nil,
+	}, {
+		"valid",
+		mClient.ID, "secret",
+		nil,
+		func(s *mockService) {
+			s.crypt.On("Compare", "hashed.secret", "secret").Return(true)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := newMockService()
+			require.Nil(t, serv.repo.InsertClient(mClient))
+			require.Nil(t, serv.repo.InsertClient(mPublic))
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			client, err := serv.Authenticate(test.clientID, test.secret)
+
+			if test.err != nil {
+				if assert.NotNil(err) {
+					errors.Assert(t, test.err, err)
+				}
+				assert.Nil(client)
+			} else {
+				assert.Nil(err)
+				if assert.NotNil(client) {
+					assert.Equal(test.clientID, client.ID)
+				}
+			}
+			serv.crypt.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	mToken := models.NewToken("user123")
+	mToken.ClientID = "client123"
+	mToken.Scope = "openid profile"
+	mToken.Expires(time.Hour)
+
+	tests := []struct {
+		name     string
+		tokenStr string
+		expected *Introspection
+		mock     func(s *mockService)
+	}{{
+		"invalid or revoked",
+		"revoked.token",
+		&Introspection{Active: false},
+		func(s *mockService) {
+			s.authServ.On("Validate", "revoked.token").Return(nil, auth.ErrValidate)
+		},
+	}, {
+		"expired",
+		"expired.token",
+		&Introspection{Active: false},
+		func(s *mockService) {
+			s.authServ.On("Validate", "expired.token").Return(nil, auth.ErrExpired)
+		},
+	}, {
+		"active",
+		"valid.token",
+		&Introspection{
+			Active:    true,
+			Sub:       "user123",
+			Exp:       time.Unix(0, mToken.ExpiresAt).Unix(),
+			Iat:       time.Unix(0, mToken.CreatedAt).Unix(),
+			Jti:       mToken.ID,
+			Scope:     "openid profile",
+			ClientID:  "client123",
+			TokenType: "Bearer",
+		},
+		func(s *mockService) {
+			s.authServ.On("Validate", "valid.token").Return(mToken, nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := newMockService()
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			assert.Equal(t, test.expected, serv.Introspect(test.tokenStr))
+			serv.authServ.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package users
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+// getJSON decodes the value of key in c into dst. A missing key is
+// ErrRepositoryNotFound; a cache that cannot be read is ErrRepositoryBackend.
+func getJSON(c cache.Cache, key string, dst interface{}) error {
+	v, err := c.Get(key)
+	if err != nil {
+		return cacheError(err)
+	}
+	return decodeJSON(v, dst)
+}
+
+// takeJSON is getJSON deleting the key at once, so of concurrent calls only
+// one finds it.
+func takeJSON(c cache.Cache, key string, dst interface{}) error {
+	v, err := c.Take(key)
+	if err != nil {
+		return cacheError(err)
+	}
+	return decodeJSON(v, dst)
+}
+
+func cacheError(err error) error {
+	if cErr, ok := err.(errors.Error); !ok || !cErr.Equals(cache.ErrCacheNotFound) {
+		return ErrRepositoryBackend.Wrap(err)
+	}
+	return ErrRepositoryNotFound.Wrap(err)
+}
+
+// decodeJSON decodes a value read from the cache into dst.
+func decodeJSON(v interface{}, dst interface{}) error {
+	if v == nil {
+		return ErrRepositoryNotFound
+	}
+
+	var b []byte
+	switch v := v.(type) {
+	case []byte:
+		b = v
+	case string: // Redis
+		b = []byte(v)
+	default:
+		return ErrRepositoryNotFound.M("wrong conversion")
+	}
+
+	if err := json.Unmarshal(b, dst); err != nil {
+		return ErrRepositoryNotFound.Wrap(err)
+	}
+	return nil
+}
+
+// setJSON stores v in key for ttl, cache.NoExpiration included.
+func setJSON(c cache.Cache, key string, v interface{}, ttl time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	if err := c.Set(key, b, ttl); err != nil {
+		return ErrRepositoryInsert.Wrap(err)
+	}
+	return nil
+}
+
+// isRepositoryNotFound reports whether err is a missing key, not a failure.
+func isRepositoryNotFound(err error) bool {
+	rErr, ok := err.(errors.Error)
+	return ok && rErr.Equals(ErrRepositoryNotFound)
+}
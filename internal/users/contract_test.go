@@ -0,0 +1,143 @@
+package users_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/internal/users/userstest"
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/db"
+	"github.com/stretchr/testify/require"
+)
+
+// pgTestSchema is where the migrations are applied, apart from whatever
+// other tests leave in the test database.
+const pgTestSchema = "users_contract"
+
+// connectPostgres connects to the test database with migrations/*.sql applied
+// in order to an empty pgTestSchema. The statements creating databases and
+// connecting to them are skipped: the test database exists already.
+func connectPostgres(t *testing.T) *sql.DB {
+	c := config.Get()
+	conn, err := db.ConnectPostgres(c.PostgresURL, "test", c.PostgresUsername, c.PostgresPassword)
+	require.Nil(t, err)
+
+	// search_path is set per connection
+	conn.SetMaxOpenConns(1)
+	_, err = conn.Exec(fmt.Sprintf(
+		"DROP SCHEMA IF EXISTS %[1]s CASCADE; CREATE SCHEMA %[1]s; SET search_path TO %[1]s",
+		pgTestSchema,
+	))
+	require.Nil(t, err)
+
+	files, err := filepath.Glob("../../migrations/*.sql")
+	require.Nil(t, err)
+	require.NotEmpty(t, files)
+	sort.Strings(files)
+
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		require.Nil(t, err)
+
+		lines := strings.Split(string(b), "\n")
+		stmts := make([]string, 0, len(lines))
+		for _, line := range lines {
+			if strings.HasPrefix(line, "\\c ") ||
+				strings.HasPrefix(line, "CREATE DATABASE ") ||
+				strings.HasPrefix(line, "GRANT ALL PRIVILEGES ON DATABASE ") {
+				continue
+			}
+			stmts = append(stmts, line)
+		}
+
+		_, err = conn.Exec(strings.Join(stmts, "\n"))
+		require.Nil(t, err, file)
+	}
+
+	return conn
+}
+
+func TestInMemoryRepositoryContract(t *testing.T) {
+	userstest.RepositoryContract(t, func(t *testing.T) users.Repository {
+		return users.NewInMemoryRepository()
+	})
+}
+
+func TestPostgresRepositoryContract(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	conn := connectPostgres(t)
+	defer conn.Close()
+
+	userstest.RepositoryContract(t, func(t *testing.T) users.Repository {
+		_, err := conn.Exec("TRUNCATE users")
+		require.Nil(t, err)
+		return users.NewPostgresRepository(conn)
+	})
+}
+
+func TestMongoRepositoryContract(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	c := config.Get()
+	conn, err := db.ConnectMongo(c.MongoURL, "test", c.MongoUsername, c.MongoPassword)
+	require.Nil(t, err)
+
+	userstest.RepositoryContract(t, func(t *testing.T) users.Repository {
+		err := conn.Collection("users").Drop(context.Background())
+		require.Nil(t, err)
+		repo, err := users.NewMongoRepository(conn)
+		require.Nil(t, err)
+		return repo
+	})
+}
+
+func TestInMemoryWebAuthnRepositoryContract(t *testing.T) {
+	userstest.WebAuthnRepositoryContract(t, func(t *testing.T) users.WebAuthnRepository {
+		return users.NewInMemoryWebAuthnRepository()
+	})
+}
+
+func TestPostgresWebAuthnRepositoryContract(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	conn := connectPostgres(t)
+	defer conn.Close()
+
+	userstest.WebAuthnRepositoryContract(t, func(t *testing.T) users.WebAuthnRepository {
+		_, err := conn.Exec("TRUNCATE webauthn_credentials")
+		require.Nil(t, err)
+		return users.NewPostgresWebAuthnRepository(conn)
+	})
+}
+
+func TestMongoWebAuthnRepositoryContract(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	c := config.Get()
+	conn, err := db.ConnectMongo(c.MongoURL, "test", c.MongoUsername, c.MongoPassword)
+	require.Nil(t, err)
+
+	userstest.WebAuthnRepositoryContract(t, func(t *testing.T) users.WebAuthnRepository {
+		err := conn.Collection("webauthn_credentials").Drop(context.Background())
+		require.Nil(t, err)
+		repo, err := users.NewMongoWebAuthnRepository(conn)
+		require.Nil(t, err)
+		return repo
+	})
+}
@@ -12,6 +12,7 @@ type UserDTO struct {
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	Lastname string `json:"lastname"`
+	Locale   string `json:"locale"`
 
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
@@ -25,9 +26,36 @@ func NewDTO(user *models.User) *UserDTO {
 		Email:    user.Email,
 		Name:     user.Name,
 		Lastname: user.Lastname,
+		Locale:   user.Locale,
 
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 		Validated: user.Validated,
 	}
 }
+
+type WebAuthnCredentialDTO struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Transports []string `json:"transports"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// NewWebAuthnCredentialDTO hides the public key of cred. LastUsedAt is null
+// for credentials never used to log in.
+func NewWebAuthnCredentialDTO(cred *models.WebAuthnCredential) *WebAuthnCredentialDTO {
+	dto := &WebAuthnCredentialDTO{
+		ID:         cred.ID,
+		Name:       cred.Name,
+		Transports: cred.Transports,
+
+		CreatedAt: time.Unix(0, cred.CreatedAt).UTC(),
+	}
+	if cred.LastUsedAt != 0 {
+		lastUsedAt := time.Unix(0, cred.LastUsedAt).UTC()
+		dto.LastUsedAt = &lastUsedAt
+	}
+	return dto
+}
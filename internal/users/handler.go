@@ -0,0 +1,572 @@
+package users
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/server"
+	"github.com/gin-gonic/gin"
+)
+
+// Module is the name API key permissions are granted on for users.
+const Module = "users"
+
+// Errors
+var (
+	ErrInvalidRequest = errors.Validation.New("user.handler.invalid_request").S(400)
+	ErrForbidden      = errors.Status.New("user.handler.forbidden").S(403)
+)
+
+type Handler struct {
+	serv     Service
+	authServ auth.Service
+}
+
+func NewHandler(serv Service, authServ auth.Service) *Handler {
+	return &Handler{
+		serv:     serv,
+		authServ: authServ,
+	}
+}
+
+// Routes registers the users and auth endpoints in r.
+func (h *Handler) Routes(r gin.IRouter) {
+	r.POST("/users", h.register)
+	r.POST("/auth/login", h.login)
+	r.POST("/auth/login/mfa", h.loginMFA)
+	r.POST("/auth/login/magic-link", h.loginWithMagicLink)
+	r.POST("/auth/magic-link", h.requestMagicLink)
+	r.POST("/auth/login/webauthn/begin", h.beginWebAuthnLogin)
+	r.POST("/auth/login/webauthn/finish", h.finishWebAuthnLogin)
+	r.POST("/auth/refresh", h.refresh)
+	r.POST("/users/verification", h.verify)
+	r.POST("/users/verification/resend", h.resendVerification)
+	r.POST("/users/password/forgot", h.forgotPassword)
+	r.POST("/users/password/reset", h.resetPassword)
+
+	authorized := r.Group("/", auth.Middleware(h.authServ))
+	authorized.GET("/users/:id", auth.RequirePermission(Module, models.READ), h.get)
+	// Updating and deleting a user change its credentials, so API keys
+	// cannot, like below
+	authorized.PUT("/users/:id", auth.RejectAPIKeys(), auth.RequireFirstParty(), auth.RequirePermission(Module, models.UPDATE), h.owner, h.update)
+	authorized.DELETE("/users/:id", auth.RejectAPIKeys(), auth.RequireFirstParty(), auth.RequirePermission(Module, models.DELETE), h.owner, h.delete)
+
+	// Credentials are managed with tokens from a login, not with API keys
+	// or the tokens of OAuth clients
+	login := authorized.Group("/", auth.RejectAPIKeys(), auth.RequireFirstParty())
+	login.POST("/auth/logout", h.logout)
+	login.GET("/auth/sessions", h.sessions)
+	login.DELETE("/auth/sessions", h.revokeSessions)
+	login.DELETE("/auth/sessions/:id", h.revokeSession)
+	login.GET("/auth/api-keys", h.apiKeys)
+	login.POST("/auth/api-keys", h.createAPIKey)
+	login.DELETE("/auth/api-keys/:id", h.revokeAPIKey)
+	login.POST("/auth/mfa/totp", h.enrollTOTP)
+	login.POST("/auth/mfa/totp/confirm", h.confirmTOTP)
+	login.DELETE("/auth/mfa/totp", h.disableTOTP)
+	login.POST("/auth/webauthn/register/begin", h.beginWebAuthnRegistration)
+	login.POST("/auth/webauthn/register/finish", h.finishWebAuthnRegistration)
+	login.GET("/auth/webauthn/credentials", h.webAuthnCredentials)
+	login.DELETE("/auth/webauthn/credentials/:id", h.deleteWebAuthnCredential)
+}
+
+func (h *Handler) register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+	if req.Locale == "" {
+		req.Locale = acceptedLocale(c.GetHeader("Accept-Language"))
+	}
+
+	user, err := h.serv.Register(&req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, NewDTO(user))
+}
+
+// acceptedLocale is the preferred language of an Accept-Language header, or
+// empty when it names none usable.
+func acceptedLocale(header string) string {
+	tag := strings.TrimSpace(strings.Split(strings.Split(header, ",")[0], ";")[0])
+	if len(tag) > 16 || !localeRE.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
+
+type VerifyRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func (h *Handler) verify(c *gin.Context) {
+	var req VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	user, err := h.serv.VerifyEmail(req.Token)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewDTO(user))
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// resendVerification answers the same for every address.
+func (h *Handler) resendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	if err := h.serv.ResendVerification(req.Email); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// forgotPassword answers the same for every address.
+func (h *Handler) forgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	if err := h.serv.RequestPasswordReset(req.Email); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (h *Handler) resetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	if err := h.serv.ResetPassword(req.Token, req.Password); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) get(c *gin.Context) {
+	user, err := h.serv.GetByID(c.Param("id"))
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewDTO(user))
+}
+
+func (h *Handler) update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	if token := auth.Token(c); token != nil {
+		req.SessionID = token.SessionID
+	}
+
+	user, err := h.serv.Update(c.Param("id"), &req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewDTO(user))
+}
+
+func (h *Handler) delete(c *gin.Context) {
+	if err := h.serv.Delete(c.Param("id")); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	res, err := h.serv.Login(&req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) loginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	pair, err := h.serv.LoginMFA(&req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// requestMagicLink answers the same for every address.
+func (h *Handler) requestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	if err := h.serv.RequestMagicLink(req.Email); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) loginWithMagicLink(c *gin.Context) {
+	var req MagicLinkLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	res, err := h.serv.LoginWithMagicLink(&req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+type BeginWebAuthnLoginRequest struct {
+	// MFAToken is the token of a login with password, when the passkey is
+	// the second factor. Without it, the login is with the passkey alone.
+	MFAToken string `json:"mfa_token"`
+}
+
+// beginWebAuthnLogin answers the publicKey options of
+// navigator.credentials.get.
+func (h *Handler) beginWebAuthnLogin(c *gin.Context) {
+	var req BeginWebAuthnLoginRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+			return
+		}
+	}
+
+	opts, err := h.serv.BeginWebAuthnLogin(req.MFAToken)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, opts)
+}
+
+func (h *Handler) finishWebAuthnLogin(c *gin.Context) {
+	var req WebAuthnLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	pair, err := h.serv.FinishWebAuthnLogin(&req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (h *Handler) refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	pair, err := h.serv.Refresh(req.RefreshToken)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+func (h *Handler) logout(c *gin.Context) {
+	if err := h.serv.Logout(auth.TokenStr(c)); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) sessions(c *gin.Context) {
+	token := auth.Token(c)
+	sessions, err := h.authServ.ListSessions(token.UserID)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	dtos := make([]*auth.SessionDTO, 0, len(sessions))
+	for _, session := range sessions {
+		dtos = append(dtos, auth.NewSessionDTO(session, token))
+	}
+
+	c.JSON(http.StatusOK, dtos)
+}
+
+func (h *Handler) revokeSession(c *gin.Context) {
+	token := auth.Token(c)
+	if err := h.authServ.RevokeSession(token.UserID, c.Param("id")); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeSessions logs the user out everywhere but in the current session.
+func (h *Handler) revokeSessions(c *gin.Context) {
+	token := auth.Token(c)
+	if err := h.authServ.RevokeAll(token.UserID, token.SessionID); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) apiKeys(c *gin.Context) {
+	keys, err := h.authServ.ListAPIKeys(auth.Token(c).UserID)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	dtos := make([]*auth.APIKeyDTO, 0, len(keys))
+	for _, key := range keys {
+		dtos = append(dtos, auth.NewAPIKeyDTO(key))
+	}
+
+	c.JSON(http.StatusOK, dtos)
+}
+
+type createAPIKeyResponse struct {
+	*auth.APIKeyDTO
+	Key string `json:"key"`
+}
+
+// createAPIKey answers the key in plain text, which is only shown once.
+func (h *Handler) createAPIKey(c *gin.Context) {
+	var req auth.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	token := auth.Token(c)
+	req.UserID = token.UserID
+	req.Role = token.Role
+
+	key, keyStr, err := h.authServ.CreateAPIKey(&req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, &createAPIKeyResponse{auth.NewAPIKeyDTO(key), keyStr})
+}
+
+func (h *Handler) revokeAPIKey(c *gin.Context) {
+	if err := h.authServ.RevokeAPIKey(auth.Token(c).UserID, c.Param("id")); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) enrollTOTP(c *gin.Context) {
+	enrollment, err := h.serv.EnrollTOTP(auth.Token(c).UserID)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type confirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// confirmTOTP answers the recovery codes, which are only shown once.
+func (h *Handler) confirmTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	codes, err := h.serv.ConfirmTOTP(auth.Token(c).UserID, req.Code)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, &confirmTOTPResponse{codes})
+}
+
+func (h *Handler) disableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	if err := h.serv.DisableTOTP(auth.Token(c).UserID, req.Code); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// beginWebAuthnRegistration answers the publicKey options of
+// navigator.credentials.create.
+func (h *Handler) beginWebAuthnRegistration(c *gin.Context) {
+	opts, err := h.serv.BeginWebAuthnRegistration(auth.Token(c).UserID)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, opts)
+}
+
+func (h *Handler) finishWebAuthnRegistration(c *gin.Context) {
+	var req WebAuthnRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		server.Error(c, ErrInvalidRequest.M("%s", err.Error()))
+		return
+	}
+
+	cred, err := h.serv.FinishWebAuthnRegistration(auth.Token(c).UserID, &req)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, NewWebAuthnCredentialDTO(cred))
+}
+
+func (h *Handler) webAuthnCredentials(c *gin.Context) {
+	creds, err := h.serv.ListWebAuthnCredentials(auth.Token(c).UserID)
+	if err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	dtos := make([]*WebAuthnCredentialDTO, 0, len(creds))
+	for _, cred := range creds {
+		dtos = append(dtos, NewWebAuthnCredentialDTO(cred))
+	}
+
+	c.JSON(http.StatusOK, dtos)
+}
+
+func (h *Handler) deleteWebAuthnCredential(c *gin.Context) {
+	if err := h.serv.DeleteWebAuthnCredential(auth.Token(c).UserID, c.Param("id")); err != nil {
+		server.Error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// owner only lets users modify their own account.
+func (h *Handler) owner(c *gin.Context) {
+	token := auth.Token(c)
+	if token == nil || token.UserID != c.Param("id") {
+		server.Error(c, ErrForbidden.C("id", c.Param("id")))
+		return
+	}
+	c.Next()
+}
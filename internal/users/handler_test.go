@@ -0,0 +1,612 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestRouter(s *mockService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewHandler(s, s.authServ).Routes(r)
+	return r
+}
+
+func TestHandler(t *testing.T) {
+	mUser := mockUser()
+	mToken := models.NewToken(mUser.ID)
+	mToken.SessionID = "session123"
+	mTokenStr := "encoded.token"
+
+	authorized := func(s *mockService) {
+		s.authServ.On("Validate", mTokenStr).Return(mToken, nil)
+	}
+	mKey := models.NewAPIKey("a1b2c3d4e5f6", mUser.ID)
+	mKey.Prefix = "bb_a1b2c3d4e5f6"
+	mKey.Permissions = map[string][]models.Permission{Module: {models.READ}}
+	mKeyStr := "bb_a1b2c3d4e5f6_secret"
+	withAPIKey := func(s *mockService) {
+		s.authServ.On("ValidateAPIKey", mKeyStr).Return(mKey, nil)
+	}
+	mWriteKey := models.NewAPIKey("f6e5d4c3b2a1", mUser.ID)
+	mWriteKey.Prefix = "bb_f6e5d4c3b2a1"
+	mWriteKey.Permissions = map[string][]models.Permission{Module: {models.READ, models.UPDATE, models.DELETE}}
+	mWriteKeyStr := "bb_f6e5d4c3b2a1_secret"
+	withWriteAPIKey := func(s *mockService) {
+		s.authServ.On("ValidateAPIKey", mWriteKeyStr).Return(mWriteKey, nil)
+	}
+	mClientToken := models.NewToken(mUser.ID)
+	mClientToken.ClientID = "client123"
+	mClientToken.Scope = "openid " + auth.PermissionScope(Module, models.READ)
+	mClientTokenStr := "client.token"
+	withClientToken := func(s *mockService) {
+		s.authServ.On("Validate", mClientTokenStr).Return(mClientToken, nil)
+	}
+	mMachineToken := models.NewToken("client123")
+	mMachineToken.ClientID = "client123"
+	mMachineToken.Scope = auth.PermissionScope(Module, models.READ)
+	mMachineToken.Machine = true
+	mMachineTokenStr := "machine.token"
+	withMachineToken := func(s *mockService) {
+		s.authServ.On("Validate", mMachineTokenStr).Return(mMachineToken, nil)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   interface{}
+		status int
+		mock   func(s *mockService)
+	}{{
+		"register with invalid body",
+		"POST", "/users", "",
+		"not an object",
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"register",
+		"POST", "/users", "",
+		&RegisterRequest{
+			Username: "user",
+			Password: "12345678",
+			Email:    "user@user.com",
+			Name:     "Name",
+			Lastname: "Lastname",
+		},
+		http.StatusCreated,
+		func(s *mockService) {
+			s.repo.On("FindByUsername", "user").Return(nil, ErrRepositoryNotFound)
+			s.repo.On("FindByEmail", "user@user.com").Return(nil, ErrRepositoryNotFound)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.validator.On("ValidatePassword", "12345678").Return(nil)
+			s.crypt.On("Hash", "12345678").Return("hashed.password", nil)
+			s.repo.On("Insert", mock.AnythingOfType("*models.User")).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+			s.authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("verification.token", nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.TokenEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+		},
+	}, {
+		"verify email with invalid token",
+		"POST", "/users/verification", "",
+		&VerifyRequest{Token: "invalid"},
+		http.StatusBadRequest,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "invalid", ActionVerifyEmail).Return(nil, auth.ErrInvalidAction)
+		},
+	}, {
+		"verify email",
+		"POST", "/users/verification", "",
+		&VerifyRequest{Token: "verification.token"},
+		http.StatusOK,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Validated = false
+			s.authServ.On("VerifyAction", "verification.token", ActionVerifyEmail).Return(&auth.ActionClaims{
+				StandardClaims: jwt.StandardClaims{Subject: mUser.ID},
+				Action:         ActionVerifyEmail,
+				Email:          mUser.Email,
+			}, nil)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+		},
+	}, {
+		"resend verification without email",
+		"POST", "/users/verification/resend", "",
+		&ResendVerificationRequest{},
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"resend verification to unknown email",
+		"POST", "/users/verification/resend", "",
+		&ResendVerificationRequest{Email: "unknown@email.com"},
+		http.StatusNoContent,
+		func(s *mockService) {
+			s.repo.On("FindByEmail", "unknown@email.com").Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"forgot password for unknown email",
+		"POST", "/users/password/forgot", "",
+		&ForgotPasswordRequest{Email: "unknown@email.com"},
+		http.StatusNoContent,
+		func(s *mockService) {
+			s.repo.On("FindByEmail", "unknown@email.com").Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"reset password without password",
+		"POST", "/users/password/reset", "",
+		&ResetPasswordRequest{Token: "reset.token"},
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"reset password with unknown token",
+		"POST", "/users/password/reset", "",
+		&ResetPasswordRequest{Token: "reset.token", Password: "new-password"},
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"get without token",
+		"GET", "/users/" + mUser.ID, "",
+		nil,
+		http.StatusUnauthorized,
+		nil,
+	}, {
+		"get with invalid token",
+		"GET", "/users/" + mUser.ID, "invalid",
+		nil,
+		http.StatusUnauthorized,
+		func(s *mockService) {
+			s.authServ.On("Validate", "invalid").Return(nil, auth.ErrValidate)
+		},
+	}, {
+		"get not found",
+		"GET", "/users/abc123", mTokenStr,
+		nil,
+		http.StatusNotFound,
+		func(s *mockService) {
+			authorized(s)
+			s.repo.On("FindByID", "abc123").Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"get",
+		"GET", "/users/" + mUser.ID, mTokenStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			authorized(s)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+		},
+	}, {
+		"update another user",
+		"PUT", "/users/abc123", mTokenStr,
+		&UpdateRequest{},
+		http.StatusForbidden,
+		authorized,
+	}, {
+		"update",
+		"PUT", "/users/" + mUser.ID, mTokenStr,
+		map[string]string{"name": "Other"},
+		http.StatusOK,
+		func(s *mockService) {
+			authorized(s)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+		},
+	}, {
+		"delete another user",
+		"DELETE", "/users/abc123", mTokenStr,
+		nil,
+		http.StatusForbidden,
+		authorized,
+	}, {
+		"delete",
+		"DELETE", "/users/" + mUser.ID, mTokenStr,
+		nil,
+		http.StatusNoContent,
+		func(s *mockService) {
+			authorized(s)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil)
+			s.repo.On("Delete", mUser.ID).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+		},
+	}, {
+		"login",
+		"POST", "/auth/login", "",
+		map[string]string{"username_or_email": "user", "password": "12345678"},
+		http.StatusOK,
+		func(s *mockService) {
+			s.repo.On("FindByUsername", "user").Return(mUser, nil)
+			s.crypt.On("Compare", mUser.Password, "12345678").Return(true)
+			s.authServ.On("Create", mock.MatchedBy(func(req *auth.CreateRequest) bool {
+				return req.UserID == mUser.ID && req.UserAgent == "test-agent"
+			})).Return(&auth.TokenPair{AccessToken: mTokenStr}, nil)
+		},
+	}, {
+		"refresh without token",
+		"POST", "/auth/refresh", "",
+		map[string]string{},
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"refresh reused token",
+		"POST", "/auth/refresh", "",
+		map[string]string{"refresh_token": "refresh.token"},
+		http.StatusUnauthorized,
+		func(s *mockService) {
+			s.authServ.On("Refresh", &auth.RefreshRequest{RefreshToken: "refresh.token"}).Return(nil, auth.ErrRefreshReuse)
+		},
+	}, {
+		"refresh",
+		"POST", "/auth/refresh", "",
+		map[string]string{"refresh_token": "refresh.token"},
+		http.StatusOK,
+		func(s *mockService) {
+			s.authServ.On("Refresh", &auth.RefreshRequest{RefreshToken: "refresh.token"}).Return(&auth.TokenPair{AccessToken: mTokenStr}, nil)
+		},
+	}, {
+		"logout",
+		"POST", "/auth/logout", mTokenStr,
+		nil,
+		http.StatusNoContent,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("Invalidate", mTokenStr).Return(mToken, nil)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+		},
+	}, {
+		"list sessions",
+		"GET", "/auth/sessions", mTokenStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			authorized(s)
+			session := models.NewSession(mUser.ID)
+			session.ID = mToken.SessionID
+			session.TokenIDs = append(session.TokenIDs, mToken.ID)
+			s.authServ.On("ListSessions", mUser.ID).Return([]*models.Session{session}, nil)
+		},
+	}, {
+		"revoke session not found",
+		"DELETE", "/auth/sessions/other", mTokenStr,
+		nil,
+		http.StatusNotFound,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("RevokeSession", mUser.ID, "other").Return(auth.ErrNoSession)
+		},
+	}, {
+		"revoke session",
+		"DELETE", "/auth/sessions/other", mTokenStr,
+		nil,
+		http.StatusNoContent,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("RevokeSession", mUser.ID, "other").Return(nil)
+		},
+	}, {
+		"revoke all other sessions",
+		"DELETE", "/auth/sessions", mTokenStr,
+		nil,
+		http.StatusNoContent,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("RevokeAll", mUser.ID, []string{mToken.SessionID}).Return(nil)
+		},
+	}, {
+		"get with API key",
+		"GET", "/users/" + mUser.ID, mKeyStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			withAPIKey(s)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+		},
+	}, {
+		"update without API key permission",
+		"PUT", "/users/" + mUser.ID, mKeyStr,
+		&UpdateRequest{},
+		http.StatusForbidden,
+		withAPIKey,
+	}, {
+		"update with API key",
+		"PUT", "/users/" + mUser.ID, mWriteKeyStr,
+		&UpdateRequest{},
+		http.StatusForbidden,
+		withWriteAPIKey,
+	}, {
+		"delete with API key",
+		"DELETE", "/users/" + mUser.ID, mWriteKeyStr,
+		nil,
+		http.StatusForbidden,
+		withWriteAPIKey,
+	}, {
+		"list sessions with API key",
+		"GET", "/auth/sessions", mKeyStr,
+		nil,
+		http.StatusForbidden,
+		withAPIKey,
+	}, {
+		"create API key with API key",
+		"POST", "/auth/api-keys", mKeyStr,
+		&auth.CreateAPIKeyRequest{Name: "ci", Permissions: mKey.Permissions},
+		http.StatusForbidden,
+		withAPIKey,
+	}, {
+		"create API key",
+		"POST", "/auth/api-keys", mTokenStr,
+		&auth.CreateAPIKeyRequest{Name: "ci", Permissions: mKey.Permissions},
+		http.StatusCreated,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("CreateAPIKey", &auth.CreateAPIKeyRequest{
+				UserID:      mUser.ID,
+				Role:        mToken.Role,
+				Name:        "ci",
+				Permissions: mKey.Permissions,
+			}).Return(mKey, mKeyStr, nil)
+		},
+	}, {
+		"list API keys",
+		"GET", "/auth/api-keys", mTokenStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("ListAPIKeys", mUser.ID).Return([]*models.APIKey{mKey}, nil)
+		},
+	}, {
+		"revoke API key",
+		"DELETE", "/auth/api-keys/" + mKey.ID, mTokenStr,
+		nil,
+		http.StatusNoContent,
+		func(s *mockService) {
+			authorized(s)
+			s.authServ.On("RevokeAPIKey", mUser.ID, mKey.ID).Return(nil)
+		},
+	}, {
+		"login with second factor without code",
+		"POST", "/auth/login/mfa", "",
+		&MFALoginRequest{MFAToken: "mfa.token"},
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"login with second factor and invalid challenge",
+		"POST", "/auth/login/mfa", "",
+		&MFALoginRequest{MFAToken: "mfa.token", Code: "123456"},
+		http.StatusUnauthorized,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "mfa.token", ActionMFA).Return(nil, auth.ErrInvalidAction)
+		},
+	}, {
+		"enroll TOTP with API key",
+		"POST", "/auth/mfa/totp", mKeyStr,
+		nil,
+		http.StatusForbidden,
+		withAPIKey,
+	}, {
+		"enroll TOTP",
+		"POST", "/auth/mfa/totp", mTokenStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			authorized(s)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+		},
+	}, {
+		"confirm TOTP without enrollment",
+		"POST", "/auth/mfa/totp/confirm", mTokenStr,
+		&TOTPCodeRequest{Code: "123456"},
+		http.StatusNotFound,
+		authorized,
+	}, {
+		"disable TOTP without code",
+		"DELETE", "/auth/mfa/totp", mTokenStr,
+		nil,
+		http.StatusBadRequest,
+		authorized,
+	}, {
+		"request magic link for unknown email",
+		"POST", "/auth/magic-link", "",
+		&MagicLinkRequest{Email: "unknown@email.com"},
+		http.StatusNoContent,
+		func(s *mockService) {
+			s.repo.On("FindByEmail", "unknown@email.com").Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"login with magic link without token",
+		"POST", "/auth/login/magic-link", "",
+		nil,
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"login with invalid magic link",
+		"POST", "/auth/login/magic-link", "",
+		&MagicLinkLoginRequest{Token: "magic.token"},
+		http.StatusUnauthorized,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "magic.token", ActionMagicLink).Return(nil, auth.ErrInvalidAction)
+		},
+	}, {
+		"begin login with passkey",
+		"POST", "/auth/login/webauthn/begin", "",
+		nil,
+		http.StatusOK,
+		nil,
+	}, {
+		"begin login with passkey and invalid challenge",
+		"POST", "/auth/login/webauthn/begin", "",
+		&BeginWebAuthnLoginRequest{MFAToken: "mfa.token"},
+		http.StatusUnauthorized,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "mfa.token", ActionMFA).Return(nil, auth.ErrInvalidAction)
+		},
+	}, {
+		"finish login with passkey without credential",
+		"POST", "/auth/login/webauthn/finish", "",
+		&WebAuthnLoginRequest{MFAToken: "mfa.token"},
+		http.StatusBadRequest,
+		nil,
+	}, {
+		"begin passkey registration with API key",
+		"POST", "/auth/webauthn/register/begin", mKeyStr,
+		nil,
+		http.StatusForbidden,
+		withAPIKey,
+	}, {
+		"begin passkey registration",
+		"POST", "/auth/webauthn/register/begin", mTokenStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			authorized(s)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+		},
+	}, {
+		"finish passkey registration without credential",
+		"POST", "/auth/webauthn/register/finish", mTokenStr,
+		&WebAuthnRegistrationRequest{Name: "Laptop"},
+		http.StatusBadRequest,
+		authorized,
+	}, {
+		"list passkeys",
+		"GET", "/auth/webauthn/credentials", mTokenStr,
+		nil,
+		http.StatusOK,
+		authorized,
+	}, {
+		"delete unknown passkey",
+		"DELETE", "/auth/webauthn/credentials/abc", mTokenStr,
+		nil,
+		http.StatusNotFound,
+		authorized,
+	}, {
+		"get with OAuth client token granted the scope",
+		"GET", "/users/" + mUser.ID, mClientTokenStr,
+		nil,
+		http.StatusOK,
+		func(s *mockService) {
+			withClientToken(s)
+			s.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+		},
+	}, {
+		"get with OAuth client token without the scope",
+		"GET", "/users/" + mUser.ID, mClientTokenStr,
+		nil,
+		http.StatusForbidden,
+		func(s *mockService) {
+			token := *mClientToken
+			token.Scope = "openid profile"
+			s.authServ.On("Validate", mClientTokenStr).Return(&token, nil)
+		},
+	}, {
+		"update with OAuth client token",
+		"PUT", "/users/" + mUser.ID, mClientTokenStr,
+		&UpdateRequest{},
+		http.StatusForbidden,
+		withClientToken,
+	}, {
+		"delete with OAuth client token",
+		"DELETE", "/users/" + mUser.ID, mClientTokenStr,
+		nil,
+		http.StatusForbidden,
+		withClientToken,
+	}, {
+		"list sessions with OAuth client token",
+		"GET", "/auth/sessions", mClientTokenStr,
+		nil,
+		http.StatusForbidden,
+		withClientToken,
+	}, {
+		"create API key with OAuth client token",
+		"POST", "/auth/api-keys", mClientTokenStr,
+		&auth.CreateAPIKeyRequest{Name: "ci", Permissions: mKey.Permissions},
+		http.StatusForbidden,
+		withClientToken,
+	}, {
+		"enroll TOTP with OAuth client token",
+		"POST", "/auth/mfa/totp", mClientTokenStr,
+		nil,
+		http.StatusForbidden,
+		withClientToken,
+	}, {
+		"get with machine token granted the scope",
+		"GET", "/users/" + mUser.ID, mMachineTokenStr,
+		nil,
+		http.StatusForbidden,
+		withMachineToken,
+	}, {
+		"list API keys with machine token",
+		"GET", "/auth/api-keys", mMachineTokenStr,
+		nil,
+		http.StatusForbidden,
+		withMachineToken,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := newMockService()
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			var body bytes.Buffer
+			if test.body != nil {
+				json.NewEncoder(&body).Encode(test.body)
+			}
+			req := httptest.NewRequest(test.method, test.path, &body)
+			req.Header.Set("User-Agent", "test-agent")
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			res := httptest.NewRecorder()
+
+			newTestRouter(serv).ServeHTTP(res, req)
+
+			assert.Equal(test.status, res.Code, res.Body.String())
+			if res.Code < 300 && res.Code != http.StatusNoContent {
+				assert.NotContains(res.Body.String(), "password")
+				assert.NotContains(res.Body.String(), mToken.ID)
+			}
+			serv.crypt.AssertExpectations(t)
+			serv.repo.AssertExpectations(t)
+			serv.validator.AssertExpectations(t)
+			serv.events.AssertExpectations(t)
+			serv.authServ.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAcceptedLocale(t *testing.T) {
+	tests := []struct {
+		header string
+		locale string
+	}{
+		{"", ""},
+		{"es-AR", "es-AR"},
+		{"es-AR,es;q=0.9,en;q=0.8", "es-AR"},
+		{"en;q=0.8, es", "en"},
+		{"*", ""},
+		{"not a locale", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.locale, acceptedLocale(test.header), test.header)
+	}
+}
@@ -0,0 +1,138 @@
+package users
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+type inMemoryRepository struct {
+	mux        sync.RWMutex
+	users      map[string]*models.User
+	byUsername map[string]string
+	byEmail    map[string]string
+}
+
+// NewInMemoryRepository returns a concurrency-safe repository whose username
+// and email indexes are case-insensitive. Users are copied on every read and
+// write, so callers never share state with the store.
+func NewInMemoryRepository() Repository {
+	return &inMemoryRepository{
+		users:      make(map[string]*models.User),
+		byUsername: make(map[string]string),
+		byEmail:    make(map[string]string),
+	}
+}
+
+func (r *inMemoryRepository) FindByID(id string) (*models.User, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrRepositoryNotFound.C("id", id)
+	}
+	return copyOf(u), nil
+}
+
+func (r *inMemoryRepository) FindByUsername(username string) (*models.User, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	id, ok := r.byUsername[indexKey(username)]
+	if !ok {
+		return nil, ErrRepositoryNotFound.C("username", username)
+	}
+	return copyOf(r.users[id]), nil
+}
+
+func (r *inMemoryRepository) FindByEmail(email string) (*models.User, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	id, ok := r.byEmail[indexKey(email)]
+	if !ok {
+		return nil, ErrRepositoryNotFound.C("email", email)
+	}
+	return copyOf(r.users[id]), nil
+}
+
+func (r *inMemoryRepository) Insert(u *models.User) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.users[u.ID]; ok {
+		return ErrNotAvailable.C("id", u.ID)
+	}
+	if vErr := r.notAvailable(u); vErr != nil {
+		return vErr
+	}
+
+	r.store(u)
+	return nil
+}
+
+func (r *inMemoryRepository) Update(u *models.User) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	old, ok := r.users[u.ID]
+	if !ok {
+		return ErrRepositoryNotFound.C("id", u.ID)
+	}
+	if vErr := r.notAvailable(u); vErr != nil {
+		return vErr
+	}
+
+	delete(r.byUsername, indexKey(old.Username))
+	delete(r.byEmail, indexKey(old.Email))
+	r.store(u)
+	return nil
+}
+
+func (r *inMemoryRepository) Delete(id string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+
+	delete(r.byUsername, indexKey(u.Username))
+	delete(r.byEmail, indexKey(u.Email))
+	delete(r.users, id)
+	return nil
+}
+
+// notAvailable checks the unique indexes against any user other than u.
+// Callers must hold the write lock.
+func (r *inMemoryRepository) notAvailable(u *models.User) error {
+	vErr := ErrNotAvailable
+	if id, ok := r.byUsername[indexKey(u.Username)]; ok && id != u.ID {
+		vErr = vErr.F("username", "not_available")
+	}
+	if id, ok := r.byEmail[indexKey(u.Email)]; ok && id != u.ID {
+		vErr = vErr.F("email", "not_available")
+	}
+	if len(vErr.Fields) > 0 {
+		return vErr
+	}
+	return nil
+}
+
+func (r *inMemoryRepository) store(u *models.User) {
+	r.users[u.ID] = copyOf(u)
+	r.byUsername[indexKey(u.Username)] = u.ID
+	r.byEmail[indexKey(u.Email)] = u.ID
+}
+
+func indexKey(s string) string {
+	return strings.ToLower(s)
+}
+
+func copyOf(u *models.User) *models.User {
+	c := *u
+	return &c
+}
@@ -0,0 +1,125 @@
+package users
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aboglioli/big-brother/mocks"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestInMemoryCaseInsensitiveIndexes(t *testing.T) {
+	assert := assert.New(t)
+	repo := NewInMemoryRepository()
+
+	user := mockUser()
+	require.Nil(t, repo.Insert(user))
+
+	found, err := repo.FindByUsername("USER")
+	assert.Nil(err)
+	if assert.NotNil(found) {
+		assert.Equal(user.ID, found.ID)
+	}
+
+	found, err = repo.FindByEmail("User@User.com")
+	assert.Nil(err)
+	if assert.NotNil(found) {
+		assert.Equal(user.ID, found.ID)
+	}
+
+	dup := mockUser()
+	dup.Username = "User"
+	dup.Email = "USER@user.com"
+	err = repo.Insert(dup)
+	errors.Assert(t, ErrNotAvailable.F("username", "not_available").F("email", "not_available"), err)
+
+	// Renaming frees the old username
+	user.Username = "renamed"
+	require.Nil(t, repo.Update(user))
+	_, err = repo.FindByUsername("user")
+	errors.Assert(t, ErrRepositoryNotFound, err)
+	dup.Email = "other@user.com"
+	assert.Nil(repo.Insert(dup))
+}
+
+func TestInMemoryCopyOnReadAndWrite(t *testing.T) {
+	assert := assert.New(t)
+	repo := NewInMemoryRepository()
+
+	user := mockUser()
+	require.Nil(t, repo.Insert(user))
+	user.Name = "Changed"
+
+	found, err := repo.FindByID(user.ID)
+	require.Nil(t, err)
+	assert.Equal("Name", found.Name)
+
+	found.Name = "Changed again"
+	again, err := repo.FindByID(user.ID)
+	require.Nil(t, err)
+	assert.Equal("Name", again.Name)
+}
+
+func TestInMemoryConcurrentInsert(t *testing.T) {
+	repo := NewInMemoryRepository()
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	inserted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := mockUser()
+			user.Email = fmt.Sprintf("user%d@user.com", i)
+			if err := repo.Insert(user); err == nil {
+				mux.Lock()
+				inserted++
+				mux.Unlock()
+			}
+			repo.FindByUsername("user")
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, inserted)
+}
+
+func TestServiceWithInMemoryRepository(t *testing.T) {
+	assert := assert.New(t)
+
+	events := mocks.NewMockEventManager()
+	events.On("Publish", mock.Anything, mock.Anything).Return(nil)
+	authServ := &mockAuthService{}
+	authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("verification.token", nil)
+	serv := &service{
+		repo:      NewInMemoryRepository(),
+		events:    events,
+		validator: NewValidator(),
+		crypt:     &bcryptCrypt{bcrypt.MinCost},
+		authServ:  authServ,
+	}
+
+	req := &RegisterRequest{
+		Username: "user",
+		Password: "12345678",
+		Email:    "user@user.com",
+		Name:     "Name",
+		Lastname: "Lastname",
+	}
+	user, err := serv.Register(req)
+	require.Nil(t, err)
+
+	req.Username = "USER"
+	_, err = serv.Register(req)
+	errors.Assert(t, ErrNotAvailable.F("username", "not_available").F("email", "not_available"), err)
+
+	_, err = serv.GetByID(user.ID)
+	errors.Assert(t, ErrNotValidated, err)
+	assert.Len(events.Calls, 2)
+}
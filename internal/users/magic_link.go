@@ -0,0 +1,115 @@
+package users
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// ActionMagicLink is the action of the tokens in login links.
+const ActionMagicLink = "magic_link"
+
+const (
+	magicLinkTTL = 15 * time.Minute
+
+	// Links allowed per address every window.
+	magicLinkLimit  = 3
+	magicLinkWindow = 15 * time.Minute
+)
+
+// Errors
+var (
+	ErrMagicLink        = errors.Status.New("user.service.magic_link").S(500)
+	ErrInvalidMagicLink = errors.Status.New("user.service.invalid_magic_link").S(401)
+)
+
+// RequestMagicLink mails a login link to email. It answers the same whether
+// the address belongs to a user or not.
+func (s *service) RequestMagicLink(email string) error {
+	email = strings.TrimSpace(email)
+
+	allowed, err := s.magicLinkLimiter.Allow(strings.ToLower(email))
+	if err != nil {
+		return ErrMagicLink.Wrap(err)
+	}
+	if !allowed {
+		return ErrTooManyRequests.C("email", email)
+	}
+
+	user, err := s.repo.FindByEmail(email)
+	if err != nil || user == nil || !user.Enabled {
+		return nil
+	}
+
+	token, err := s.authServ.SignAction(&auth.ActionRequest{
+		UserID: user.ID,
+		Action: ActionMagicLink,
+		Email:  user.Email,
+		TTL:    magicLinkTTL,
+	})
+	if err != nil {
+		return ErrMagicLink.Wrap(err)
+	}
+
+	if err := s.magicLinks.InsertMagicLink(models.NewMagicLink(hashToken(token), user.ID, magicLinkTTL)); err != nil {
+		return ErrMagicLink.Wrap(err)
+	}
+
+	magicLinkEvent := NewTokenEvent(user, "MagicLinkRequested", token)
+	if err := s.events.Publish(
+		magicLinkEvent,
+		&events.Options{Exchange: "user", Route: "user.magic_link_requested"},
+	); err != nil {
+		return ErrMagicLink.Wrap(err)
+	}
+
+	return nil
+}
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// LoginWithMagicLink logs in the user the link was mailed to, whose address
+// is validated by following it. The link is consumed even if the login fails
+// afterwards, and links of an address the user has since changed are
+// rejected. Users with a second factor must still prove it.
+func (s *service) LoginWithMagicLink(req *MagicLinkLoginRequest) (*LoginResponse, error) {
+	claims, err := s.authServ.VerifyAction(req.Token, ActionMagicLink)
+	if err != nil {
+		return nil, ErrInvalidMagicLink.Wrap(err)
+	}
+
+	// Taking the link is atomic: of concurrent logins with it only one goes on
+	link, err := s.magicLinks.TakeMagicLink(hashToken(req.Token))
+	if err != nil {
+		if isRepositoryNotFound(err) {
+			return nil, ErrInvalidMagicLink.Wrap(err)
+		}
+		return nil, ErrMagicLink.Wrap(err)
+	}
+	if link.Expired() || link.UserID != claims.Subject {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := s.repo.FindByID(claims.Subject)
+	if err != nil || !user.Enabled {
+		return nil, ErrInvalidMagicLink.Wrap(err)
+	}
+	if user.Email != claims.Email {
+		return nil, ErrInvalidMagicLink.C("email", claims.Email)
+	}
+
+	if err := s.markValidated(user); err != nil {
+		return nil, ErrMagicLink.C("id", user.ID).Wrap(err)
+	}
+
+	return s.startLogin(user, req.IP, req.UserAgent)
+}
@@ -0,0 +1,55 @@
+package users
+
+import (
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// Interfaces
+// MagicLinkRepository stores login links until they are used or expire.
+type MagicLinkRepository interface {
+	FindMagicLink(hash string) (*models.MagicLink, error)
+	InsertMagicLink(link *models.MagicLink) error
+	// TakeMagicLink finds and deletes the link at once, so only one caller
+	// gets it.
+	TakeMagicLink(hash string) (*models.MagicLink, error)
+}
+
+// Implementations
+type magicLinkRepository struct {
+	cache cache.Cache
+}
+
+func NewMagicLinkRepository(c cache.Cache) MagicLinkRepository {
+	return &magicLinkRepository{
+		cache: c,
+	}
+}
+
+func (r *magicLinkRepository) FindMagicLink(hash string) (*models.MagicLink, error) {
+	link := &models.MagicLink{}
+	if err := getJSON(r.cache, magicLinkKey(hash), link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (r *magicLinkRepository) InsertMagicLink(link *models.MagicLink) error {
+	ttl := link.TTL()
+	if ttl <= 0 {
+		return ErrRepositoryInsert.M("magic link already expired")
+	}
+	return setJSON(r.cache, magicLinkKey(link.Hash), link, ttl)
+}
+
+func (r *magicLinkRepository) TakeMagicLink(hash string) (*models.MagicLink, error) {
+	link := &models.MagicLink{}
+	if err := takeJSON(r.cache, magicLinkKey(hash), link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func magicLinkKey(hash string) string {
+	return "magic_link:" + hash
+}
@@ -0,0 +1,234 @@
+package users
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// requestMagicLink returns the token published for mUser.
+func requestMagicLink(t *testing.T, s *mockService, mUser *models.User) string {
+	var token string
+	s.repo.On("FindByEmail", mUser.Email).Return(copyUser(mUser), nil).Once()
+	s.authServ.On("SignAction", &auth.ActionRequest{
+		UserID: mUser.ID,
+		Action: ActionMagicLink,
+		Email:  mUser.Email,
+		TTL:    magicLinkTTL,
+	}).Return("magic.token", nil).Once()
+	s.events.On("Publish", mock.MatchedBy(func(e *TokenEvent) bool {
+		token = e.Token
+		return e.Type == "MagicLinkRequested" && e.User.ID == mUser.ID
+	}), &events.Options{Exchange: "user", Route: "user.magic_link_requested"}).Return(nil).Once()
+
+	require.Nil(t, s.RequestMagicLink(mUser.Email))
+	require.Equal(t, "magic.token", token)
+	return token
+}
+
+func magicLinkClaims(u *models.User) *auth.ActionClaims {
+	return &auth.ActionClaims{
+		StandardClaims: jwt.StandardClaims{Subject: u.ID},
+		Action:         ActionMagicLink,
+		Email:          u.Email,
+	}
+}
+
+func TestRequestMagicLink(t *testing.T) {
+	mUser := mockUser()
+
+	t.Run("unknown email", func(t *testing.T) {
+		serv := newMockService()
+		serv.repo.On("FindByEmail", "unknown@email.com").Return(nil, ErrRepositoryNotFound)
+
+		assert.Nil(t, serv.RequestMagicLink("unknown@email.com"))
+		serv.authServ.AssertNotCalled(t, "SignAction", mock.Anything)
+		serv.events.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything)
+	})
+
+	t.Run("stores a hash of the token", func(t *testing.T) {
+		serv := newMockService()
+		token := requestMagicLink(t, serv, mUser)
+
+		_, err := serv.magicLinks.FindMagicLink(token)
+		assert.NotNil(t, err)
+		link, err := serv.magicLinks.FindMagicLink(hashToken(token))
+		require.Nil(t, err)
+		assert.Equal(t, mUser.ID, link.UserID)
+		serv.events.AssertExpectations(t)
+	})
+
+	t.Run("rate limited per address", func(t *testing.T) {
+		serv := newMockService()
+		serv.repo.On("FindByEmail", mock.Anything).Return(nil, ErrRepositoryNotFound)
+
+		for i := 0; i < magicLinkLimit; i++ {
+			assert.Nil(t, serv.RequestMagicLink("unknown@email.com"))
+		}
+		err := serv.RequestMagicLink(" Unknown@email.com")
+		errors.Assert(t, ErrTooManyRequests, err)
+	})
+}
+
+func TestLoginWithMagicLink(t *testing.T) {
+	mUser := mockUser()
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+	requested := func(t *testing.T, s *mockService) string {
+		token := requestMagicLink(t, s, mUser)
+		s.authServ.On("VerifyAction", token, ActionMagicLink).Return(magicLinkClaims(mUser), nil)
+		return token
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		token func(t *testing.T, s *mockService) string
+		mock  func(s *mockService)
+		check func(t *testing.T, res *LoginResponse)
+	}{{
+		"invalid token",
+		ErrInvalidMagicLink,
+		func(t *testing.T, s *mockService) string {
+			s.authServ.On("VerifyAction", "invalid", ActionMagicLink).Return(nil, auth.ErrInvalidAction)
+			return "invalid"
+		},
+		nil,
+		nil,
+	}, {
+		"signed but not issued",
+		ErrInvalidMagicLink,
+		func(t *testing.T, s *mockService) string {
+			s.authServ.On("VerifyAction", "other.token", ActionMagicLink).Return(magicLinkClaims(mUser), nil)
+			return "other.token"
+		},
+		nil,
+		nil,
+	}, {
+		"email changed after request",
+		ErrInvalidMagicLink,
+		requested,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Email = "other@user.com"
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+		},
+		nil,
+	}, {
+		"validates the email",
+		nil,
+		requested,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Validated = false
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.repo.On("Update", mock.MatchedBy(func(u *models.User) bool {
+				return u.Validated
+			})).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), &events.Options{Exchange: "user", Route: "user.validated"}).Return(nil)
+			s.authServ.On("Create", &auth.CreateRequest{
+				UserID:    mUser.ID,
+				Role:      mUser.Role,
+				IP:        "127.0.0.1",
+				UserAgent: "test-agent",
+			}).Return(mPair, nil)
+		},
+		func(t *testing.T, res *LoginResponse) {
+			assert.Equal(t, mPair, res.TokenPair)
+		},
+	}, {
+		"with second factor",
+		nil,
+		func(t *testing.T, s *mockService) string {
+			t2 := models.NewTOTP(mUser.ID, "SECRET")
+			t2.Confirmed = true
+			require.Nil(t, s.mfa.InsertTOTP(t2))
+			return requested(t, s)
+		},
+		func(s *mockService) {
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.authServ.On("SignAction", &auth.ActionRequest{
+				UserID: mUser.ID,
+				Action: ActionMFA,
+				TTL:    mfaChallengeTTL,
+			}).Return("mfa.token", nil)
+		},
+		func(t *testing.T, res *LoginResponse) {
+			assert.Nil(t, res.TokenPair)
+			assert.True(t, res.MFARequired)
+			assert.Equal(t, "mfa.token", res.MFAToken)
+			assert.Equal(t, []string{MFATOTP}, res.MFAMethods)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := newMockService()
+			token := test.token(t, serv)
+			if test.mock != nil {
+				test.mock(serv)
+			}
+			req := &MagicLinkLoginRequest{Token: token, IP: "127.0.0.1", UserAgent: "test-agent"}
+
+			res, err := serv.LoginWithMagicLink(req)
+
+			if test.err != nil {
+				errors.Assert(t, test.err, err)
+				assert.Nil(t, res)
+			} else {
+				require.Nil(t, err)
+				test.check(t, res)
+
+				// Single use
+				_, err = serv.LoginWithMagicLink(req)
+				errors.Assert(t, ErrInvalidMagicLink, err)
+			}
+			serv.repo.AssertExpectations(t)
+			serv.events.AssertExpectations(t)
+			serv.authServ.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginWithMagicLinkConcurrently(t *testing.T) {
+	mUser := mockUser()
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+	serv := newMockService()
+	token := requestMagicLink(t, serv, mUser)
+	serv.authServ.On("VerifyAction", token, ActionMagicLink).Return(magicLinkClaims(mUser), nil)
+	serv.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil).Once()
+	serv.authServ.On("Create", mock.AnythingOfType("*auth.CreateRequest")).Return(mPair, nil).Once()
+
+	const n = 10
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := serv.LoginWithMagicLink(&MagicLinkLoginRequest{Token: token})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	logins := 0
+	for err := range errs {
+		if err == nil {
+			logins++
+		} else {
+			errors.Assert(t, ErrInvalidMagicLink, err)
+		}
+	}
+	assert.Equal(t, 1, logins)
+	serv.repo.AssertExpectations(t)
+	serv.authServ.AssertExpectations(t)
+}
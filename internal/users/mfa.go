@@ -0,0 +1,299 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/totp"
+)
+
+// ActionMFA is the action of the challenge tokens a login with password
+// returns when the user has a second factor.
+const ActionMFA = "mfa"
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+	// totpSkew is how many 30 second steps a code may be off by.
+	totpSkew = 1
+	// totpStepTTL outlives the time a code is accepted for, so a used one
+	// is remembered until it expires.
+	totpStepTTL = (2*totpSkew + 2) * totp.Period
+
+	// Codes allowed per user every window, against guessing.
+	mfaLimit  = 5
+	mfaWindow = 5 * time.Minute
+)
+
+// Errors
+var (
+	ErrMFA            = errors.Status.New("user.service.mfa").S(500)
+	ErrMFAEnabled     = errors.Validation.New("user.service.mfa_enabled").S(409)
+	ErrNoMFA          = errors.Status.New("user.service.no_mfa").S(404)
+	ErrInvalidMFACode = errors.Validation.New("user.service.invalid_mfa_code").S(400)
+	ErrInvalidMFA     = errors.Status.New("user.service.invalid_mfa").S(401)
+)
+
+// TOTPEnrollment is what an authenticator app needs to generate codes. URI
+// is the otpauth:// provisioning URI, which is also the payload of the QR
+// code apps scan; Secret is for typing it in by hand.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// EnrollTOTP starts enrolling a new authenticator app, replacing one not
+// confirmed yet. It is not required on login until confirmed.
+func (s *service) EnrollTOTP(userID string) (*TOTPEnrollment, error) {
+	user, err := s.getByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.findTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Confirmed {
+		return nil, ErrMFAEnabled.C("id", userID)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, ErrMFA.Wrap(err)
+	}
+	if err := s.mfa.InsertTOTP(models.NewTOTP(userID, secret)); err != nil {
+		return nil, ErrMFA.Wrap(err)
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the enrolled app with its first code and returns the
+// recovery codes, which are not shown again.
+func (s *service) ConfirmTOTP(userID, code string) ([]string, error) {
+	t, err := s.findTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrNoMFA.C("id", userID)
+	}
+	if t.Confirmed {
+		return nil, ErrMFAEnabled.C("id", userID)
+	}
+
+	counter, ok := totp.Validate(t.Secret, code, s.now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode.F("code", "invalid")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, ErrMFA.Wrap(err)
+	}
+
+	t.Confirmed = true
+	t.ConfirmedAt = s.now().UnixNano()
+	t.LastCounter = counter
+	t.RecoveryCodes = hashes
+	if err := s.mfa.InsertTOTP(t); err != nil {
+		return nil, ErrMFA.Wrap(err)
+	}
+
+	if err := s.updateMFAEnabled(userID); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP removes the second factor of the user, which must prove to
+// still have it with a code or a recovery code.
+func (s *service) DisableTOTP(userID, code string) error {
+	t, err := s.findTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return ErrNoMFA.C("id", userID)
+	}
+
+	if t.Confirmed {
+		if err := s.verifyTOTP(t, code); err != nil {
+			return err
+		}
+	}
+
+	if err := s.mfa.DeleteTOTP(userID); err != nil {
+		return ErrMFA.Wrap(err)
+	}
+	return s.updateMFAEnabled(userID)
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a TOTP code or a recovery code.
+	Code string `json:"code" binding:"required"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// LoginMFA completes a login started with a password.
+func (s *service) LoginMFA(req *MFALoginRequest) (*auth.TokenPair, error) {
+	claims, err := s.authServ.VerifyAction(req.MFAToken, ActionMFA)
+	if err != nil {
+		return nil, ErrInvalidMFA.Wrap(err)
+	}
+
+	user, err := s.repo.FindByID(claims.Subject)
+	if err != nil || !user.Enabled {
+		return nil, ErrInvalidUser.Wrap(err)
+	}
+
+	t, err := s.findTOTP(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || !t.Confirmed {
+		return nil, ErrInvalidMFA.M("second factor disabled")
+	}
+
+	if err := s.verifyTOTP(t, req.Code); err != nil {
+		return nil, err
+	}
+
+	pair, err := s.authServ.Create(&auth.CreateRequest{
+		UserID:    user.ID,
+		Role:      user.Role,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+	})
+	if err != nil {
+		return nil, ErrInvalidUser.Wrap(err)
+	}
+
+	return pair, nil
+}
+
+// verifyTOTP accepts a code not used yet or an unused recovery code. Each
+// one is claimed atomically, so concurrent requests cannot both spend it.
+func (s *service) verifyTOTP(t *models.TOTP, code string) error {
+	allowed, err := s.mfaLimiter.Allow(t.UserID)
+	if err != nil {
+		return ErrMFA.Wrap(err)
+	}
+	if !allowed {
+		return ErrTooManyRequests.C("id", t.UserID)
+	}
+
+	if counter, ok := totp.Validate(t.Secret, code, s.now(), totpSkew); ok {
+		if counter <= t.LastCounter {
+			return ErrInvalidMFA.M("code already used")
+		}
+		unused, err := s.mfa.UseTOTPStep(t.UserID, counter, totpStepTTL)
+		if err != nil {
+			return ErrMFA.Wrap(err)
+		}
+		if !unused {
+			return ErrInvalidMFA.M("code already used")
+		}
+		return nil
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	if !t.HasRecoveryCode(hash) {
+		return ErrInvalidMFA
+	}
+	unused, err := s.mfa.UseRecoveryCode(t.UserID, hash)
+	if err != nil {
+		return ErrMFA.Wrap(err)
+	}
+	if !unused {
+		return ErrInvalidMFA.M("recovery code already used")
+	}
+	return nil
+}
+
+// findTOTP returns nil when the user has no TOTP. Other errors fail, so an
+// unavailable store never skips the second factor.
+func (s *service) findTOTP(userID string) (*models.TOTP, error) {
+	t, err := s.mfa.FindTOTP(userID)
+	if err != nil {
+		if isRepositoryNotFound(err) {
+			return nil, nil
+		}
+		return nil, ErrMFA.Wrap(err)
+	}
+	return t, nil
+}
+
+// updateMFAEnabled sets the flag of the user to whether a second factor is
+// left, after adding or removing one.
+func (s *service) updateMFAEnabled(userID string) error {
+	t, err := s.findTOTP(userID)
+	if err != nil {
+		return err
+	}
+	hasWebAuthn, err := s.hasWebAuthn(userID)
+	if err != nil {
+		return err
+	}
+	enabled := (t != nil && t.Confirmed) || hasWebAuthn
+
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return ErrMFA.Wrap(err)
+	}
+	if user.MFAEnabled == enabled {
+		return nil
+	}
+	user.MFAEnabled = enabled
+	if err := s.repo.Update(user); err != nil {
+		return ErrMFA.C("id", userID).Wrap(err)
+	}
+	return nil
+}
+
+// mfaChallenge signs the token that LoginMFA takes along with a code.
+func (s *service) mfaChallenge(user *models.User) (string, error) {
+	return s.authServ.SignAction(&auth.ActionRequest{
+		UserID: user.ID,
+		Action: ActionMFA,
+		TTL:    mfaChallengeTTL,
+	})
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns codes like "abcde-fghij" and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
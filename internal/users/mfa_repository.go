@@ -0,0 +1,109 @@
+package users
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// Interfaces
+// MFARepository stores the TOTP of users, the codes they spent and the
+// WebAuthn ceremonies in progress. WebAuthn credentials are in a
+// WebAuthnRepository.
+type MFARepository interface {
+	FindTOTP(userID string) (*models.TOTP, error)
+	// InsertTOTP stores the TOTP, replacing any previous one of the user.
+	InsertTOTP(t *models.TOTP) error
+	DeleteTOTP(userID string) error
+	// UseTOTPStep records that the code of a time step was used, for ttl. It
+	// reports false when it already was.
+	UseTOTPStep(userID string, counter int64, ttl time.Duration) (bool, error)
+	// UseRecoveryCode records that the recovery code with hash was used. It
+	// reports false when it already was.
+	UseRecoveryCode(userID, hash string) (bool, error)
+
+	InsertWebAuthnChallenge(c *models.WebAuthnChallenge) error
+	// TakeWebAuthnChallenge finds and deletes the challenge at once, so
+	// only one caller gets it.
+	TakeWebAuthnChallenge(challenge string) (*models.WebAuthnChallenge, error)
+}
+
+// Implementations
+type mfaRepository struct {
+	cache cache.Cache
+}
+
+func NewMFARepository(c cache.Cache) MFARepository {
+	return &mfaRepository{
+		cache: c,
+	}
+}
+
+func (r *mfaRepository) FindTOTP(userID string) (*models.TOTP, error) {
+	t := &models.TOTP{}
+	if err := getJSON(r.cache, totpKey(userID), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (r *mfaRepository) InsertTOTP(t *models.TOTP) error {
+	return setJSON(r.cache, totpKey(t.UserID), t, cache.NoExpiration)
+}
+
+func (r *mfaRepository) DeleteTOTP(userID string) error {
+	if err := r.cache.Delete(totpKey(userID)); err != nil {
+		return ErrRepositoryDelete.Wrap(err)
+	}
+	return nil
+}
+
+func (r *mfaRepository) UseTOTPStep(userID string, counter int64, ttl time.Duration) (bool, error) {
+	ok, err := r.cache.Add(totpStepKey(userID, counter), []byte("1"), ttl)
+	if err != nil {
+		return false, ErrRepositoryInsert.Wrap(err)
+	}
+	return ok, nil
+}
+
+func (r *mfaRepository) UseRecoveryCode(userID, hash string) (bool, error) {
+	ok, err := r.cache.Add(recoveryCodeKey(userID, hash), []byte("1"), cache.NoExpiration)
+	if err != nil {
+		return false, ErrRepositoryInsert.Wrap(err)
+	}
+	return ok, nil
+}
+
+func (r *mfaRepository) InsertWebAuthnChallenge(c *models.WebAuthnChallenge) error {
+	ttl := c.TTL()
+	if ttl <= 0 {
+		return ErrRepositoryInsert.M("challenge already expired")
+	}
+	return setJSON(r.cache, webAuthnChallengeKey(c.Challenge), c, ttl)
+}
+
+func (r *mfaRepository) TakeWebAuthnChallenge(challenge string) (*models.WebAuthnChallenge, error) {
+	c := &models.WebAuthnChallenge{}
+	if err := takeJSON(r.cache, webAuthnChallengeKey(challenge), c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func totpKey(userID string) string {
+	return "totp:" + userID
+}
+
+func totpStepKey(userID string, counter int64) string {
+	return "totp_step:" + userID + ":" + strconv.FormatInt(counter, 10)
+}
+
+func recoveryCodeKey(userID, hash string) string {
+	return "recovery_code:" + userID + ":" + hash
+}
+
+func webAuthnChallengeKey(challenge string) string {
+	return "webauthn_challenge:" + challenge
+}
@@ -0,0 +1,212 @@
+package users
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/mocks"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/ratelimit"
+	"github.com/aboglioli/big-brother/pkg/totp"
+	"github.com/aboglioli/big-brother/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+	mUser := mockUser()
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+
+	serv := newMockService()
+	now := time.Unix(1600000000, 0)
+	serv.now = func() time.Time { return now }
+	code := func() string {
+		c, err := totp.Code(findTOTP(t, serv, mUser.ID).Secret, totp.Counter(now))
+		require.Nil(t, err)
+		return c
+	}
+
+	serv.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+	serv.repo.On("FindByUsername", mUser.Username).Return(mUser, nil)
+	serv.repo.On("Update", mUser).Return(nil)
+	serv.crypt.On("Compare", mUser.Password, "12345678").Return(true)
+	serv.authServ.On("SignAction", &auth.ActionRequest{
+		UserID: mUser.ID,
+		Action: ActionMFA,
+		TTL:    mfaChallengeTTL,
+	}).Return("mfa.token", nil)
+	serv.authServ.On("VerifyAction", "mfa.token", ActionMFA).Return(&auth.ActionClaims{
+		StandardClaims: jwt.StandardClaims{Subject: mUser.ID},
+		Action:         ActionMFA,
+	}, nil)
+	serv.authServ.On("Create", mock.AnythingOfType("*auth.CreateRequest")).Return(mPair, nil)
+
+	login := func() *LoginResponse {
+		res, err := serv.Login(&LoginRequest{
+			UsernameOrEmail: &mUser.Username,
+			Password:        utils.NewString("12345678"),
+		})
+		require.Nil(t, err)
+		return res
+	}
+	loginMFA := func(code string) error {
+		_, err := serv.LoginMFA(&MFALoginRequest{MFAToken: "mfa.token", Code: code})
+		return err
+	}
+
+	// Not required until confirmed
+	enrollment, err := serv.EnrollTOTP(mUser.ID)
+	require.Nil(t, err)
+	uri, err := url.Parse(enrollment.URI)
+	require.Nil(t, err)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	assert.Equal(t, "Big Brother", uri.Query().Get("issuer"))
+	assert.Equal(t, mPair, login().TokenPair)
+
+	_, err = serv.ConfirmTOTP(mUser.ID, "000000")
+	errors.Assert(t, ErrInvalidMFACode, err)
+
+	codes, err := serv.ConfirmTOTP(mUser.ID, code())
+	require.Nil(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+	for _, c := range codes {
+		assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`), c)
+	}
+	stored := findTOTP(t, serv, mUser.ID)
+	assert.NotContains(t, stored.RecoveryCodes, codes[0])
+	assert.True(t, mUser.MFAEnabled)
+
+	_, err = serv.EnrollTOTP(mUser.ID)
+	errors.Assert(t, ErrMFAEnabled, err)
+
+	// Two-step login
+	res := login()
+	assert.Nil(t, res.TokenPair)
+	assert.True(t, res.MFARequired)
+	assert.Equal(t, "mfa.token", res.MFAToken)
+
+	errors.Assert(t, ErrInvalidMFA, loginMFA(code())) // Used to confirm
+
+	now = now.Add(totp.Period)
+	assert.Nil(t, loginMFA(code()))
+	errors.Assert(t, ErrInvalidMFA, loginMFA(code()))
+
+	assert.Nil(t, loginMFA(fmt.Sprintf(" %s ", codes[0])))
+	errors.Assert(t, ErrInvalidMFA, loginMFA(codes[0]))
+
+	errors.Assert(t, ErrTooManyRequests, loginMFA(codes[1]))
+
+	// Disable, in a new window
+	serv.mfaLimiter = ratelimit.New(cache.NewInMemory("users"), "mfa", mfaLimit, mfaWindow)
+	errors.Assert(t, ErrInvalidMFA, serv.DisableTOTP(mUser.ID, "000000"))
+	assert.Nil(t, serv.DisableTOTP(mUser.ID, codes[1]))
+	assert.False(t, mUser.MFAEnabled)
+	assert.Equal(t, mPair, login().TokenPair)
+	errors.Assert(t, ErrNoMFA, serv.DisableTOTP(mUser.ID, codes[2]))
+}
+
+func TestLoginWithUnavailableMFAStore(t *testing.T) {
+	mUser := mockUser()
+	c := mocks.NewMockCache()
+	c.On("Get", totpKey(mUser.ID)).Return(nil, errors.Internal.New("redis.down"))
+
+	serv := newMockService()
+	serv.mfa = NewMFARepository(c)
+	serv.repo.On("FindByUsername", mUser.Username).Return(mUser, nil)
+	serv.crypt.On("Compare", mUser.Password, "12345678").Return(true)
+
+	res, err := serv.Login(&LoginRequest{
+		UsernameOrEmail: &mUser.Username,
+		Password:        utils.NewString("12345678"),
+	})
+
+	errors.Assert(t, ErrMFA, err)
+	assert.Nil(t, res)
+	serv.authServ.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestTOTPConcurrentUse(t *testing.T) {
+	mUser := mockUser()
+	mUser.MFAEnabled = true
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+
+	serv := newMockService()
+	now := time.Unix(1600000000, 0)
+	serv.now = func() time.Time { return now }
+	secret, err := totp.GenerateSecret()
+	require.Nil(t, err)
+	stored := models.NewTOTP(mUser.ID, secret)
+	stored.Confirmed = true
+	stored.RecoveryCodes = []string{hashToken("abcdefghij")}
+	require.Nil(t, serv.mfa.InsertTOTP(stored))
+
+	serv.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+	serv.authServ.On("VerifyAction", "mfa.token", ActionMFA).Return(&auth.ActionClaims{
+		StandardClaims: jwt.StandardClaims{Subject: mUser.ID},
+		Action:         ActionMFA,
+	}, nil)
+	serv.authServ.On("Create", mock.AnythingOfType("*auth.CreateRequest")).Return(mPair, nil)
+
+	code, err := totp.Code(secret, totp.Counter(now))
+	require.Nil(t, err)
+
+	for _, c := range []string{code, "abcde-fghij"} {
+		serv.mfaLimiter = ratelimit.New(cache.NewInMemory("users"), "mfa", mfaLimit, mfaWindow)
+
+		results := make(chan error, mfaLimit)
+		var wg sync.WaitGroup
+		for i := 0; i < mfaLimit; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := serv.LoginMFA(&MFALoginRequest{MFAToken: "mfa.token", Code: c})
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for err := range results {
+			if err == nil {
+				succeeded++
+			} else {
+				errors.Assert(t, ErrInvalidMFA, err)
+			}
+		}
+		assert.Equal(t, 1, succeeded, c)
+	}
+}
+
+func TestLoginWithLostMFA(t *testing.T) {
+	mUser := mockUser()
+	mUser.MFAEnabled = true
+
+	serv := newMockService()
+	serv.repo.On("FindByUsername", mUser.Username).Return(mUser, nil)
+	serv.crypt.On("Compare", mUser.Password, "12345678").Return(true)
+
+	res, err := serv.Login(&LoginRequest{
+		UsernameOrEmail: &mUser.Username,
+		Password:        utils.NewString("12345678"),
+	})
+
+	errors.Assert(t, ErrMFA, err)
+	assert.Nil(t, res)
+	serv.authServ.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func findTOTP(t *testing.T, s *mockService, userID string) *models.TOTP {
+	found, err := s.mfa.FindTOTP(userID)
+	require.Nil(t, err)
+	return found
+}
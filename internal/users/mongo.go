@@ -0,0 +1,141 @@
+package users
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The unique indexes are case-insensitive, and so must be the lookups on
+// them. Their names start with the ones of the case-sensitive indexes they
+// replace, which mongoNotAvailable looks for.
+const (
+	mongoCollection    = "users"
+	mongoDuplicateKey  = 11000
+	mongoUsernameIndex = "username_unique"
+	mongoEmailIndex    = "email_unique"
+)
+
+var mongoCaseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+type mongoRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoRepository makes sure the unique indexes on username and email
+// exist before returning the repository.
+func NewMongoRepository(db *mongo.Database) (Repository, error) {
+	collection := db.Collection(mongoCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{{
+		Keys:    bson.M{"username": 1},
+		Options: options.Index().SetName(mongoUsernameIndex + "_ci").SetUnique(true).SetCollation(mongoCaseInsensitive),
+	}, {
+		Keys:    bson.M{"email": 1},
+		Options: options.Index().SetName(mongoEmailIndex + "_ci").SetUnique(true).SetCollation(mongoCaseInsensitive),
+	}})
+	if err != nil {
+		return nil, ErrRepositoryIndex.C("collection", mongoCollection).Wrap(err)
+	}
+
+	return &mongoRepository{
+		collection: collection,
+	}, nil
+}
+
+func (r *mongoRepository) FindByID(id string) (*models.User, error) {
+	return r.findOne(bson.M{"_id": id}, nil, "id", id)
+}
+
+func (r *mongoRepository) FindByUsername(username string) (*models.User, error) {
+	return r.findOne(bson.M{"username": username}, mongoCaseInsensitive, "username", username)
+}
+
+func (r *mongoRepository) FindByEmail(email string) (*models.User, error) {
+	return r.findOne(bson.M{"email": email}, mongoCaseInsensitive, "email", email)
+}
+
+func (r *mongoRepository) Insert(u *models.User) error {
+	if _, err := r.collection.InsertOne(context.Background(), u); err != nil {
+		if vErr := mongoNotAvailable(err); vErr != nil {
+			return vErr
+		}
+		return ErrRepositoryInsert.C("id", u.ID).Wrap(err)
+	}
+	return nil
+}
+
+func (r *mongoRepository) Update(u *models.User) error {
+	res, err := r.collection.ReplaceOne(context.Background(), bson.M{"_id": u.ID}, u)
+	if err != nil {
+		if vErr := mongoNotAvailable(err); vErr != nil {
+			return vErr
+		}
+		return ErrRepositoryUpdate.C("id", u.ID).Wrap(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrRepositoryNotFound.C("id", u.ID)
+	}
+
+	return nil
+}
+
+func (r *mongoRepository) Delete(id string) error {
+	if _, err := r.collection.DeleteOne(context.Background(), bson.M{"_id": id}); err != nil {
+		return ErrRepositoryDelete.C("id", id).Wrap(err)
+	}
+	return nil
+}
+
+// findOne matches filter with collation, which must be the one of the index
+// on the field for the index to be used.
+func (r *mongoRepository) findOne(filter bson.M, collation *options.Collation, key, value string) (*models.User, error) {
+	u := &models.User{}
+	opts := options.FindOne()
+	if collation != nil {
+		opts.SetCollation(collation)
+	}
+	err := r.collection.FindOne(context.Background(), filter, opts).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRepositoryNotFound.C(key, value)
+	}
+	if err != nil {
+		return nil, ErrRepositoryNotFound.C(key, value).Wrap(err)
+	}
+
+	u.CreatedAt = u.CreatedAt.UTC()
+	u.UpdatedAt = u.UpdatedAt.UTC()
+	u.DeletedAt = u.DeletedAt.UTC()
+
+	return u, nil
+}
+
+// mongoNotAvailable translates duplicate key errors on the username or email
+// indexes into the same validation error the service returns.
+func mongoNotAvailable(err error) error {
+	wErr, ok := err.(mongo.WriteException)
+	if !ok {
+		return nil
+	}
+
+	for _, we := range wErr.WriteErrors {
+		if we.Code != mongoDuplicateKey {
+			continue
+		}
+
+		switch {
+		case strings.Contains(we.Message, mongoUsernameIndex):
+			return ErrNotAvailable.F("username", "not_available")
+		case strings.Contains(we.Message, mongoEmailIndex):
+			return ErrNotAvailable.F("email", "not_available")
+		}
+		return ErrNotAvailable
+	}
+
+	return nil
+}
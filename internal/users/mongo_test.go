@@ -0,0 +1,58 @@
+package users
+
+import (
+	goerrors "errors"
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMongoNotAvailable(t *testing.T) {
+	dupKey := func(index string) error {
+		return mongo.WriteException{
+			WriteErrors: mongo.WriteErrors{{
+				Code:    mongoDuplicateKey,
+				Message: "E11000 duplicate key error collection: test.users index: " + index + " dup key",
+			}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		in   error
+		out  error
+	}{{
+		"other error",
+		goerrors.New("connection reset"),
+		nil,
+	}, {
+		"other write error",
+		mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121}}},
+		nil,
+	}, {
+		"username taken",
+		dupKey(mongoUsernameIndex),
+		ErrNotAvailable.F("username", "not_available"),
+	}, {
+		"email taken",
+		dupKey(mongoEmailIndex),
+		ErrNotAvailable.F("email", "not_available"),
+	}, {
+		"id taken",
+		dupKey("_id_"),
+		ErrNotAvailable,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := mongoNotAvailable(test.in)
+			if test.out == nil {
+				assert.Nil(t, err)
+				return
+			}
+			errors.Assert(t, test.out, err)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+const (
+	resetTTL = time.Hour
+
+	// Reset requests allowed per address every hour.
+	resetLimit  = 3
+	resetWindow = time.Hour
+)
+
+// Errors
+var (
+	ErrPasswordReset        = errors.Status.New("user.service.password_reset").S(500)
+	ErrInvalidPasswordReset = errors.Validation.New("user.service.invalid_password_reset").S(400)
+)
+
+// RequestPasswordReset sends a reset token to email. It answers the same
+// whether the address belongs to a user or not.
+func (s *service) RequestPasswordReset(email string) error {
+	email = strings.TrimSpace(email)
+
+	allowed, err := s.resetLimiter.Allow(strings.ToLower(email))
+	if err != nil {
+		return ErrPasswordReset.Wrap(err)
+	}
+	if !allowed {
+		return ErrTooManyRequests.C("email", email)
+	}
+
+	user, err := s.repo.FindByEmail(email)
+	if err != nil || user == nil || !user.Enabled {
+		return nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ErrPasswordReset.Wrap(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	reset := models.NewPasswordReset(hashToken(token), user.ID, resetTTL)
+	reset.PasswordHash = hashToken(user.Password)
+	if err := s.resets.InsertReset(reset); err != nil {
+		return ErrPasswordReset.Wrap(err)
+	}
+
+	resetEvent := NewTokenEvent(user, "PasswordResetRequested", token)
+	if err := s.events.Publish(
+		resetEvent,
+		&events.Options{Exchange: "user", Route: "user.password_reset_requested"},
+	); err != nil {
+		return ErrPasswordReset.Wrap(err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets the password of the user the token was issued to and
+// logs them out everywhere. The token is consumed even if the reset fails
+// afterwards, and tokens issued before a password change are rejected.
+func (s *service) ResetPassword(tokenStr, password string) error {
+	hash := hashToken(tokenStr)
+	if reset, err := s.resets.FindReset(hash); err != nil || reset.Expired() {
+		return ErrInvalidPasswordReset.Wrap(err)
+	}
+
+	// A weak password does not spend the token
+	if err := s.validator.ValidatePassword(password); err != nil {
+		return err
+	}
+
+	// Taking the reset is atomic: of concurrent resets with it only one goes
+	// on
+	reset, err := s.resets.TakeReset(hash)
+	if err != nil {
+		if isRepositoryNotFound(err) {
+			return ErrInvalidPasswordReset.Wrap(err)
+		}
+		return ErrPasswordReset.Wrap(err)
+	}
+	if reset.Expired() {
+		return ErrInvalidPasswordReset
+	}
+
+	user, err := s.repo.FindByID(reset.UserID)
+	if err != nil || !user.Enabled {
+		return ErrInvalidPasswordReset.Wrap(err)
+	}
+	if reset.PasswordHash != hashToken(user.Password) {
+		return ErrInvalidPasswordReset.M("password changed")
+	}
+
+	passwordHash, err := s.crypt.Hash(password)
+	if err != nil {
+		return ErrPasswordReset.Wrap(err)
+	}
+	user.Password = passwordHash
+
+	if err := s.repo.Update(user); err != nil {
+		return ErrPasswordReset.C("id", user.ID).Wrap(err)
+	}
+
+	if err := s.authServ.RevokeAll(user.ID); err != nil {
+		return ErrPasswordReset.C("id", user.ID).Wrap(err)
+	}
+	if err := s.authServ.RevokeAPIKeys(user.ID); err != nil {
+		return ErrPasswordReset.C("id", user.ID).Wrap(err)
+	}
+
+	// Emit event
+	passwordResetEvent := NewUserEvent(user, "PasswordReset")
+	if err := s.events.Publish(
+		passwordResetEvent,
+		&events.Options{Exchange: "user", Route: "user.password_reset"},
+	); err != nil {
+		return ErrPasswordReset.Wrap(err)
+	}
+
+	return nil
+}
+
+// hashToken is used for lookups, so it must be deterministic: reset tokens
+// are random enough not to need a salt.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,218 @@
+package users
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// requestReset returns the token published for mUser.
+func requestReset(t *testing.T, s *mockService, mUser *models.User) string {
+	var token string
+	s.repo.On("FindByEmail", mUser.Email).Return(copyUser(mUser), nil).Once()
+	s.events.On("Publish", mock.MatchedBy(func(e *TokenEvent) bool {
+		token = e.Token
+		return e.Type == "PasswordResetRequested" && e.User.ID == mUser.ID
+	}), &events.Options{Exchange: "user", Route: "user.password_reset_requested"}).Return(nil).Once()
+
+	require.Nil(t, s.RequestPasswordReset(mUser.Email))
+	require.NotEmpty(t, token)
+	return token
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	mUser := mockUser()
+
+	t.Run("unknown email", func(t *testing.T) {
+		serv := newMockService()
+		serv.repo.On("FindByEmail", "unknown@email.com").Return(nil, ErrRepositoryNotFound)
+
+		assert.Nil(t, serv.RequestPasswordReset("unknown@email.com"))
+		serv.events.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything)
+	})
+
+	t.Run("stores a hash of the token", func(t *testing.T) {
+		serv := newMockService()
+		token := requestReset(t, serv, mUser)
+
+		_, err := serv.resets.FindReset(token)
+		assert.NotNil(t, err)
+		reset, err := serv.resets.FindReset(hashToken(token))
+		require.Nil(t, err)
+		assert.Equal(t, mUser.ID, reset.UserID)
+		assert.NotEqual(t, mUser.Password, reset.PasswordHash)
+		serv.events.AssertExpectations(t)
+	})
+
+	t.Run("rate limited per address", func(t *testing.T) {
+		serv := newMockService()
+		serv.repo.On("FindByEmail", mock.Anything).Return(nil, ErrRepositoryNotFound)
+
+		for i := 0; i < resetLimit; i++ {
+			assert.Nil(t, serv.RequestPasswordReset("unknown@email.com"))
+		}
+		err := serv.RequestPasswordReset("Unknown@email.com")
+		errors.Assert(t, ErrTooManyRequests, err)
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	mUser := mockUser()
+	requested := func(t *testing.T, s *mockService) string {
+		return requestReset(t, s, mUser)
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		token func(t *testing.T, s *mockService) string
+		mock  func(s *mockService)
+	}{{
+		"unknown token",
+		ErrInvalidPasswordReset,
+		func(t *testing.T, s *mockService) string {
+			return "unknown"
+		},
+		nil,
+	}, {
+		"expired token",
+		ErrInvalidPasswordReset,
+		func(t *testing.T, s *mockService) string {
+			reset := models.NewPasswordReset(hashToken("expired"), mUser.ID, 10*time.Millisecond)
+			require.Nil(t, s.resets.InsertReset(reset))
+			time.Sleep(20 * time.Millisecond)
+			return "expired"
+		},
+		nil,
+	}, {
+		"weak password",
+		ErrPasswordValidation,
+		requested,
+		func(s *mockService) {
+			s.validator.On("ValidatePassword", "new-password").Return(ErrPasswordValidation)
+		},
+	}, {
+		"password changed after request",
+		ErrInvalidPasswordReset,
+		requested,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Password = "other.hashed.password"
+			s.validator.On("ValidatePassword", "new-password").Return(nil)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+		},
+	}, {
+		"error revoking tokens",
+		ErrPasswordReset,
+		requested,
+		func(s *mockService) {
+			s.validator.On("ValidatePassword", "new-password").Return(nil)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.crypt.On("Hash", "new-password").Return("new.hashed.password", nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(auth.ErrRevoke)
+		},
+	}, {
+		"error revoking API keys",
+		ErrPasswordReset,
+		requested,
+		func(s *mockService) {
+			s.validator.On("ValidatePassword", "new-password").Return(nil)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.crypt.On("Hash", "new-password").Return("new.hashed.password", nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(auth.ErrRevoke)
+		},
+	}, {
+		"valid token",
+		nil,
+		requested,
+		func(s *mockService) {
+			s.validator.On("ValidatePassword", "new-password").Return(nil)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.crypt.On("Hash", "new-password").Return("new.hashed.password", nil)
+			s.repo.On("Update", mock.MatchedBy(func(u *models.User) bool {
+				return u.Password == "new.hashed.password"
+			})).Return(nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), &events.Options{Exchange: "user", Route: "user.password_reset"}).Return(nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := newMockService()
+			token := test.token(t, serv)
+			if test.mock != nil {
+				test.mock(serv)
+			}
+
+			err := serv.ResetPassword(token, "new-password")
+
+			if test.err != nil {
+				if assert.NotNil(t, err) {
+					errors.Assert(t, test.err, err)
+				}
+			} else {
+				assert.Nil(t, err)
+
+				// Single use
+				err := serv.ResetPassword(token, "new-password")
+				errors.Assert(t, ErrInvalidPasswordReset, err)
+			}
+			serv.crypt.AssertExpectations(t)
+			serv.repo.AssertExpectations(t)
+			serv.validator.AssertExpectations(t)
+			serv.events.AssertExpectations(t)
+			serv.authServ.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResetPasswordConcurrently(t *testing.T) {
+	mUser := mockUser()
+	serv := newMockService()
+	token := requestReset(t, serv, mUser)
+	serv.validator.On("ValidatePassword", "new-password").Return(nil)
+	serv.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil).Once()
+	serv.crypt.On("Hash", "new-password").Return("new.hashed.password", nil).Once()
+	serv.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil).Once()
+	serv.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil).Once()
+	serv.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil).Once()
+	serv.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), &events.Options{Exchange: "user", Route: "user.password_reset"}).Return(nil).Once()
+
+	const n = 10
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- serv.ResetPassword(token, "new-password")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	resets := 0
+	for err := range errs {
+		if err == nil {
+			resets++
+		} else {
+			errors.Assert(t, ErrInvalidPasswordReset, err)
+		}
+	}
+	assert.Equal(t, 1, resets)
+	serv.repo.AssertExpectations(t)
+	serv.authServ.AssertExpectations(t)
+}
@@ -0,0 +1,204 @@
+package users
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/lib/pq"
+)
+
+// pgUsernameKey and pgEmailKey are unique indexes on the lower-cased
+// columns, so usernames and emails are unique and found regardless of case.
+const (
+	pgUniqueViolation = "23505"
+	pgUsernameKey     = "users_username_key"
+	pgEmailKey        = "users_email_key"
+)
+
+type postgresRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresRepository(db *sql.DB) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+const pgUserColumns = `
+	id, username, password, email, name, lastname, role,
+	enabled, validated, created_at, updated_at, deleted_at, locale,
+	mfa_enabled`
+
+func (r *postgresRepository) FindByID(id string) (*models.User, error) {
+	row := r.db.QueryRow("SELECT"+pgUserColumns+" FROM users WHERE id = $1", id)
+	return r.scan(row, "id", id)
+}
+
+func (r *postgresRepository) FindByUsername(username string) (*models.User, error) {
+	row := r.db.QueryRow("SELECT"+pgUserColumns+" FROM users WHERE lower(username) = lower($1)", username)
+	return r.scan(row, "username", username)
+}
+
+func (r *postgresRepository) FindByEmail(email string) (*models.User, error) {
+	row := r.db.QueryRow("SELECT"+pgUserColumns+" FROM users WHERE lower(email) = lower($1)", email)
+	return r.scan(row, "email", email)
+}
+
+func (r *postgresRepository) Insert(u *models.User) error {
+	_, err := r.db.Exec(`
+		INSERT INTO users(`+pgUserColumns+`)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		u.ID,
+		u.Username,
+		u.Password,
+		u.Email,
+		u.Name,
+		u.Lastname,
+		string(u.Role),
+		u.Enabled,
+		u.Validated,
+		u.CreatedAt.UTC(),
+		nullTime(u.UpdatedAt),
+		nullTime(u.DeletedAt),
+		u.Locale,
+		u.MFAEnabled,
+	)
+	if err != nil {
+		if vErr := postgresNotAvailable(err); vErr != nil {
+			return vErr
+		}
+		return ErrRepositoryInsert.C("id", u.ID).Wrap(err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) Update(u *models.User) error {
+	res, err := r.db.Exec(`
+		UPDATE users SET
+			username = $2,
+			password = $3,
+			email = $4,
+			name = $5,
+			lastname = $6,
+			role = $7,
+			enabled = $8,
+			validated = $9,
+			created_at = $10,
+			updated_at = $11,
+			deleted_at = $12,
+			locale = $13,
+			mfa_enabled = $14
+		WHERE id = $1`,
+		u.ID,
+		u.Username,
+		u.Password,
+		u.Email,
+		u.Name,
+		u.Lastname,
+		string(u.Role),
+		u.Enabled,
+		u.Validated,
+		u.CreatedAt.UTC(),
+		nullTime(u.UpdatedAt),
+		nullTime(u.DeletedAt),
+		u.Locale,
+		u.MFAEnabled,
+	)
+	if err != nil {
+		if vErr := postgresNotAvailable(err); vErr != nil {
+			return vErr
+		}
+		return ErrRepositoryUpdate.C("id", u.ID).Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ErrRepositoryUpdate.C("id", u.ID).Wrap(err)
+	}
+	if n == 0 {
+		return ErrRepositoryNotFound.C("id", u.ID)
+	}
+
+	return nil
+}
+
+func (r *postgresRepository) Delete(id string) error {
+	if _, err := r.db.Exec("DELETE FROM users WHERE id = $1", id); err != nil {
+		return ErrRepositoryDelete.C("id", id).Wrap(err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) scan(row *sql.Row, key, value string) (*models.User, error) {
+	var (
+		u         models.User
+		role      string
+		name      sql.NullString
+		lastname  sql.NullString
+		updatedAt sql.NullTime
+		deletedAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&u.ID,
+		&u.Username,
+		&u.Password,
+		&u.Email,
+		&name,
+		&lastname,
+		&role,
+		&u.Enabled,
+		&u.Validated,
+		&u.CreatedAt,
+		&updatedAt,
+		&deletedAt,
+		&u.Locale,
+		&u.MFAEnabled,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrRepositoryNotFound.C(key, value)
+	}
+	if err != nil {
+		return nil, ErrRepositoryNotFound.C(key, value).Wrap(err)
+	}
+
+	u.Role = models.Role(role)
+	u.Name = name.String
+	u.Lastname = lastname.String
+	u.CreatedAt = u.CreatedAt.UTC()
+	if updatedAt.Valid {
+		u.UpdatedAt = updatedAt.Time.UTC()
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = deletedAt.Time.UTC()
+	}
+
+	return &u, nil
+}
+
+// postgresNotAvailable translates unique constraint violations on username or
+// email into the same validation error the service returns.
+func postgresNotAvailable(err error) error {
+	pqErr, ok := err.(*pq.Error)
+	if !ok || pqErr.Code != pgUniqueViolation {
+		return nil
+	}
+
+	switch pqErr.Constraint {
+	case pgUsernameKey:
+		return ErrNotAvailable.F("username", "not_available")
+	case pgEmailKey:
+		return ErrNotAvailable.F("email", "not_available")
+	}
+
+	return ErrNotAvailable
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
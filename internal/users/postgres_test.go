@@ -0,0 +1,49 @@
+package users
+
+import (
+	goerrors "errors"
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresNotAvailable(t *testing.T) {
+	tests := []struct {
+		name string
+		in   error
+		out  error
+	}{{
+		"other error",
+		goerrors.New("connection reset"),
+		nil,
+	}, {
+		"other postgres error",
+		&pq.Error{Code: "23503", Constraint: "users_username_key"},
+		nil,
+	}, {
+		"username taken",
+		&pq.Error{Code: pgUniqueViolation, Constraint: pgUsernameKey},
+		ErrNotAvailable.F("username", "not_available"),
+	}, {
+		"email taken",
+		&pq.Error{Code: pgUniqueViolation, Constraint: pgEmailKey},
+		ErrNotAvailable.F("email", "not_available"),
+	}, {
+		"primary key taken",
+		&pq.Error{Code: pgUniqueViolation, Constraint: "users_pkey"},
+		ErrNotAvailable,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := postgresNotAvailable(test.in)
+			if test.out == nil {
+				assert.Nil(t, err)
+				return
+			}
+			errors.Assert(t, test.out, err)
+		})
+	}
+}
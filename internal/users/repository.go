@@ -1,6 +1,8 @@
 package users
 
 import (
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/db"
 	"github.com/aboglioli/big-brother/pkg/errors"
 	"github.com/aboglioli/big-brother/pkg/models"
 )
@@ -11,9 +13,13 @@ var (
 	ErrRepositoryInsert   = errors.Internal.New("user.repository.insert")
 	ErrRepositoryUpdate   = errors.Internal.New("user.repository.update")
 	ErrRepositoryDelete   = errors.Internal.New("user.repository.delete")
+	ErrRepositoryIndex    = errors.Internal.New("user.repository.index")
+	ErrRepositoryBackend  = errors.Internal.New("user.repository.backend")
 )
 
 // Interfaces
+// Repository stores users. Usernames and emails are unique and found
+// regardless of case, and kept as given.
 type Repository interface {
 	FindByID(id string) (*models.User, error)
 	FindByUsername(username string) (*models.User, error)
@@ -23,3 +29,27 @@ type Repository interface {
 	Update(*models.User) error
 	Delete(id string) error
 }
+
+// NewRepository connects to the backend selected by config.UserRepository.
+func NewRepository() (Repository, error) {
+	c := config.Get()
+
+	switch c.UserRepository {
+	case "postgres":
+		conn, err := db.ConnectPostgres(c.PostgresURL, c.PostgresDatabase, c.PostgresUsername, c.PostgresPassword)
+		if err != nil {
+			return nil, ErrRepositoryBackend.C("backend", c.UserRepository).Wrap(err)
+		}
+		return NewPostgresRepository(conn), nil
+	case "mongo":
+		conn, err := db.ConnectMongo(c.MongoURL, c.MongoDatabase, c.MongoUsername, c.MongoPassword)
+		if err != nil {
+			return nil, ErrRepositoryBackend.C("backend", c.UserRepository).Wrap(err)
+		}
+		return NewMongoRepository(conn)
+	case "memory":
+		return NewInMemoryRepository(), nil
+	}
+
+	return nil, ErrRepositoryBackend.M("unknown users backend %s", c.UserRepository).C("backend", c.UserRepository)
+}
@@ -0,0 +1,55 @@
+package users
+
+import (
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// Interfaces
+// ResetRepository stores password resets until they expire.
+type ResetRepository interface {
+	FindReset(hash string) (*models.PasswordReset, error)
+	InsertReset(reset *models.PasswordReset) error
+	// TakeReset finds and deletes the reset at once, so only one caller gets
+	// it.
+	TakeReset(hash string) (*models.PasswordReset, error)
+}
+
+// Implementations
+type resetRepository struct {
+	cache cache.Cache
+}
+
+func NewResetRepository(c cache.Cache) ResetRepository {
+	return &resetRepository{
+		cache: c,
+	}
+}
+
+func (r *resetRepository) FindReset(hash string) (*models.PasswordReset, error) {
+	reset := &models.PasswordReset{}
+	if err := getJSON(r.cache, resetKey(hash), reset); err != nil {
+		return nil, err
+	}
+	return reset, nil
+}
+
+func (r *resetRepository) InsertReset(reset *models.PasswordReset) error {
+	ttl := reset.TTL()
+	if ttl <= 0 {
+		return ErrRepositoryInsert.M("reset already expired")
+	}
+	return setJSON(r.cache, resetKey(reset.Hash), reset, ttl)
+}
+
+func (r *resetRepository) TakeReset(hash string) (*models.PasswordReset, error) {
+	reset := &models.PasswordReset{}
+	if err := takeJSON(r.cache, resetKey(hash), reset); err != nil {
+		return nil, err
+	}
+	return reset, nil
+}
+
+func resetKey(hash string) string {
+	return "password_reset:" + hash
+}
@@ -1,34 +1,66 @@
 package users
 
 import (
+	"log"
+	"time"
+
 	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/config"
 	"github.com/aboglioli/big-brother/pkg/errors"
 	"github.com/aboglioli/big-brother/pkg/events"
 	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/ratelimit"
+	"github.com/aboglioli/big-brother/pkg/webauthn"
 )
 
 // Errors
 var (
 	ErrNotFound     = errors.Status.New("user.service.not_found").S(404)
-	ErrNotValidated = errors.Status.New("user.service.not_validated")
-	ErrRegister     = errors.Status.New("user.service.register")
-	ErrNotAvailable = errors.Validation.New("user.not_available")
-	ErrUpdate       = errors.Status.New("user.service.update")
-	ErrDelete       = errors.Status.New("user.service.delete")
-	ErrInvalidUser  = errors.Status.New("user.service.invalid_user")
+	ErrNotValidated = errors.Status.New("user.service.not_validated").S(403)
+	ErrRegister     = errors.Status.New("user.service.register").S(500)
+	ErrNotAvailable = errors.Validation.New("user.not_available").S(409)
+	ErrUpdate       = errors.Status.New("user.service.update").S(500)
+	ErrDelete       = errors.Status.New("user.service.delete").S(500)
+	ErrInvalidUser  = errors.Status.New("user.service.invalid_user").S(401)
 	ErrInvalidLogin = errors.Validation.New("user.service.invalid_login")
 )
 
 // Interfaces
 type Service interface {
 	GetByID(id string) (*models.User, error)
+	// GetEnabled is GetByID including users whose email is not validated,
+	// for callers that report it, like OpenID Connect claims.
+	GetEnabled(id string) (*models.User, error)
 
 	Register(req *RegisterRequest) (*models.User, error)
 	Update(id string, req *UpdateRequest) (*models.User, error)
 	Delete(id string) error
 
-	Login(req *LoginRequest) (string, error)
+	Login(req *LoginRequest) (*LoginResponse, error)
+	Refresh(refreshTokenStr string) (*auth.TokenPair, error)
 	Logout(tokenStr string) error
+
+	VerifyEmail(tokenStr string) (*models.User, error)
+	ResendVerification(email string) error
+
+	RequestPasswordReset(email string) error
+	ResetPassword(tokenStr, password string) error
+
+	RequestMagicLink(email string) error
+	LoginWithMagicLink(req *MagicLinkLoginRequest) (*LoginResponse, error)
+
+	EnrollTOTP(userID string) (*TOTPEnrollment, error)
+	ConfirmTOTP(userID, code string) ([]string, error)
+	DisableTOTP(userID, code string) error
+	LoginMFA(req *MFALoginRequest) (*auth.TokenPair, error)
+
+	BeginWebAuthnRegistration(userID string) (*webauthn.CreationOptions, error)
+	FinishWebAuthnRegistration(userID string, req *WebAuthnRegistrationRequest) (*models.WebAuthnCredential, error)
+	ListWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error)
+	DeleteWebAuthnCredential(userID, id string) error
+	BeginWebAuthnLogin(mfaToken string) (*webauthn.RequestOptions, error)
+	FinishWebAuthnLogin(req *WebAuthnLoginRequest) (*auth.TokenPair, error)
 }
 
 // Implementations
@@ -38,15 +70,45 @@ type service struct {
 	validator Validator
 	crypt     PasswordCrypt
 	authServ  auth.Service
+
+	resets      ResetRepository
+	mfa         MFARepository
+	credentials WebAuthnRepository
+	magicLinks  MagicLinkRepository
+
+	resendLimiter    ratelimit.Limiter
+	resetLimiter     ratelimit.Limiter
+	mfaLimiter       ratelimit.Limiter
+	magicLinkLimiter ratelimit.Limiter
+
+	totpIssuer string
+	rp         *webauthn.RelyingParty
+	now        func() time.Time
 }
 
-func NewService(repo Repository, events events.Manager, authServ auth.Service) Service {
+// NewService keeps password resets, TOTPs, WebAuthn challenges, login links
+// and rate limit counters in c, and WebAuthn credentials in credentials.
+func NewService(repo Repository, credentials WebAuthnRepository, events events.Manager, authServ auth.Service, c cache.Cache) Service {
 	return &service{
 		repo:      repo,
 		events:    events,
 		validator: NewValidator(),
 		crypt:     NewBcryptCrypt(),
 		authServ:  authServ,
+
+		resets:      NewResetRepository(c),
+		mfa:         NewMFARepository(c),
+		credentials: credentials,
+		magicLinks:  NewMagicLinkRepository(c),
+
+		resendLimiter:    ratelimit.New(c, "verification", resendLimit, resendWindow),
+		resetLimiter:     ratelimit.New(c, "password_reset", resetLimit, resetWindow),
+		mfaLimiter:       ratelimit.New(c, "mfa", mfaLimit, mfaWindow),
+		magicLinkLimiter: ratelimit.New(c, "magic_link", magicLinkLimit, magicLinkWindow),
+
+		totpIssuer: config.Get().TOTPIssuer,
+		rp:         webauthn.New(config.Get().WebAuthnRPID, config.Get().WebAuthnRPName, config.Get().WebAuthnOrigins...),
+		now:        time.Now,
 	}
 }
 
@@ -54,12 +116,21 @@ func (s *service) GetByID(id string) (*models.User, error) {
 	return s.getByID(id)
 }
 
+func (s *service) GetEnabled(id string) (*models.User, error) {
+	user, err := s.repo.FindByID(id)
+	if err != nil || !user.Enabled {
+		return nil, ErrNotFound.C("id", id).Wrap(err)
+	}
+	return user, nil
+}
+
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	Lastname string `json:"lastname"`
+	Locale   string `json:"locale"`
 }
 
 func (s *service) Register(req *RegisterRequest) (*models.User, error) {
@@ -76,6 +147,7 @@ func (s *service) Register(req *RegisterRequest) (*models.User, error) {
 	user.Email = req.Email
 	user.Name = req.Name
 	user.Lastname = req.Lastname
+	user.Locale = req.Locale
 
 	// Schema validation
 	if err := s.validator.ValidateSchema(user); err != nil {
@@ -107,6 +179,9 @@ func (s *service) Register(req *RegisterRequest) (*models.User, error) {
 
 	// Insert
 	if err := s.repo.Insert(user); err != nil {
+		if isNotAvailable(err) {
+			return nil, err
+		}
 		return nil, ErrRegister.Wrap(err)
 	}
 
@@ -119,6 +194,11 @@ func (s *service) Register(req *RegisterRequest) (*models.User, error) {
 		return nil, ErrRegister.Wrap(err)
 	}
 
+	// The user is already stored; it can ask for a new token later
+	if err := s.sendVerification(user); err != nil {
+		log.Printf("users: %s", err)
+	}
+
 	return user, nil
 }
 
@@ -128,6 +208,11 @@ type UpdateRequest struct {
 	Email    *string `json:"email"`
 	Name     *string `json:"name"`
 	Lastname *string `json:"lastname"`
+	Locale   *string `json:"locale"`
+
+	// SessionID is the session making the change. It survives a password
+	// change; every other session of the user is revoked.
+	SessionID string `json:"-"`
 }
 
 func (s *service) Update(id string, req *UpdateRequest) (*models.User, error) {
@@ -155,6 +240,9 @@ func (s *service) Update(id string, req *UpdateRequest) (*models.User, error) {
 	if req.Lastname != nil {
 		user.Lastname = *req.Lastname
 	}
+	if req.Locale != nil {
+		user.Locale = *req.Locale
+	}
 
 	// Schema validation
 	if err := s.validator.ValidateSchema(user); err != nil {
@@ -165,6 +253,7 @@ func (s *service) Update(id string, req *UpdateRequest) (*models.User, error) {
 		return nil, errs
 	}
 
+	emailChanged := false
 	vErr := ErrNotAvailable
 	if req.Username != nil {
 		if existing, _ := s.repo.FindByUsername(*req.Username); existing != nil && existing.ID != id {
@@ -176,9 +265,10 @@ func (s *service) Update(id string, req *UpdateRequest) (*models.User, error) {
 	if req.Email != nil {
 		if existing, _ := s.repo.FindByEmail(*req.Email); existing != nil && existing.ID != id {
 			vErr = vErr.F("email", "not_available")
-		} else {
+		} else if *req.Email != user.Email {
 			user.Email = *req.Email
 			user.Validated = false
+			emailChanged = true
 		}
 	}
 
@@ -188,9 +278,21 @@ func (s *service) Update(id string, req *UpdateRequest) (*models.User, error) {
 
 	// Update
 	if err := s.repo.Update(user); err != nil {
+		if isNotAvailable(err) {
+			return nil, err
+		}
 		return nil, ErrUpdate.C("id", id).Wrap(err)
 	}
 
+	if req.Password != nil {
+		if err := s.authServ.RevokeAll(id, req.SessionID); err != nil {
+			return nil, ErrUpdate.C("id", id).Wrap(err)
+		}
+		if err := s.authServ.RevokeAPIKeys(id); err != nil {
+			return nil, ErrUpdate.C("id", id).Wrap(err)
+		}
+	}
+
 	// Emit event
 	userUpdatedEvent := NewUserEvent(user, "UserUpdated")
 	if err := s.events.Publish(
@@ -200,6 +302,13 @@ func (s *service) Update(id string, req *UpdateRequest) (*models.User, error) {
 		return nil, ErrUpdate.Wrap(err)
 	}
 
+	// The new address must be verified
+	if emailChanged {
+		if err := s.sendVerification(user); err != nil {
+			log.Printf("users: %s", err)
+		}
+	}
+
 	return user, nil
 }
 
@@ -209,6 +318,15 @@ func (s *service) Delete(id string) error {
 		return err
 	}
 
+	// Credentials go first: a failed delete can be retried, a deleted user
+	// with live tokens cannot be fixed from here
+	if err := s.authServ.RevokeAll(id); err != nil {
+		return ErrDelete.C("id", id).Wrap(err)
+	}
+	if err := s.authServ.RevokeAPIKeys(id); err != nil {
+		return ErrDelete.C("id", id).Wrap(err)
+	}
+
 	// Delete
 	if err := s.repo.Delete(id); err != nil {
 		return ErrDelete.Wrap(err)
@@ -229,9 +347,28 @@ func (s *service) Delete(id string) error {
 type LoginRequest struct {
 	UsernameOrEmail *string `json:"username_or_email"`
 	Password        *string `json:"password"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// Second factors a login can be completed with.
+const (
+	MFATOTP     = "totp"
+	MFAWebAuthn = "webauthn"
+)
+
+// LoginResponse holds the tokens of the new session or, when the user has a
+// second factor, the challenge to complete the login with on LoginMFA or
+// FinishWebAuthnLogin, as MFAMethods allow.
+type LoginResponse struct {
+	*auth.TokenPair
+	MFARequired bool     `json:"mfa_required,omitempty"`
+	MFAToken    string   `json:"mfa_token,omitempty"`
+	MFAMethods  []string `json:"mfa_methods,omitempty"`
 }
 
-func (s *service) Login(req *LoginRequest) (string, error) {
+func (s *service) Login(req *LoginRequest) (*LoginResponse, error) {
 	vErr := ErrInvalidLogin
 	if req.UsernameOrEmail == nil {
 		vErr = vErr.F("username", "required")
@@ -240,28 +377,70 @@ func (s *service) Login(req *LoginRequest) (string, error) {
 		vErr = vErr.F("password", "required")
 	}
 	if len(vErr.Fields) > 0 {
-		return "", vErr
+		return nil, vErr
 	}
 
 	user, err := s.repo.FindByUsername(*req.UsernameOrEmail)
 	if user == nil || err != nil {
 		user, err = s.repo.FindByEmail(*req.UsernameOrEmail)
 	}
-
 	if user == nil || err != nil {
-		return "", ErrInvalidUser.Wrap(err)
+		return nil, ErrInvalidUser.Wrap(err)
 	}
 
 	if !s.crypt.Compare(user.Password, *req.Password) {
-		return "", ErrInvalidUser
+		return nil, ErrInvalidUser
 	}
 
-	tokenStr, err := s.authServ.Create(user.ID)
+	return s.startLogin(user, req.IP, req.UserAgent)
+}
+
+// startLogin creates a session for a user who proved the first factor or,
+// when the user has a second factor, returns the challenge to prove it with.
+func (s *service) startLogin(user *models.User, ip, userAgent string) (*LoginResponse, error) {
+	// Second factor
+	methods := make([]string, 0)
+	t, err := s.findTOTP(user.ID)
 	if err != nil {
-		return "", ErrInvalidUser.Wrap(err)
+		return nil, err
+	}
+	if t != nil && t.Confirmed {
+		methods = append(methods, MFATOTP)
+	}
+	hasWebAuthn, err := s.hasWebAuthn(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if hasWebAuthn {
+		methods = append(methods, MFAWebAuthn)
+	}
+	// A lost factor locks the user out rather than being skipped
+	if user.MFAEnabled && len(methods) == 0 {
+		return nil, ErrMFA.C("id", user.ID).M("second factor not found")
+	}
+	if len(methods) > 0 {
+		token, err := s.mfaChallenge(user)
+		if err != nil {
+			return nil, ErrInvalidUser.Wrap(err)
+		}
+		return &LoginResponse{MFARequired: true, MFAToken: token, MFAMethods: methods}, nil
 	}
 
-	return tokenStr, nil
+	pair, err := s.authServ.Create(&auth.CreateRequest{
+		UserID:    user.ID,
+		Role:      user.Role,
+		IP:        ip,
+		UserAgent: userAgent,
+	})
+	if err != nil {
+		return nil, ErrInvalidUser.Wrap(err)
+	}
+
+	return &LoginResponse{TokenPair: pair}, nil
+}
+
+func (s *service) Refresh(refreshTokenStr string) (*auth.TokenPair, error) {
+	return s.authServ.Refresh(&auth.RefreshRequest{RefreshToken: refreshTokenStr})
 }
 
 func (s *service) Logout(tokenStr string) error {
@@ -289,3 +468,10 @@ func (s *service) getByID(id string) (*models.User, error) {
 
 	return user, nil
 }
+
+// isNotAvailable reports whether a repository rejected a write because the
+// username or email was taken concurrently.
+func isNotAvailable(err error) bool {
+	vErr, ok := err.(errors.Error)
+	return ok && vErr.Equals(ErrNotAvailable)
+}
@@ -1,8 +1,14 @@
 package users
 
 import (
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
 	"github.com/aboglioli/big-brother/mocks"
+	"github.com/aboglioli/big-brother/pkg/cache"
 	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/ratelimit"
+	"github.com/aboglioli/big-brother/pkg/webauthn"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -64,14 +70,60 @@ func (r *mockRepository) Delete(id string) error {
 	return args.Error(0)
 }
 
+type mockWebAuthnRepository struct {
+	mock.Mock
+}
+
+func (r *mockWebAuthnRepository) FindWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error) {
+	args := r.Called(userID)
+	if creds, ok := args.Get(0).([]*models.WebAuthnCredential); ok {
+		return creds, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (r *mockWebAuthnRepository) FindWebAuthnCredential(id string) (*models.WebAuthnCredential, error) {
+	args := r.Called(id)
+	if cred, ok := args.Get(0).(*models.WebAuthnCredential); ok {
+		return cred, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (r *mockWebAuthnRepository) InsertWebAuthnCredential(c *models.WebAuthnCredential) error {
+	args := r.Called(c)
+	return args.Error(0)
+}
+
+func (r *mockWebAuthnRepository) UpdateWebAuthnCredentialUse(c *models.WebAuthnCredential, signCount uint32) error {
+	args := r.Called(c, signCount)
+	return args.Error(0)
+}
+
+func (r *mockWebAuthnRepository) DeleteWebAuthnCredential(userID, id string) error {
+	args := r.Called(userID, id)
+	return args.Error(0)
+}
+
 // Auth service
 type mockAuthService struct {
 	mock.Mock
 }
 
-func (s *mockAuthService) Create(userID string) (string, error) {
-	args := s.Called(userID)
-	return args.String(0), args.Error(1)
+func (s *mockAuthService) Create(req *auth.CreateRequest) (*auth.TokenPair, error) {
+	args := s.Called(req)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) Refresh(req *auth.RefreshRequest) (*auth.TokenPair, error) {
+	args := s.Called(req)
+	if pair, ok := args.Get(0).(*auth.TokenPair); ok {
+		return pair, args.Error(1)
+	}
+	return nil, args.Error(1)
 }
 
 func (s *mockAuthService) Validate(tokenStr string) (*models.Token, error) {
@@ -90,6 +142,71 @@ func (s *mockAuthService) Invalidate(tokenStr string) (*models.Token, error) {
 	return nil, args.Error(1)
 }
 
+func (s *mockAuthService) ListSessions(userID string) ([]*models.Session, error) {
+	args := s.Called(userID)
+	if sessions, ok := args.Get(0).([]*models.Session); ok {
+		return sessions, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) RevokeSession(userID, sessionID string) error {
+	args := s.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) RevokeAll(userID string, except ...string) error {
+	args := s.Called(userID, except)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) CreateAPIKey(req *auth.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	args := s.Called(req)
+	if key, ok := args.Get(0).(*models.APIKey); ok {
+		return key, args.String(1), args.Error(2)
+	}
+	return nil, args.String(1), args.Error(2)
+}
+
+func (s *mockAuthService) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	args := s.Called(userID)
+	if keys, ok := args.Get(0).([]*models.APIKey); ok {
+		return keys, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) RevokeAPIKey(userID, keyID string) error {
+	args := s.Called(userID, keyID)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) RevokeAPIKeys(userID string) error {
+	args := s.Called(userID)
+	return args.Error(0)
+}
+
+func (s *mockAuthService) ValidateAPIKey(keyStr string) (*models.APIKey, error) {
+	args := s.Called(keyStr)
+	if key, ok := args.Get(0).(*models.APIKey); ok {
+		return key, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (s *mockAuthService) SignAction(req *auth.ActionRequest) (string, error) {
+	args := s.Called(req)
+	return args.String(0), args.Error(1)
+}
+
+func (s *mockAuthService) VerifyAction(tokenStr, action string) (*auth.ActionClaims, error) {
+	args := s.Called(tokenStr, action)
+	if claims, ok := args.Get(0).(*auth.ActionClaims); ok {
+		return claims, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 // Crypt
 type mockPasswordCrypt struct {
 	mock.Mock
@@ -121,6 +238,7 @@ func newMockService() *mockService {
 	validator := &mockValidator{}
 	crypt := &mockPasswordCrypt{}
 	authServ := &mockAuthService{}
+	c := cache.NewInMemory("users")
 
 	serv := &service{
 		repo:      repo,
@@ -128,6 +246,20 @@ func newMockService() *mockService {
 		validator: validator,
 		crypt:     crypt,
 		authServ:  authServ,
+
+		resets:      NewResetRepository(c),
+		mfa:         NewMFARepository(c),
+		credentials: NewInMemoryWebAuthnRepository(),
+		magicLinks:  NewMagicLinkRepository(c),
+
+		resendLimiter:    ratelimit.New(c, "verification", resendLimit, resendWindow),
+		resetLimiter:     ratelimit.New(c, "password_reset", resetLimit, resetWindow),
+		mfaLimiter:       ratelimit.New(c, "mfa", mfaLimit, mfaWindow),
+		magicLinkLimiter: ratelimit.New(c, "magic_link", magicLinkLimit, magicLinkWindow),
+
+		totpIssuer: "Big Brother",
+		rp:         webauthn.New("localhost", "Big Brother", "http://localhost:3344"),
+		now:        time.Now,
 	}
 
 	return &mockService{
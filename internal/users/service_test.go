@@ -115,6 +115,58 @@ func TestGetByID(t *testing.T) {
 	}
 }
 
+func TestGetEnabled(t *testing.T) {
+	mUser := mockUser()
+
+	tests := []struct {
+		name string
+		err  error
+		mock func(s *mockService)
+	}{{
+		"not found",
+		ErrNotFound.Wrap(ErrRepositoryNotFound),
+		func(s *mockService) {
+			s.repo.On("FindByID", mUser.ID).Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"not enabled",
+		ErrNotFound,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Enabled = false
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+		},
+	}, {
+		"not validated",
+		nil,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Validated = false
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := newMockService()
+			test.mock(serv)
+
+			user, err := serv.GetEnabled(mUser.ID)
+
+			if test.err != nil {
+				errors.Assert(t, test.err, err)
+				assert.Nil(t, user)
+			} else {
+				assert.Nil(t, err)
+				if assert.NotNil(t, user) {
+					assert.Equal(t, mUser.ID, user.ID)
+				}
+			}
+			serv.repo.AssertExpectations(t)
+		})
+	}
+}
+
 func TestRegister(t *testing.T) {
 	mUser := mockUser()
 
@@ -197,6 +249,18 @@ func TestRegister(t *testing.T) {
 			s.crypt.On("Hash", "12345678").Return("hashed.password", nil)
 			s.repo.On("Insert", mock.AnythingOfType("*models.User")).Return(ErrRepositoryInsert)
 		},
+	}, {
+		"taken between check and insert",
+		genReq(nil),
+		ErrNotAvailable.F("email", "not_available"),
+		func(s *mockService) {
+			s.repo.On("FindByUsername", "user").Return(nil, ErrRepositoryNotFound)
+			s.repo.On("FindByEmail", "user@user.com").Return(nil, ErrRepositoryNotFound)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.validator.On("ValidatePassword", "12345678").Return(nil)
+			s.crypt.On("Hash", "12345678").Return("hashed.password", nil)
+			s.repo.On("Insert", mock.AnythingOfType("*models.User")).Return(ErrNotAvailable.F("email", "not_available"))
+		},
 	}, {
 		"error on publishing event",
 		genReq(nil),
@@ -222,6 +286,23 @@ func TestRegister(t *testing.T) {
 			s.crypt.On("Hash", "12345678").Return("hashed.password", nil)
 			s.repo.On("Insert", mock.AnythingOfType("*models.User")).Return(nil)
 			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+			s.authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("verification.token", nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.TokenEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+		},
+	}, {
+		"verification not sent",
+		genReq(nil),
+		nil,
+		func(s *mockService) {
+			s.repo.On("FindByUsername", "user").Return(nil, ErrRepositoryNotFound)
+			s.repo.On("FindByEmail", "user@user.com").Return(nil, ErrRepositoryNotFound)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.validator.On("ValidatePassword", "12345678").Return(nil)
+			s.crypt.On("Hash", "12345678").Return("hashed.password", nil)
+			s.repo.On("Insert", mock.AnythingOfType("*models.User")).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+			s.authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("verification.token", nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.TokenEvent"), mock.AnythingOfType("*events.Options")).Return(events.ErrPublish)
 		},
 	}, {
 		"valid admin",
@@ -241,6 +322,8 @@ func TestRegister(t *testing.T) {
 			s.crypt.On("Hash", "adminComplexPasswd#!").Return("hashed.password", nil)
 			s.repo.On("Insert", mock.AnythingOfType("*models.User")).Return(nil)
 			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+			s.authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("verification.token", nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.TokenEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
 		},
 	}}
 
@@ -444,6 +527,17 @@ func TestUpdate(t *testing.T) {
 			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
 			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(ErrRepositoryUpdate)
 		},
+	}, {
+		"taken between check and update",
+		mUser.ID,
+		genReq(nil),
+		ErrNotAvailable.F("username", "not_available"),
+		func(s *mockService) {
+			u := copyUser(mUser)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(ErrNotAvailable.F("username", "not_available"))
+		},
 	}, {
 		"error on publishing",
 		mUser.ID,
@@ -456,6 +550,41 @@ func TestUpdate(t *testing.T) {
 			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
 			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(events.ErrPublish)
 		},
+	}, {
+		"error revoking other sessions",
+		mUser.ID,
+		genReq(func(req *UpdateRequest) {
+			req.Password = utils.NewString("new-password")
+			req.SessionID = "session123"
+		}),
+		ErrUpdate.C("id", mUser.ID).Wrap(auth.ErrRevoke),
+		func(s *mockService) {
+			u := copyUser(mUser)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.validator.On("ValidatePassword", "new-password").Return(nil)
+			s.crypt.On("Hash", "new-password").Return("hashed.password", nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string{"session123"}).Return(auth.ErrRevoke)
+		},
+	}, {
+		"error revoking API keys",
+		mUser.ID,
+		genReq(func(req *UpdateRequest) {
+			req.Password = utils.NewString("new-password")
+			req.SessionID = "session123"
+		}),
+		ErrUpdate.C("id", mUser.ID).Wrap(auth.ErrRevoke),
+		func(s *mockService) {
+			u := copyUser(mUser)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.validator.On("ValidatePassword", "new-password").Return(nil)
+			s.crypt.On("Hash", "new-password").Return("hashed.password", nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string{"session123"}).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(auth.ErrRevoke)
+		},
 	}, {
 		"valid update",
 		mUser.ID,
@@ -463,6 +592,7 @@ func TestUpdate(t *testing.T) {
 			req.Username = utils.NewString("new-user")
 			req.Email = utils.NewString("new@email.com")
 			req.Password = utils.NewString("new-password")
+			req.SessionID = "session123"
 		}),
 		nil,
 		func(s *mockService) {
@@ -474,7 +604,32 @@ func TestUpdate(t *testing.T) {
 			s.validator.On("ValidatePassword", "new-password").Return(nil)
 			s.crypt.On("Hash", "new-password").Return("hashed.password", nil)
 			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string{"session123"}).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+			s.authServ.On("SignAction", &auth.ActionRequest{
+				UserID: mUser.ID,
+				Action: ActionVerifyEmail,
+				Email:  "new@email.com",
+				TTL:    verificationTTL,
+			}).Return("verification.token", nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.TokenEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+		},
+	}, {
+		"verification not sent",
+		mUser.ID,
+		genReq(func(req *UpdateRequest) {
+			req.Email = utils.NewString("new@email.com")
+		}),
+		nil,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.repo.On("FindByEmail", "new@email.com").Return(nil, ErrRepositoryNotFound)
+			s.validator.On("ValidateSchema", mock.AnythingOfType("*models.User")).Return(nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
 			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
+			s.authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("", auth.ErrSignAction)
 		},
 	}, {
 		"change name only",
@@ -560,6 +715,25 @@ func TestDelete(t *testing.T) {
 			u.Validated = false
 			s.repo.On("FindByID", mUser.ID).Return(u, nil)
 		},
+	}, {
+		"error revoking sessions",
+		mUser.ID,
+		ErrDelete.C("id", mUser.ID).Wrap(auth.ErrRevoke),
+		func(s *mockService) {
+			u := copyUser(mUser)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(auth.ErrRevoke)
+		},
+	}, {
+		"error revoking API keys",
+		mUser.ID,
+		ErrDelete.C("id", mUser.ID).Wrap(auth.ErrRevoke),
+		func(s *mockService) {
+			u := copyUser(mUser)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(auth.ErrRevoke)
+		},
 	}, {
 		"error on delete",
 		mUser.ID,
@@ -567,6 +741,8 @@ func TestDelete(t *testing.T) {
 		func(s *mockService) {
 			u := copyUser(mUser)
 			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil)
 			s.repo.On("Delete", mUser.ID).Return(ErrRepositoryDelete)
 		},
 	}, {
@@ -576,6 +752,8 @@ func TestDelete(t *testing.T) {
 		func(s *mockService) {
 			u := copyUser(mUser)
 			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil)
 			s.repo.On("Delete", mUser.ID).Return(nil)
 			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(events.ErrPublish)
 		},
@@ -586,6 +764,8 @@ func TestDelete(t *testing.T) {
 		func(s *mockService) {
 			u := copyUser(mUser)
 			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+			s.authServ.On("RevokeAll", mUser.ID, []string(nil)).Return(nil)
+			s.authServ.On("RevokeAPIKeys", mUser.ID).Return(nil)
 			s.repo.On("Delete", mUser.ID).Return(nil)
 			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), mock.AnythingOfType("*events.Options")).Return(nil)
 		},
@@ -619,12 +799,15 @@ func TestDelete(t *testing.T) {
 
 func TestLogin(t *testing.T) {
 	mUser := mockUser()
-	mTokenStr := "encoded.token"
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+	mCreateReq := &auth.CreateRequest{UserID: mUser.ID, Role: mUser.Role, IP: "10.0.0.1", UserAgent: "curl/7.68.0"}
 
 	genReq := func(cb func(req *LoginRequest)) *LoginRequest {
 		req := &LoginRequest{
 			UsernameOrEmail: utils.NewString("user"),
 			Password:        utils.NewString("12345678"),
+			IP:              "10.0.0.1",
+			UserAgent:       "curl/7.68.0",
 		}
 		if cb != nil {
 			cb(req)
@@ -679,7 +862,7 @@ func TestLogin(t *testing.T) {
 		func(s *mockService) {
 			s.repo.On("FindByUsername", "user").Return(mUser, nil)
 			s.crypt.On("Compare", mUser.Password, "12345678").Return(true)
-			s.authServ.On("Create", mUser.ID).Return(mTokenStr, nil)
+			s.authServ.On("Create", mCreateReq).Return(mPair, nil)
 			// s.events.On("Publish", mock.Anything, mock.Anything).Return(nil)
 		},
 	}, {
@@ -693,7 +876,7 @@ func TestLogin(t *testing.T) {
 			s.repo.On("FindByUsername", "user@user.com").Return(nil, ErrRepositoryNotFound)
 			s.repo.On("FindByEmail", "user@user.com").Return(mUser, nil)
 			s.crypt.On("Compare", mUser.Password, "complexPassword#!").Return(true)
-			s.authServ.On("Create", mUser.ID).Return(mTokenStr, nil)
+			s.authServ.On("Create", mCreateReq).Return(mPair, nil)
 			// s.events.On("Publish", mock.Anything, mock.Anything).Return(nil)
 		},
 	}}
@@ -706,17 +889,18 @@ func TestLogin(t *testing.T) {
 				test.mock(serv)
 			}
 
-			tokenStr, err := serv.Login(test.req)
+			res, err := serv.Login(test.req)
 
 			if test.err != nil {
 				if assert.NotNil(err) {
 					errors.Assert(t, test.err, err)
 				}
-				assert.Empty(tokenStr)
+				assert.Nil(res)
 			} else {
 				assert.Nil(err)
-				if assert.NotEmpty(tokenStr) {
-					assert.Equal(mTokenStr, tokenStr)
+				if assert.NotNil(res) {
+					assert.Equal(mPair, res.TokenPair)
+					assert.False(res.MFARequired)
 				}
 			}
 			serv.crypt.AssertExpectations(t)
@@ -0,0 +1,169 @@
+// Package userstest provides a conformance suite for users.Repository
+// implementations.
+package userstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RepositoryContract runs the behaviour every users.Repository must share.
+// newRepo is called once per subtest and must return an empty repository.
+func RepositoryContract(t *testing.T, newRepo func(t *testing.T) users.Repository) {
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("user")
+
+		u, err := repo.FindByID(user.ID)
+		assert.Nil(t, u)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+
+		u, err = repo.FindByUsername(user.Username)
+		assert.Nil(t, u)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+
+		u, err = repo.FindByEmail(user.Email)
+		assert.Nil(t, u)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("user")
+		require.Nil(t, repo.Insert(user))
+
+		u, err := repo.FindByID(user.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+
+		u, err = repo.FindByUsername(user.Username)
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+
+		u, err = repo.FindByEmail(user.Email)
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+	})
+
+	t.Run("uniqueness", func(t *testing.T) {
+		repo := newRepo(t)
+		require.Nil(t, repo.Insert(newUser("user")))
+
+		dup := newUser("user")
+		dup.Email = "other@user.com"
+		errors.Assert(t, users.ErrNotAvailable.F("username", "not_available"), repo.Insert(dup))
+
+		dup = newUser("other")
+		dup.Email = "user@user.com"
+		errors.Assert(t, users.ErrNotAvailable.F("email", "not_available"), repo.Insert(dup))
+
+		_, err := repo.FindByID(dup.ID)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+	})
+
+	t.Run("case", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("User")
+		user.Email = "User@User.com"
+		require.Nil(t, repo.Insert(user))
+
+		// Found regardless of case, and stored as given
+		u, err := repo.FindByUsername("USER")
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+
+		u, err = repo.FindByEmail("user@user.com")
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+
+		dup := newUser("user")
+		dup.Email = "other@user.com"
+		errors.Assert(t, users.ErrNotAvailable.F("username", "not_available"), repo.Insert(dup))
+
+		dup = newUser("other")
+		dup.Email = "USER@USER.COM"
+		errors.Assert(t, users.ErrNotAvailable.F("email", "not_available"), repo.Insert(dup))
+	})
+
+	t.Run("update", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("user")
+		other := newUser("other")
+		require.Nil(t, repo.Insert(user))
+		require.Nil(t, repo.Insert(other))
+
+		user.Username = "renamed"
+		user.Email = "renamed@user.com"
+		user.Password = "other.hash"
+		user.Validated = false
+		user.UpdatedAt = now().Add(time.Hour)
+		require.Nil(t, repo.Update(user))
+
+		u, err := repo.FindByID(user.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+
+		u, err = repo.FindByUsername("renamed")
+		assert.Nil(t, err)
+		assert.Equal(t, user, u)
+
+		_, err = repo.FindByUsername("user")
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+
+		other.Username = "renamed"
+		errors.Assert(t, users.ErrNotAvailable.F("username", "not_available"), repo.Update(other))
+	})
+
+	t.Run("update missing", func(t *testing.T) {
+		repo := newRepo(t)
+		errors.Assert(t, users.ErrRepositoryNotFound, repo.Update(newUser("user")))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("user")
+		require.Nil(t, repo.Insert(user))
+
+		assert.Nil(t, repo.Delete(user.ID))
+		_, err := repo.FindByID(user.ID)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+		_, err = repo.FindByUsername(user.Username)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+
+		// Idempotent
+		assert.Nil(t, repo.Delete(user.ID))
+
+		// Username and email can be reused
+		assert.Nil(t, repo.Insert(newUser("user")))
+	})
+}
+
+// newUser sets every field of models.User, with times truncated to the
+// millisecond precision all backends can store.
+func newUser(username string) *models.User {
+	user := models.NewUser()
+	user.Enabled = false
+	user.CreatedAt = now().Add(-time.Hour)
+	user.UpdatedAt = now()
+	user.DeletedAt = now().Add(time.Minute)
+	user.Username = username
+	user.Password = "hashed.password"
+	user.Email = username + "@user.com"
+	user.Name = "Name"
+	user.Lastname = "Lastname"
+	user.Role = models.ADMIN
+	user.Locale = "es-AR"
+	user.Validated = true
+	user.MFAEnabled = true
+	return user
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
@@ -0,0 +1,153 @@
+package userstest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/users"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// WebAuthnRepositoryContract runs the behaviour every
+// users.WebAuthnRepository must share. newRepo is called once per subtest
+// and must return an empty repository.
+func WebAuthnRepositoryContract(t *testing.T, newRepo func(t *testing.T) users.WebAuthnRepository) {
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepo(t)
+
+		creds, err := repo.FindWebAuthnCredentials(models.NewID())
+		assert.Nil(t, err)
+		assert.Empty(t, creds)
+
+		_, err = repo.FindWebAuthnCredential("unknown")
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		repo := newRepo(t)
+		userID := models.NewID()
+		first := newCredential("first", userID, 1)
+		second := newCredential("second", userID, 2)
+		require.Nil(t, repo.InsertWebAuthnCredential(second))
+		require.Nil(t, repo.InsertWebAuthnCredential(first))
+		require.Nil(t, repo.InsertWebAuthnCredential(newCredential("other", models.NewID(), 1)))
+
+		c, err := repo.FindWebAuthnCredential(first.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, first, c)
+
+		creds, err := repo.FindWebAuthnCredentials(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, []*models.WebAuthnCredential{first, second}, creds)
+	})
+
+	t.Run("uniqueness", func(t *testing.T) {
+		repo := newRepo(t)
+		require.Nil(t, repo.InsertWebAuthnCredential(newCredential("cred", models.NewID(), 1)))
+
+		err := repo.InsertWebAuthnCredential(newCredential("cred", models.NewID(), 2))
+		errors.Assert(t, users.ErrNotAvailable, err)
+	})
+
+	t.Run("concurrent inserts", func(t *testing.T) {
+		repo := newRepo(t)
+		userID := models.NewID()
+
+		ids := []string{"a", "b", "c", "d"}
+		var wg sync.WaitGroup
+		for i, id := range ids {
+			wg.Add(1)
+			go func(id string, createdAt int64) {
+				defer wg.Done()
+				assert.Nil(t, repo.InsertWebAuthnCredential(newCredential(id, userID, createdAt)))
+			}(id, int64(i+1))
+		}
+		wg.Wait()
+
+		creds, err := repo.FindWebAuthnCredentials(userID)
+		assert.Nil(t, err)
+		assert.Len(t, creds, len(ids))
+	})
+
+	t.Run("update use", func(t *testing.T) {
+		repo := newRepo(t)
+		cred := newCredential("cred", models.NewID(), 1)
+		require.Nil(t, repo.InsertWebAuthnCredential(cred))
+
+		used := *cred
+		used.SignCount = 8
+		used.LastUsedAt = 1600000000000000000
+		require.Nil(t, repo.UpdateWebAuthnCredentialUse(&used, cred.SignCount))
+
+		c, err := repo.FindWebAuthnCredential(cred.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, &used, c)
+
+		// The count read before is stale now
+		stale := *cred
+		stale.SignCount = 9
+		errors.Assert(t, users.ErrRepositoryNotFound, repo.UpdateWebAuthnCredentialUse(&stale, cred.SignCount))
+
+		errors.Assert(t, users.ErrRepositoryNotFound, repo.UpdateWebAuthnCredentialUse(newCredential("unknown", cred.UserID, 1), 0))
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		repo := newRepo(t)
+		cred := newCredential("cred", models.NewID(), 1)
+		require.Nil(t, repo.InsertWebAuthnCredential(cred))
+
+		results := make(chan error, 4)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(results); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				used := *cred
+				used.SignCount = cred.SignCount + 1
+				results <- repo.UpdateWebAuthnCredentialUse(&used, cred.SignCount)
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		updated := 0
+		for err := range results {
+			if err == nil {
+				updated++
+			} else {
+				errors.Assert(t, users.ErrRepositoryNotFound, err)
+			}
+		}
+		assert.Equal(t, 1, updated)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepo(t)
+		cred := newCredential("cred", models.NewID(), 1)
+		require.Nil(t, repo.InsertWebAuthnCredential(cred))
+
+		errors.Assert(t, users.ErrRepositoryNotFound, repo.DeleteWebAuthnCredential(models.NewID(), cred.ID))
+
+		assert.Nil(t, repo.DeleteWebAuthnCredential(cred.UserID, cred.ID))
+		_, err := repo.FindWebAuthnCredential(cred.ID)
+		errors.Assert(t, users.ErrRepositoryNotFound, err)
+
+		errors.Assert(t, users.ErrRepositoryNotFound, repo.DeleteWebAuthnCredential(cred.UserID, cred.ID))
+	})
+}
+
+// newCredential sets every field of models.WebAuthnCredential.
+func newCredential(id, userID string, createdAt int64) *models.WebAuthnCredential {
+	c := models.NewWebAuthnCredential(id, userID, "Laptop")
+	c.PublicKey = []byte{0xa5, 0x01, 0x02}
+	c.Algorithm = -7
+	c.SignCount = 7
+	c.AAGUID = []byte{0x01, 0x02, 0x03, 0x04}
+	c.Transports = []string{"usb", "nfc"}
+	c.CreatedAt = createdAt
+	c.LastUsedAt = createdAt
+	return c
+}
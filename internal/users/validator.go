@@ -21,6 +21,9 @@ type Validator interface {
 	ValidatePassword(pwd string) error
 }
 
+// localeRE matches language tags like es or es-AR.
+var localeRE = regexp.MustCompile("^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$")
+
 // Implementations
 type validator struct {
 	validate *govalidator.Validate
@@ -47,9 +50,14 @@ func NewValidator() Validator {
 		return alphaNumWithDashRE.MatchString(str)
 	}
 
+	locale := func(fl govalidator.FieldLevel) bool {
+		return localeRE.MatchString(fl.Field().String())
+	}
+
 	validate := govalidator.New()
 	validate.RegisterValidation("alphaspaces", alphaWithSpaces)
 	validate.RegisterValidation("alphanumdash", alphaNumWithDash)
+	validate.RegisterValidation("locale", locale)
 
 	return &validator{
 		validate: validate,
@@ -80,6 +80,14 @@ func TestValidateSchema(t *testing.T) {
 		"invalid lastname",
 		func(u *models.User) { u.Lastname = "De0tal" },
 		ErrSchemaValidation,
+	}, {
+		"invalid locale",
+		func(u *models.User) { u.Locale = "*" },
+		ErrSchemaValidation,
+	}, {
+		"invalid locale",
+		func(u *models.User) { u.Locale = "es-AR;q=0.9" },
+		ErrSchemaValidation,
 	}, {
 		"valid",
 		nil,
@@ -92,6 +100,7 @@ func TestValidateSchema(t *testing.T) {
 			u.Email = "user@e-mail.com"
 			u.Name = "Fulano"
 			u.Lastname = "De tal"
+			u.Locale = "es-AR"
 		},
 		nil,
 	}, {
@@ -0,0 +1,128 @@
+package users
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// ActionVerifyEmail is the action of email verification tokens.
+const ActionVerifyEmail = "verify_email"
+
+const (
+	verificationTTL = 24 * time.Hour
+
+	// Resends allowed per address every hour.
+	resendLimit  = 3
+	resendWindow = time.Hour
+)
+
+// Errors
+var (
+	ErrVerification        = errors.Status.New("user.service.verification").S(500)
+	ErrInvalidVerification = errors.Validation.New("user.service.invalid_verification").S(400)
+	ErrTooManyRequests     = errors.Status.New("user.service.too_many_requests").S(429)
+)
+
+// TokenEvent carries a token that must reach the user, like the link of a
+// verification email.
+type TokenEvent struct {
+	UserEvent
+	Token string `json:"token"`
+}
+
+func NewTokenEvent(u *models.User, eventType, token string) *TokenEvent {
+	return &TokenEvent{
+		UserEvent: *NewUserEvent(u, eventType),
+		Token:     token,
+	}
+}
+
+// VerifyEmail validates the address the token was issued for. Tokens of an
+// address the user has since changed are rejected.
+func (s *service) VerifyEmail(tokenStr string) (*models.User, error) {
+	claims, err := s.authServ.VerifyAction(tokenStr, ActionVerifyEmail)
+	if err != nil {
+		return nil, ErrInvalidVerification.Wrap(err)
+	}
+
+	user, err := s.repo.FindByID(claims.Subject)
+	if err != nil || !user.Enabled {
+		return nil, ErrInvalidVerification.Wrap(err)
+	}
+	if user.Email != claims.Email {
+		return nil, ErrInvalidVerification.C("email", claims.Email)
+	}
+	if err := s.markValidated(user); err != nil {
+		return nil, ErrVerification.C("id", user.ID).Wrap(err)
+	}
+
+	return user, nil
+}
+
+// markValidated records that the user owns their address, if not yet known.
+func (s *service) markValidated(user *models.User) error {
+	if user.Validated {
+		return nil
+	}
+
+	user.Validated = true
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	// Emit event
+	userValidatedEvent := NewUserEvent(user, "UserValidated")
+	return s.events.Publish(
+		userValidatedEvent,
+		&events.Options{Exchange: "user", Route: "user.validated"},
+	)
+}
+
+// ResendVerification sends a new verification token to email. It does not
+// tell whether the address belongs to a user waiting for verification.
+func (s *service) ResendVerification(email string) error {
+	email = strings.TrimSpace(email)
+
+	allowed, err := s.resendLimiter.Allow(strings.ToLower(email))
+	if err != nil {
+		return ErrVerification.Wrap(err)
+	}
+	if !allowed {
+		return ErrTooManyRequests.C("email", email)
+	}
+
+	user, err := s.repo.FindByEmail(email)
+	if err != nil || user == nil || !user.Enabled || user.Validated {
+		return nil
+	}
+
+	return s.sendVerification(user)
+}
+
+// sendVerification publishes a token for the current address of user.
+func (s *service) sendVerification(user *models.User) error {
+	token, err := s.authServ.SignAction(&auth.ActionRequest{
+		UserID: user.ID,
+		Action: ActionVerifyEmail,
+		Email:  user.Email,
+		TTL:    verificationTTL,
+	})
+	if err != nil {
+		return ErrVerification.Wrap(err)
+	}
+
+	verificationEvent := NewTokenEvent(user, "VerificationRequested", token)
+	if err := s.events.Publish(
+		verificationEvent,
+		&events.Options{Exchange: "user", Route: "user.verification_requested"},
+	); err != nil {
+		return ErrVerification.Wrap(err)
+	}
+
+	return nil
+}
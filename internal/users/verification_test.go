@@ -0,0 +1,178 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/events"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	mUser := mockUser()
+	mUser.Validated = false
+
+	claims := func(email string) *auth.ActionClaims {
+		return &auth.ActionClaims{
+			StandardClaims: jwt.StandardClaims{Subject: mUser.ID},
+			Action:         ActionVerifyEmail,
+			Email:          email,
+		}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		mock func(s *mockService)
+	}{{
+		"invalid token",
+		ErrInvalidVerification,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(nil, auth.ErrInvalidAction)
+		},
+	}, {
+		"user not found",
+		ErrInvalidVerification,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(claims(mUser.Email), nil)
+			s.repo.On("FindByID", mUser.ID).Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"not enabled user",
+		ErrInvalidVerification,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Enabled = false
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(claims(mUser.Email), nil)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+		},
+	}, {
+		"email changed",
+		ErrInvalidVerification,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(claims("old@email.com"), nil)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+		},
+	}, {
+		"error on update",
+		ErrVerification,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(claims(mUser.Email), nil)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(ErrRepositoryUpdate)
+		},
+	}, {
+		"already validated",
+		nil,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Validated = true
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(claims(mUser.Email), nil)
+			s.repo.On("FindByID", mUser.ID).Return(u, nil)
+		},
+	}, {
+		"valid token",
+		nil,
+		func(s *mockService) {
+			s.authServ.On("VerifyAction", "token", ActionVerifyEmail).Return(claims(mUser.Email), nil)
+			s.repo.On("FindByID", mUser.ID).Return(copyUser(mUser), nil)
+			s.repo.On("Update", mock.AnythingOfType("*models.User")).Return(nil)
+			s.events.On("Publish", mock.AnythingOfType("*users.UserEvent"), &events.Options{Exchange: "user", Route: "user.validated"}).Return(nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			serv := newMockService()
+			test.mock(serv)
+
+			user, err := serv.VerifyEmail("token")
+
+			if test.err != nil {
+				if assert.NotNil(err) {
+					errors.Assert(t, test.err, err)
+				}
+				assert.Nil(user)
+			} else {
+				assert.Nil(err)
+				if assert.NotNil(user) {
+					assert.True(user.Validated)
+				}
+			}
+			serv.repo.AssertExpectations(t)
+			serv.events.AssertExpectations(t)
+			serv.authServ.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	mUser := mockUser()
+	mUser.Validated = false
+
+	tests := []struct {
+		name  string
+		email string
+		mock  func(s *mockService)
+	}{{
+		"unknown email",
+		"unknown@email.com",
+		func(s *mockService) {
+			s.repo.On("FindByEmail", "unknown@email.com").Return(nil, ErrRepositoryNotFound)
+		},
+	}, {
+		"already validated",
+		mUser.Email,
+		func(s *mockService) {
+			u := copyUser(mUser)
+			u.Validated = true
+			s.repo.On("FindByEmail", mUser.Email).Return(u, nil)
+		},
+	}, {
+		"not validated",
+		" " + mUser.Email,
+		func(s *mockService) {
+			s.repo.On("FindByEmail", mUser.Email).Return(copyUser(mUser), nil)
+			s.authServ.On("SignAction", &auth.ActionRequest{
+				UserID: mUser.ID,
+				Action: ActionVerifyEmail,
+				Email:  mUser.Email,
+				TTL:    verificationTTL,
+			}).Return("verification.token", nil)
+			s.events.On("Publish", mock.MatchedBy(func(e *TokenEvent) bool {
+				return e.Type == "VerificationRequested" && e.Token == "verification.token"
+			}), &events.Options{Exchange: "user", Route: "user.verification_requested"}).Return(nil)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := newMockService()
+			test.mock(serv)
+
+			err := serv.ResendVerification(test.email)
+
+			assert.Nil(t, err)
+			serv.repo.AssertExpectations(t)
+			serv.events.AssertExpectations(t)
+			serv.authServ.AssertExpectations(t)
+		})
+	}
+
+	t.Run("rate limited per address", func(t *testing.T) {
+		serv := newMockService()
+		serv.repo.On("FindByEmail", mock.Anything).Return(nil, ErrRepositoryNotFound)
+
+		for i := 0; i < resendLimit; i++ {
+			assert.Nil(t, serv.ResendVerification("user@user.com"))
+		}
+		err := serv.ResendVerification("USER@user.com")
+		errors.Assert(t, ErrTooManyRequests, err)
+
+		assert.Nil(t, serv.ResendVerification("other@user.com"))
+	})
+}
@@ -0,0 +1,342 @@
+package users
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/webauthn"
+)
+
+// Ceremonies a WebAuthn challenge is for.
+const (
+	webAuthnRegister = "register"
+	// webAuthnLogin is a login without password, with a passkey that
+	// verified the user: it stands for both factors.
+	webAuthnLogin = "login"
+	// webAuthnMFA completes a login started with a password.
+	webAuthnMFA = "mfa"
+)
+
+const (
+	webAuthnChallengeTTL  = 5 * time.Minute
+	defaultCredentialName = "Passkey"
+)
+
+// Errors
+var (
+	ErrWebAuthn                     = errors.Status.New("user.service.webauthn").S(500)
+	ErrInvalidWebAuthnRegistration  = errors.Validation.New("user.service.invalid_webauthn_registration").S(400)
+	ErrInvalidWebAuthn              = errors.Status.New("user.service.invalid_webauthn").S(401)
+	ErrWebAuthnCredentialNotFound   = errors.Status.New("user.service.webauthn_credential_not_found").S(404)
+	ErrWebAuthnCredentialRegistered = errors.Validation.New("user.service.webauthn_credential_registered").S(409)
+)
+
+// BeginWebAuthnRegistration returns the options to create a credential
+// with, excluding the ones the user already has.
+func (s *service) BeginWebAuthnRegistration(userID string) (*webauthn.CreationOptions, error) {
+	user, err := s.getByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := s.credentials.FindWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+
+	challenge, err := s.webAuthnChallenge(user.ID, webAuthnRegister)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := strings.TrimSpace(user.Name + " " + user.Lastname)
+	if displayName == "" {
+		displayName = user.Username
+	}
+
+	return s.rp.CreationOptions(challenge, webauthn.User{
+		ID:          []byte(user.ID),
+		Name:        user.Username,
+		DisplayName: displayName,
+	}, descriptors(creds)), nil
+}
+
+type WebAuthnRegistrationRequest struct {
+	// Name tells the user's credentials apart, like "Laptop".
+	Name       string                        `json:"name"`
+	Credential *webauthn.AttestationResponse `json:"credential" binding:"required"`
+}
+
+// FinishWebAuthnRegistration verifies and stores the created credential.
+func (s *service) FinishWebAuthnRegistration(userID string, req *WebAuthnRegistrationRequest) (*models.WebAuthnCredential, error) {
+	if req.Credential == nil {
+		return nil, ErrInvalidWebAuthnRegistration.F("credential", "required")
+	}
+
+	user, err := s.getByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.consumeWebAuthnChallenge(req.Credential.Response.ClientDataJSON, ErrInvalidWebAuthnRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.Purpose != webAuthnRegister || challenge.UserID != user.ID {
+		return nil, ErrInvalidWebAuthnRegistration.M("challenge not for this registration")
+	}
+
+	cred, err := s.rp.VerifyRegistration(challengeBytes(challenge), req.Credential, false)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnRegistration.Wrap(err)
+	}
+
+	id := webauthn.URLBytes(cred.ID).String()
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = defaultCredentialName
+	}
+	credential := models.NewWebAuthnCredential(id, user.ID, name)
+	credential.PublicKey = cred.PublicKey
+	credential.Algorithm = cred.Algorithm
+	credential.SignCount = cred.SignCount
+	credential.AAGUID = cred.AAGUID
+	credential.Transports = cred.Transports
+
+	if err := s.credentials.InsertWebAuthnCredential(credential); err != nil {
+		if isNotAvailable(err) {
+			return nil, ErrWebAuthnCredentialRegistered.C("id", id)
+		}
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+
+	if err := s.updateMFAEnabled(user.ID); err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+func (s *service) ListWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error) {
+	creds, err := s.credentials.FindWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+	return creds, nil
+}
+
+func (s *service) DeleteWebAuthnCredential(userID, id string) error {
+	if err := s.credentials.DeleteWebAuthnCredential(userID, id); err != nil {
+		if isRepositoryNotFound(err) {
+			return ErrWebAuthnCredentialNotFound.C("id", id)
+		}
+		return ErrWebAuthn.Wrap(err)
+	}
+	return s.updateMFAEnabled(userID)
+}
+
+// BeginWebAuthnLogin returns the options to sign in with. Without mfaToken
+// it is a login without password, with any passkey the authenticator has
+// for this service; with the token of Login it is the second factor, with
+// the credentials of that user.
+func (s *service) BeginWebAuthnLogin(mfaToken string) (*webauthn.RequestOptions, error) {
+	if mfaToken == "" {
+		challenge, err := s.webAuthnChallenge("", webAuthnLogin)
+		if err != nil {
+			return nil, err
+		}
+		return s.rp.RequestOptions(challenge, nil, webauthn.UserVerificationRequired), nil
+	}
+
+	claims, err := s.authServ.VerifyAction(mfaToken, ActionMFA)
+	if err != nil {
+		return nil, ErrInvalidMFA.Wrap(err)
+	}
+	creds, err := s.credentials.FindWebAuthnCredentials(claims.Subject)
+	if err != nil {
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+	if len(creds) == 0 {
+		return nil, ErrInvalidMFA.M("no WebAuthn credentials")
+	}
+
+	challenge, err := s.webAuthnChallenge(claims.Subject, webAuthnMFA)
+	if err != nil {
+		return nil, err
+	}
+	return s.rp.RequestOptions(challenge, descriptors(creds), webauthn.UserVerificationPreferred), nil
+}
+
+type WebAuthnLoginRequest struct {
+	// MFAToken is the token of Login when this is the second factor.
+	MFAToken   string                      `json:"mfa_token"`
+	Credential *webauthn.AssertionResponse `json:"credential" binding:"required"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// FinishWebAuthnLogin verifies the signed challenge and creates a session.
+func (s *service) FinishWebAuthnLogin(req *WebAuthnLoginRequest) (*auth.TokenPair, error) {
+	if req.Credential == nil {
+		return nil, ErrInvalidWebAuthn.M("credential required")
+	}
+
+	challenge, err := s.consumeWebAuthnChallenge(req.Credential.Response.ClientDataJSON, ErrInvalidWebAuthn)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID string
+	requireUV := false
+	switch challenge.Purpose {
+	case webAuthnLogin:
+		userID = string(req.Credential.Response.UserHandle)
+		requireUV = true
+	case webAuthnMFA:
+		// The password step must have been passed by the same user
+		if req.MFAToken == "" {
+			return nil, ErrInvalidMFA.M("mfa token required")
+		}
+		claims, err := s.authServ.VerifyAction(req.MFAToken, ActionMFA)
+		if err != nil {
+			return nil, ErrInvalidMFA.Wrap(err)
+		}
+		if claims.Subject != challenge.UserID {
+			return nil, ErrInvalidMFA.M("challenge for another user")
+		}
+		userID = challenge.UserID
+	default:
+		return nil, ErrInvalidWebAuthn.M("challenge not for a login")
+	}
+	if userID == "" {
+		return nil, ErrInvalidWebAuthn.M("no user handle")
+	}
+
+	user, err := s.repo.FindByID(userID)
+	if err != nil || !user.Enabled {
+		return nil, ErrInvalidUser.Wrap(err)
+	}
+
+	credential, err := s.credentials.FindWebAuthnCredential(webauthn.URLBytes(req.Credential.CredentialID()).String())
+	if err != nil {
+		if isRepositoryNotFound(err) {
+			return nil, ErrInvalidWebAuthn.M("unknown credential")
+		}
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+	if credential.UserID != user.ID {
+		return nil, ErrInvalidWebAuthn.M("unknown credential")
+	}
+
+	id, _ := webauthn.DecodeURLBytes(credential.ID)
+	signCount, err := s.rp.VerifyLogin(challengeBytes(challenge), &webauthn.Credential{
+		ID:        id,
+		PublicKey: credential.PublicKey,
+		Algorithm: credential.Algorithm,
+		SignCount: credential.SignCount,
+	}, req.Credential, requireUV)
+	if err != nil {
+		return nil, ErrInvalidWebAuthn.Wrap(err)
+	}
+
+	// Of concurrent logins with the same count, only one is accepted
+	previous := credential.SignCount
+	credential.SignCount = signCount
+	credential.LastUsedAt = s.now().UnixNano()
+	if err := s.credentials.UpdateWebAuthnCredentialUse(credential, previous); err != nil {
+		if isRepositoryNotFound(err) {
+			return nil, ErrInvalidWebAuthn.M("credential used meanwhile")
+		}
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+
+	pair, err := s.authServ.Create(&auth.CreateRequest{
+		UserID:    user.ID,
+		Role:      user.Role,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+	})
+	if err != nil {
+		return nil, ErrInvalidUser.Wrap(err)
+	}
+
+	return pair, nil
+}
+
+// webAuthnChallenge stores a new challenge for the ceremony.
+func (s *service) webAuthnChallenge(userID, purpose string) (webauthn.URLBytes, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+
+	c := models.NewWebAuthnChallenge(challenge.String(), userID, purpose, webAuthnChallengeTTL)
+	if err := s.mfa.InsertWebAuthnChallenge(c); err != nil {
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge takes the challenge the client data was signed
+// for, so a response is only accepted once even by concurrent requests. A
+// response not for a pending challenge is the invalid error.
+func (s *service) consumeWebAuthnChallenge(clientDataJSON []byte, invalid errors.Error) (*models.WebAuthnChallenge, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, invalid.Wrap(err)
+	}
+	b, err := clientData.ChallengeBytes()
+	if err != nil {
+		return nil, invalid.Wrap(err)
+	}
+	key := webauthn.URLBytes(b).String()
+
+	challenge, err := s.mfa.TakeWebAuthnChallenge(key)
+	if err != nil {
+		if isRepositoryNotFound(err) {
+			return nil, invalid.M("unknown challenge")
+		}
+		return nil, ErrWebAuthn.Wrap(err)
+	}
+	if challenge.Expired() {
+		return nil, invalid.M("challenge expired")
+	}
+
+	return challenge, nil
+}
+
+// hasWebAuthn reports whether the user has credentials to use as second
+// factor. Store errors fail, like with TOTP, and a user whose credentials are
+// gone still has MFAEnabled set.
+func (s *service) hasWebAuthn(userID string) (bool, error) {
+	creds, err := s.credentials.FindWebAuthnCredentials(userID)
+	if err != nil {
+		return false, ErrMFA.Wrap(err)
+	}
+	return len(creds) > 0, nil
+}
+
+func challengeBytes(c *models.WebAuthnChallenge) []byte {
+	b, _ := webauthn.DecodeURLBytes(c.Challenge)
+	return b
+}
+
+func descriptors(creds []*models.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	ds := make([]webauthn.CredentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		id, err := webauthn.DecodeURLBytes(c.ID)
+		if err != nil {
+			continue
+		}
+		ds = append(ds, webauthn.CredentialDescriptor{
+			Type:       webauthn.TypePublicKey,
+			ID:         id,
+			Transports: c.Transports,
+		})
+	}
+	return ds
+}
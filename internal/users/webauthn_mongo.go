@@ -0,0 +1,117 @@
+package users
+
+import (
+	"context"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	mongoWebAuthnCollection = "webauthn_credentials"
+	mongoWebAuthnUserIndex  = "user_id"
+)
+
+type mongoWebAuthnRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoWebAuthnRepository makes sure the index on the user of the
+// credentials exists before returning the repository.
+func NewMongoWebAuthnRepository(db *mongo.Database) (WebAuthnRepository, error) {
+	collection := db.Collection(mongoWebAuthnCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"user_id": 1},
+		Options: options.Index().SetName(mongoWebAuthnUserIndex),
+	})
+	if err != nil {
+		return nil, ErrRepositoryIndex.C("collection", mongoWebAuthnCollection).Wrap(err)
+	}
+
+	return &mongoWebAuthnRepository{
+		collection: collection,
+	}, nil
+}
+
+func (r *mongoWebAuthnRepository) FindWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error) {
+	ctx := context.Background()
+	cur, err := r.collection.Find(
+		ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, ErrRepositoryBackend.C("user", userID).Wrap(err)
+	}
+	defer cur.Close(ctx)
+
+	creds := make([]*models.WebAuthnCredential, 0)
+	for cur.Next(ctx) {
+		c := &models.WebAuthnCredential{}
+		if err := cur.Decode(c); err != nil {
+			return nil, ErrRepositoryBackend.C("user", userID).Wrap(err)
+		}
+		creds = append(creds, c)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, ErrRepositoryBackend.C("user", userID).Wrap(err)
+	}
+	return creds, nil
+}
+
+func (r *mongoWebAuthnRepository) FindWebAuthnCredential(id string) (*models.WebAuthnCredential, error) {
+	c := &models.WebAuthnCredential{}
+	err := r.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(c)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRepositoryNotFound.C("id", id)
+	}
+	if err != nil {
+		return nil, ErrRepositoryNotFound.C("id", id).Wrap(err)
+	}
+	return c, nil
+}
+
+func (r *mongoWebAuthnRepository) InsertWebAuthnCredential(c *models.WebAuthnCredential) error {
+	if _, err := r.collection.InsertOne(context.Background(), c); err != nil {
+		if mongoNotAvailable(err) != nil {
+			return ErrNotAvailable.C("id", c.ID)
+		}
+		return ErrRepositoryInsert.C("id", c.ID).Wrap(err)
+	}
+	return nil
+}
+
+// UpdateWebAuthnCredentialUse filters on the old sign count, so the update
+// is a compare and set.
+func (r *mongoWebAuthnRepository) UpdateWebAuthnCredentialUse(c *models.WebAuthnCredential, signCount uint32) error {
+	res, err := r.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": c.ID, "sign_count": signCount},
+		bson.M{"$set": bson.M{"sign_count": c.SignCount, "last_used_at": c.LastUsedAt}},
+	)
+	if err != nil {
+		return ErrRepositoryUpdate.C("id", c.ID).Wrap(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrRepositoryNotFound.C("id", c.ID)
+	}
+
+	return nil
+}
+
+func (r *mongoWebAuthnRepository) DeleteWebAuthnCredential(userID, id string) error {
+	res, err := r.collection.DeleteOne(context.Background(), bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return ErrRepositoryDelete.C("id", id).Wrap(err)
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrRepositoryNotFound.C("id", id)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package users
+
+import (
+	"database/sql"
+
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/lib/pq"
+)
+
+type postgresWebAuthnRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresWebAuthnRepository(db *sql.DB) WebAuthnRepository {
+	return &postgresWebAuthnRepository{
+		db: db,
+	}
+}
+
+const pgWebAuthnColumns = `
+	id, user_id, name, public_key, algorithm, sign_count, aaguid,
+	transports, created_at, last_used_at`
+
+func (r *postgresWebAuthnRepository) FindWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error) {
+	rows, err := r.db.Query(
+		"SELECT"+pgWebAuthnColumns+" FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at, id",
+		userID,
+	)
+	if err != nil {
+		return nil, ErrRepositoryBackend.C("user", userID).Wrap(err)
+	}
+	defer rows.Close()
+
+	creds := make([]*models.WebAuthnCredential, 0)
+	for rows.Next() {
+		c, err := r.scan(rows)
+		if err != nil {
+			return nil, ErrRepositoryBackend.C("user", userID).Wrap(err)
+		}
+		creds = append(creds, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ErrRepositoryBackend.C("user", userID).Wrap(err)
+	}
+	return creds, nil
+}
+
+func (r *postgresWebAuthnRepository) FindWebAuthnCredential(id string) (*models.WebAuthnCredential, error) {
+	row := r.db.QueryRow("SELECT"+pgWebAuthnColumns+" FROM webauthn_credentials WHERE id = $1", id)
+	c, err := r.scan(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrRepositoryNotFound.C("id", id)
+	}
+	if err != nil {
+		return nil, ErrRepositoryNotFound.C("id", id).Wrap(err)
+	}
+	return c, nil
+}
+
+func (r *postgresWebAuthnRepository) InsertWebAuthnCredential(c *models.WebAuthnCredential) error {
+	_, err := r.db.Exec(`
+		INSERT INTO webauthn_credentials(`+pgWebAuthnColumns+`)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		c.ID,
+		c.UserID,
+		c.Name,
+		c.PublicKey,
+		c.Algorithm,
+		int64(c.SignCount),
+		c.AAGUID,
+		pq.Array(c.Transports),
+		c.CreatedAt,
+		c.LastUsedAt,
+	)
+	if err != nil {
+		if postgresNotAvailable(err) != nil {
+			return ErrNotAvailable.C("id", c.ID)
+		}
+		return ErrRepositoryInsert.C("id", c.ID).Wrap(err)
+	}
+	return nil
+}
+
+// UpdateWebAuthnCredentialUse compares and sets the sign count in a single
+// statement.
+func (r *postgresWebAuthnRepository) UpdateWebAuthnCredentialUse(c *models.WebAuthnCredential, signCount uint32) error {
+	res, err := r.db.Exec(`
+		UPDATE webauthn_credentials SET
+			sign_count = $3,
+			last_used_at = $4
+		WHERE id = $1 AND sign_count = $2`,
+		c.ID,
+		int64(signCount),
+		int64(c.SignCount),
+		c.LastUsedAt,
+	)
+	if err != nil {
+		return ErrRepositoryUpdate.C("id", c.ID).Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ErrRepositoryUpdate.C("id", c.ID).Wrap(err)
+	}
+	if n == 0 {
+		return ErrRepositoryNotFound.C("id", c.ID)
+	}
+
+	return nil
+}
+
+func (r *postgresWebAuthnRepository) DeleteWebAuthnCredential(userID, id string) error {
+	res, err := r.db.Exec("DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return ErrRepositoryDelete.C("id", id).Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ErrRepositoryDelete.C("id", id).Wrap(err)
+	}
+	if n == 0 {
+		return ErrRepositoryNotFound.C("id", id)
+	}
+
+	return nil
+}
+
+type pgScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *postgresWebAuthnRepository) scan(row pgScanner) (*models.WebAuthnCredential, error) {
+	var (
+		c          models.WebAuthnCredential
+		signCount  int64
+		transports []string
+	)
+
+	err := row.Scan(
+		&c.ID,
+		&c.UserID,
+		&c.Name,
+		&c.PublicKey,
+		&c.Algorithm,
+		&signCount,
+		&c.AAGUID,
+		pq.Array(&transports),
+		&c.CreatedAt,
+		&c.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SignCount = uint32(signCount)
+	c.Transports = transports
+	return &c, nil
+}
@@ -0,0 +1,143 @@
+package users
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/db"
+	"github.com/aboglioli/big-brother/pkg/models"
+)
+
+// Interfaces
+// WebAuthnRepository stores the WebAuthn credentials of users one by one,
+// next to the users themselves. Credential IDs are unique across users.
+type WebAuthnRepository interface {
+	// FindWebAuthnCredentials returns no credentials, not an error, for
+	// users without them.
+	FindWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error)
+	FindWebAuthnCredential(id string) (*models.WebAuthnCredential, error)
+	// InsertWebAuthnCredential fails with ErrNotAvailable when the ID is
+	// already registered.
+	InsertWebAuthnCredential(c *models.WebAuthnCredential) error
+	// UpdateWebAuthnCredentialUse stores the sign count and last use of c
+	// only while the stored sign count is still signCount. Otherwise it is
+	// ErrRepositoryNotFound: another login used the credential meanwhile.
+	UpdateWebAuthnCredentialUse(c *models.WebAuthnCredential, signCount uint32) error
+	DeleteWebAuthnCredential(userID, id string) error
+}
+
+// NewWebAuthnRepository connects to the backend selected by
+// config.UserRepository, like NewRepository.
+func NewWebAuthnRepository() (WebAuthnRepository, error) {
+	c := config.Get()
+
+	switch c.UserRepository {
+	case "postgres":
+		conn, err := db.ConnectPostgres(c.PostgresURL, c.PostgresDatabase, c.PostgresUsername, c.PostgresPassword)
+		if err != nil {
+			return nil, ErrRepositoryBackend.C("backend", c.UserRepository).Wrap(err)
+		}
+		return NewPostgresWebAuthnRepository(conn), nil
+	case "mongo":
+		conn, err := db.ConnectMongo(c.MongoURL, c.MongoDatabase, c.MongoUsername, c.MongoPassword)
+		if err != nil {
+			return nil, ErrRepositoryBackend.C("backend", c.UserRepository).Wrap(err)
+		}
+		return NewMongoWebAuthnRepository(conn)
+	case "memory":
+		return NewInMemoryWebAuthnRepository(), nil
+	}
+
+	return nil, ErrRepositoryBackend.M("unknown users backend %s", c.UserRepository).C("backend", c.UserRepository)
+}
+
+// Implementations
+type inMemoryWebAuthnRepository struct {
+	mux   sync.RWMutex
+	creds map[string]*models.WebAuthnCredential
+}
+
+// NewInMemoryWebAuthnRepository returns a concurrency-safe repository that
+// copies credentials on every read and write.
+func NewInMemoryWebAuthnRepository() WebAuthnRepository {
+	return &inMemoryWebAuthnRepository{
+		creds: make(map[string]*models.WebAuthnCredential),
+	}
+}
+
+// FindWebAuthnCredentials returns the oldest credentials first, like the
+// other backends.
+func (r *inMemoryWebAuthnRepository) FindWebAuthnCredentials(userID string) ([]*models.WebAuthnCredential, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	creds := make([]*models.WebAuthnCredential, 0)
+	for _, c := range r.creds {
+		if c.UserID == userID {
+			creds = append(creds, copyOfCredential(c))
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool {
+		if creds[i].CreatedAt != creds[j].CreatedAt {
+			return creds[i].CreatedAt < creds[j].CreatedAt
+		}
+		return creds[i].ID < creds[j].ID
+	})
+	return creds, nil
+}
+
+func (r *inMemoryWebAuthnRepository) FindWebAuthnCredential(id string) (*models.WebAuthnCredential, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	c, ok := r.creds[id]
+	if !ok {
+		return nil, ErrRepositoryNotFound.C("id", id)
+	}
+	return copyOfCredential(c), nil
+}
+
+func (r *inMemoryWebAuthnRepository) InsertWebAuthnCredential(c *models.WebAuthnCredential) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.creds[c.ID]; ok {
+		return ErrNotAvailable.C("id", c.ID)
+	}
+	r.creds[c.ID] = copyOfCredential(c)
+	return nil
+}
+
+func (r *inMemoryWebAuthnRepository) UpdateWebAuthnCredentialUse(c *models.WebAuthnCredential, signCount uint32) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	stored, ok := r.creds[c.ID]
+	if !ok || stored.SignCount != signCount {
+		return ErrRepositoryNotFound.C("id", c.ID)
+	}
+	stored.SignCount = c.SignCount
+	stored.LastUsedAt = c.LastUsedAt
+	return nil
+}
+
+func (r *inMemoryWebAuthnRepository) DeleteWebAuthnCredential(userID, id string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	c, ok := r.creds[id]
+	if !ok || c.UserID != userID {
+		return ErrRepositoryNotFound.C("id", id)
+	}
+	delete(r.creds, id)
+	return nil
+}
+
+func copyOfCredential(c *models.WebAuthnCredential) *models.WebAuthnCredential {
+	cp := *c
+	cp.PublicKey = append([]byte(nil), c.PublicKey...)
+	cp.AAGUID = append([]byte(nil), c.AAGUID...)
+	cp.Transports = append([]string(nil), c.Transports...)
+	return &cp
+}
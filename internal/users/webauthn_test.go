@@ -0,0 +1,223 @@
+package users
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aboglioli/big-brother/internal/auth"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/models"
+	"github.com/aboglioli/big-brother/pkg/utils"
+	"github.com/aboglioli/big-brother/pkg/webauthn"
+	"github.com/aboglioli/big-brother/pkg/webauthn/webauthntest"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebAuthn(t *testing.T) {
+	mUser := mockUser()
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+
+	serv := newMockService()
+	serv.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+	serv.repo.On("FindByUsername", mUser.Username).Return(mUser, nil)
+	serv.repo.On("Update", mUser).Return(nil)
+	serv.crypt.On("Compare", mUser.Password, "12345678").Return(true)
+	serv.authServ.On("SignAction", mock.AnythingOfType("*auth.ActionRequest")).Return("mfa.token", nil)
+	serv.authServ.On("VerifyAction", "mfa.token", ActionMFA).Return(&auth.ActionClaims{
+		StandardClaims: jwt.StandardClaims{Subject: mUser.ID},
+		Action:         ActionMFA,
+	}, nil)
+	serv.authServ.On("VerifyAction", "other.token", ActionMFA).Return(&auth.ActionClaims{
+		StandardClaims: jwt.StandardClaims{Subject: models.NewID()},
+		Action:         ActionMFA,
+	}, nil)
+	serv.authServ.On("Create", mock.AnythingOfType("*auth.CreateRequest")).Return(mPair, nil)
+
+	a := webauthntest.New("http://localhost:3344")
+	login := func() *LoginResponse {
+		res, err := serv.Login(&LoginRequest{
+			UsernameOrEmail: &mUser.Username,
+			Password:        utils.NewString("12345678"),
+		})
+		require.Nil(t, err)
+		return res
+	}
+	assertion := func(a *webauthntest.Authenticator, mfaToken string) *webauthn.AssertionResponse {
+		opts, err := serv.BeginWebAuthnLogin(mfaToken)
+		require.Nil(t, err)
+		res, err := a.Get(opts)
+		require.Nil(t, err)
+		return res
+	}
+
+	// Registration
+	opts, err := serv.BeginWebAuthnRegistration(mUser.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "localhost", opts.RP.ID)
+	assert.Equal(t, []byte(mUser.ID), []byte(opts.User.ID))
+	assert.Equal(t, "Name Lastname", opts.User.DisplayName)
+	attestation, err := a.Create(opts)
+	require.Nil(t, err)
+
+	req := &WebAuthnRegistrationRequest{Name: " Laptop ", Credential: attestation}
+	cred, err := serv.FinishWebAuthnRegistration(mUser.ID, req)
+	require.Nil(t, err)
+	assert.Equal(t, attestation.ID, cred.ID)
+	assert.Equal(t, "Laptop", cred.Name)
+	assert.NotEmpty(t, cred.PublicKey)
+	assert.True(t, mUser.MFAEnabled)
+
+	_, err = serv.FinishWebAuthnRegistration(mUser.ID, req)
+	errors.Assert(t, ErrInvalidWebAuthnRegistration, err)
+
+	opts, err = serv.BeginWebAuthnRegistration(mUser.ID)
+	require.Nil(t, err)
+	require.Len(t, opts.ExcludeCredentials, 1)
+	_, err = a.Create(opts)
+	assert.NotNil(t, err)
+
+	creds, err := serv.ListWebAuthnCredentials(mUser.ID)
+	require.Nil(t, err)
+	assert.Len(t, creds, 1)
+
+	// Second factor
+	res := login()
+	assert.Nil(t, res.TokenPair)
+	assert.True(t, res.MFARequired)
+	assert.Equal(t, []string{MFAWebAuthn}, res.MFAMethods)
+
+	signed := assertion(a, res.MFAToken)
+	_, err = serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{MFAToken: "other.token", Credential: signed})
+	errors.Assert(t, ErrInvalidMFA, err)
+
+	signed = assertion(a, res.MFAToken)
+	_, err = serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{Credential: signed})
+	errors.Assert(t, ErrInvalidMFA, err)
+
+	signed = assertion(a, res.MFAToken)
+	pair, err := serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{MFAToken: res.MFAToken, Credential: signed})
+	require.Nil(t, err)
+	assert.Equal(t, mPair, pair)
+	_, err = serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{MFAToken: res.MFAToken, Credential: signed})
+	errors.Assert(t, ErrInvalidWebAuthn, err)
+
+	creds, err = serv.ListWebAuthnCredentials(mUser.ID)
+	require.Nil(t, err)
+	assert.NotZero(t, creds[0].LastUsedAt)
+	assert.NotZero(t, creds[0].SignCount)
+
+	// Without password
+	pair, err = serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{Credential: assertion(a, "")})
+	require.Nil(t, err)
+	assert.Equal(t, mPair, pair)
+
+	a.UserVerified = false
+	_, err = serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{Credential: assertion(a, "")})
+	errors.Assert(t, ErrInvalidWebAuthn, err)
+
+	// Created but never registered
+	other := webauthntest.New("http://localhost:3344")
+	opts, err = serv.BeginWebAuthnRegistration(mUser.ID)
+	require.Nil(t, err)
+	_, err = other.Create(opts)
+	require.Nil(t, err)
+	_, err = serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{Credential: assertion(other, "")})
+	errors.Assert(t, ErrInvalidWebAuthn, err)
+
+	// Removal
+	assert.Nil(t, serv.DeleteWebAuthnCredential(mUser.ID, cred.ID))
+	errors.Assert(t, ErrWebAuthnCredentialNotFound, serv.DeleteWebAuthnCredential(mUser.ID, cred.ID))
+	assert.False(t, mUser.MFAEnabled)
+	assert.Equal(t, mPair, login().TokenPair)
+	_, err = serv.BeginWebAuthnLogin("mfa.token")
+	errors.Assert(t, ErrInvalidMFA, err)
+}
+
+func TestWebAuthnConcurrency(t *testing.T) {
+	mUser := mockUser()
+	// Already set, so the shared user is only read
+	mUser.MFAEnabled = true
+	mPair := &auth.TokenPair{AccessToken: "access.token", RefreshToken: "refresh.token"}
+
+	serv := newMockService()
+	serv.repo.On("FindByID", mUser.ID).Return(mUser, nil)
+	serv.authServ.On("Create", mock.AnythingOfType("*auth.CreateRequest")).Return(mPair, nil)
+
+	// Registrations
+	authenticators := make([]*webauthntest.Authenticator, 4)
+	reqs := make([]*WebAuthnRegistrationRequest, len(authenticators))
+	for i := range authenticators {
+		authenticators[i] = webauthntest.New("http://localhost:3344")
+		opts, err := serv.BeginWebAuthnRegistration(mUser.ID)
+		require.Nil(t, err)
+		attestation, err := authenticators[i].Create(opts)
+		require.Nil(t, err)
+		reqs[i] = &WebAuthnRegistrationRequest{Credential: attestation}
+	}
+
+	var wg sync.WaitGroup
+	for _, req := range reqs {
+		wg.Add(1)
+		go func(req *WebAuthnRegistrationRequest) {
+			defer wg.Done()
+			_, err := serv.FinishWebAuthnRegistration(mUser.ID, req)
+			assert.Nil(t, err)
+		}(req)
+	}
+	wg.Wait()
+
+	creds, err := serv.ListWebAuthnCredentials(mUser.ID)
+	require.Nil(t, err)
+	assert.Len(t, creds, len(authenticators))
+
+	// Logins with the same response
+	opts, err := serv.BeginWebAuthnLogin("")
+	require.Nil(t, err)
+	signed, err := authenticators[0].Get(opts)
+	require.Nil(t, err)
+
+	results := make(chan error, 4)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := serv.FinishWebAuthnLogin(&WebAuthnLoginRequest{Credential: signed})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		} else {
+			errors.Assert(t, ErrInvalidWebAuthn, err)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestLoginWithUnavailableWebAuthnStore(t *testing.T) {
+	mUser := mockUser()
+	creds := &mockWebAuthnRepository{}
+	creds.On("FindWebAuthnCredentials", mUser.ID).Return(nil, ErrRepositoryBackend)
+
+	serv := newMockService()
+	serv.credentials = creds
+	serv.repo.On("FindByUsername", mUser.Username).Return(mUser, nil)
+	serv.crypt.On("Compare", mUser.Password, "12345678").Return(true)
+
+	res, err := serv.Login(&LoginRequest{
+		UsernameOrEmail: &mUser.Username,
+		Password:        utils.NewString("12345678"),
+	})
+
+	errors.Assert(t, ErrMFA, err)
+	assert.Nil(t, res)
+	serv.authServ.AssertNotCalled(t, "Create", mock.Anything)
+}
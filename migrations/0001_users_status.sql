@@ -0,0 +1,4 @@
+\c users_and_organizations
+-- Users status
+ALTER TABLE users ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS validated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+\c users_and_organizations
+-- Usernames and emails are unique regardless of case. The unique indexes keep
+-- the names of the constraints they replace, which the repository maps to
+-- errors.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (lower(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email));
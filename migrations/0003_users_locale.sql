@@ -0,0 +1,3 @@
+\c users_and_organizations
+-- Language users are mailed in
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(16) NOT NULL DEFAULT '';
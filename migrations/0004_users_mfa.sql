@@ -0,0 +1,3 @@
+\c users_and_organizations
+-- Users with a second factor
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+\c users_and_organizations
+-- WebAuthn credentials, one row each
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id TEXT PRIMARY KEY,
+    user_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    public_key BYTEA NOT NULL,
+    algorithm INTEGER NOT NULL,
+    sign_count BIGINT NOT NULL,
+    aaguid BYTEA,
+    transports TEXT[],
+    created_at BIGINT NOT NULL,
+    last_used_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id ON webauthn_credentials(user_id);
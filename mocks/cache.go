@@ -24,7 +24,22 @@ func (c *MockCache) Set(k string, v interface{}, d time.Duration) error {
 	return args.Error(0)
 }
 
+func (c *MockCache) Add(k string, v interface{}, d time.Duration) (bool, error) {
+	args := c.Called(k, v)
+	return args.Bool(0), args.Error(1)
+}
+
 func (c *MockCache) Delete(k string) error {
 	args := c.Called(k)
 	return args.Error(0)
 }
+
+func (c *MockCache) Take(k string) (interface{}, error) {
+	args := c.Called(k)
+	return args.Get(0), args.Error(1)
+}
+
+func (c *MockCache) Incr(k string, d time.Duration) (int64, error) {
+	args := c.Called(k)
+	return args.Get(0).(int64), args.Error(1)
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"time"
 
+	"github.com/aboglioli/big-brother/pkg/config"
 	"github.com/aboglioli/big-brother/pkg/errors"
 )
 
@@ -10,10 +11,53 @@ var (
 	ErrCacheNotFound = errors.Internal.New("cache.not_found")
 	ErrCacheSet      = errors.Internal.New("cache.set")
 	ErrCacheDelete   = errors.Internal.New("cache.delete")
+	ErrCacheBackend  = errors.Internal.New("cache.backend")
 )
 
 type Cache interface {
 	Get(k string) (interface{}, error)
 	Set(k string, v interface{}, d time.Duration) error
+	// Add sets v only if k does not exist, reporting whether it did. It is
+	// atomic: of concurrent calls for the same key only one adds it.
+	Add(k string, v interface{}, d time.Duration) (bool, error)
 	Delete(k string) error
+	// Take gets and deletes k at once: of concurrent calls for the same key
+	// only one gets it.
+	Take(k string) (interface{}, error)
+	// Incr adds one to the counter in k and returns the new count. A missing
+	// counter starts at zero and expires after d; counting does not extend
+	// it. It is atomic: concurrent calls for the same key get different
+	// counts.
+	Incr(k string, d time.Duration) (int64, error)
+}
+
+// New returns the cache selected by config.Cache under namespace ns.
+func New(ns string) (Cache, error) {
+	c := config.Get()
+
+	switch c.Cache {
+	case "redis":
+		return NewRedis(ns)
+	case "memory":
+		return NewInMemory(ns), nil
+	}
+
+	return nil, ErrCacheBackend.M("unknown cache backend %s", c.Cache).C("backend", c.Cache)
+}
+
+// NewPersistent is New for data stored with NoExpiration that must never be
+// evicted. Redis must be configured with a maxmemory-policy that only evicts
+// keys with a TTL, and with persistence to survive restarts; the in-memory
+// cache never evicts them but loses them on restart.
+func NewPersistent(ns string) (Cache, error) {
+	c := config.Get()
+
+	switch c.Cache {
+	case "redis":
+		return NewPersistentRedis(ns)
+	case "memory":
+		return NewInMemory(ns), nil
+	}
+
+	return nil, ErrCacheBackend.M("unknown cache backend %s", c.Cache).C("backend", c.Cache)
 }
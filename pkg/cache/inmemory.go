@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"sync"
 	"time"
 
 	gocache "github.com/patrickmn/go-cache"
@@ -12,6 +13,8 @@ const (
 )
 
 type goCache struct {
+	// mux makes Take and Incr atomic with respect to the other writes.
+	mux       sync.Mutex
 	cache     *gocache.Cache
 	namespace string
 }
@@ -35,12 +38,51 @@ func (c *goCache) Get(k string) (interface{}, error) {
 
 func (c *goCache) Set(k string, v interface{}, d time.Duration) error {
 	k = applyNamespace(c.namespace, k)
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.cache.Set(k, v, d)
 	return nil
 }
 
+func (c *goCache) Add(k string, v interface{}, d time.Duration) (bool, error) {
+	k = applyNamespace(c.namespace, k)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err := c.cache.Add(k, v, d); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
 func (c *goCache) Delete(k string) error {
 	k = applyNamespace(c.namespace, k)
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.cache.Delete(k)
 	return nil
 }
+
+func (c *goCache) Take(k string) (interface{}, error) {
+	k = applyNamespace(c.namespace, k)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	data, ok := c.cache.Get(k)
+	if !ok {
+		return nil, ErrCacheNotFound.C("key", k)
+	}
+	c.cache.Delete(k)
+	return data, nil
+}
+
+func (c *goCache) Incr(k string, d time.Duration) (int64, error) {
+	k = applyNamespace(c.namespace, k)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n, err := c.cache.IncrementInt64(k, 1)
+	if err != nil {
+		// Missing, expired or not a counter: start over
+		c.cache.Set(k, int64(1), d)
+		return 1, nil
+	}
+	return n, nil
+}
@@ -9,9 +9,28 @@ import (
 )
 
 var (
-	ErrRedisConnect = errors.Internal.New("cache.redis.connect")
+	ErrRedisConnect  = errors.Internal.New("cache.redis.connect")
+	ErrRedisEviction = errors.Internal.New("cache.redis.eviction")
 )
 
+// evictingPolicies may evict keys without a TTL when Redis runs out of
+// memory.
+var evictingPolicies = map[string]bool{
+	"allkeys-lru":    true,
+	"allkeys-lfu":    true,
+	"allkeys-random": true,
+}
+
+// incrScript sets the expiration only when INCR creates the counter, in the
+// same step so that a counter never stays without one.
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
 type redisCache struct {
 	client    *redis.Client
 	namespace string
@@ -36,12 +55,46 @@ func NewRedis(ns string) (Cache, error) {
 	}, nil
 }
 
+// NewPersistentRedis is NewRedis for NewPersistent: it fails if the server
+// may evict keys without a TTL, or if its policy cannot be read.
+func NewPersistentRedis(ns string) (Cache, error) {
+	c, err := NewRedis(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.(*redisCache).client.ConfigGet("maxmemory-policy").Result()
+	if err != nil {
+		return nil, ErrRedisEviction.M("cannot read maxmemory-policy").Wrap(err)
+	}
+	if err := checkEvictionPolicy(res); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// checkEvictionPolicy checks the reply of CONFIG GET maxmemory-policy.
+func checkEvictionPolicy(res []interface{}) error {
+	if len(res) != 2 {
+		return ErrRedisEviction.M("unexpected maxmemory-policy reply")
+	}
+	policy, _ := res[1].(string)
+	if policy == "" || evictingPolicies[policy] {
+		return ErrRedisEviction.M("maxmemory-policy %s may evict keys without expiration", policy).C("policy", policy)
+	}
+	return nil
+}
+
 func (r *redisCache) Get(k string) (interface{}, error) {
 	k = applyNamespace(r.namespace, k)
 	v, err := r.client.Get(k).Result()
-	if err != nil {
+	if err == redis.Nil {
 		return nil, ErrCacheNotFound.M("key = %s", k).Wrap(err)
 	}
+	if err != nil {
+		return nil, ErrCacheBackend.M("key = %s", k).Wrap(err)
+	}
 	return v, nil
 }
 
@@ -54,6 +107,15 @@ func (r *redisCache) Set(k string, v interface{}, d time.Duration) error {
 	return nil
 }
 
+func (r *redisCache) Add(k string, v interface{}, d time.Duration) (bool, error) {
+	k = applyNamespace(r.namespace, k)
+	ok, err := r.client.SetNX(k, v, d).Result()
+	if err != nil {
+		return false, ErrCacheSet.M("key = %s; value = %s", k, v).Wrap(err)
+	}
+	return ok, nil
+}
+
 func (r *redisCache) Delete(k string) error {
 	k = applyNamespace(r.namespace, k)
 	res := r.client.Del(k)
@@ -62,3 +124,30 @@ func (r *redisCache) Delete(k string) error {
 	}
 	return nil
 }
+
+// Take runs GET and DEL in a MULTI transaction, as GETDEL needs Redis 6.2.
+func (r *redisCache) Take(k string) (interface{}, error) {
+	k = applyNamespace(r.namespace, k)
+	var get *redis.StringCmd
+	_, err := r.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(k)
+		pipe.Del(k)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, ErrCacheNotFound.M("key = %s", k).Wrap(err)
+	}
+	if err != nil {
+		return nil, ErrCacheBackend.M("key = %s", k).Wrap(err)
+	}
+	return get.Val(), nil
+}
+
+func (r *redisCache) Incr(k string, d time.Duration) (int64, error) {
+	k = applyNamespace(r.namespace, k)
+	n, err := incrScript.Run(r.client, []string{k}, int64(d/time.Millisecond)).Int64()
+	if err != nil {
+		return 0, ErrCacheSet.M("key = %s", k).Wrap(err)
+	}
+	return n, nil
+}
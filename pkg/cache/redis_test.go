@@ -3,6 +3,7 @@ package cache
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/aboglioli/big-brother/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -83,4 +84,75 @@ func TestRedis(t *testing.T) {
 		err = r.Delete("data123")
 		assert.Nil(err)
 	})
+
+	t.Run("add", func(t *testing.T) {
+		ok, err := r.Add("added", "first", 0)
+		assert.Nil(err)
+		assert.True(ok)
+
+		ok, err = r.Add("added", "second", 0)
+		assert.Nil(err)
+		assert.False(ok)
+
+		v, err := r.Get("added")
+		assert.Nil(err)
+		assert.Equal("first", v)
+
+		err = r.Delete("added")
+		assert.Nil(err)
+	})
+
+	t.Run("take", func(t *testing.T) {
+		err := r.Set("taken", "value", 0)
+		assert.Nil(err)
+
+		v, err := r.Take("taken")
+		assert.Nil(err)
+		assert.Equal("value", v)
+
+		v, err = r.Take("taken")
+		errors.Assert(t, ErrCacheNotFound, err)
+		assert.Nil(v)
+	})
+
+	t.Run("incr", func(t *testing.T) {
+		n, err := r.Incr("counter", time.Minute)
+		assert.Nil(err)
+		assert.Equal(int64(1), n)
+
+		n, err = r.Incr("counter", time.Minute)
+		assert.Nil(err)
+		assert.Equal(int64(2), n)
+
+		ttl, err := r.(*redisCache).client.PTTL("namespace:counter").Result()
+		assert.Nil(err)
+		assert.True(ttl > 0 && ttl <= time.Minute)
+
+		err = r.Delete("counter")
+		assert.Nil(err)
+	})
+}
+
+func TestCheckEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		res  []interface{}
+		err  error
+	}{
+		{"no eviction", []interface{}{"maxmemory-policy", "noeviction"}, nil},
+		{"only keys with ttl", []interface{}{"maxmemory-policy", "volatile-lru"}, nil},
+		{"any key", []interface{}{"maxmemory-policy", "allkeys-lru"}, ErrRedisEviction},
+		{"unexpected reply", []interface{}{}, ErrRedisEviction},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkEvictionPolicy(test.res)
+			if test.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			errors.Assert(t, test.err, err)
+		})
+	}
 }
@@ -0,0 +1,106 @@
+package errors
+
+import (
+	"net/http"
+)
+
+// ProblemContentType is the media type of Problem documents (RFC 7807).
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details document.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+	Errors        []*Problem     `json:"errors,omitempty"`
+	Stack         []Stack        `json:"stack,omitempty"`
+}
+
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Code   string `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ProblemOptions struct {
+	// Debug exposes internal errors and the full cause stack.
+	Debug bool
+}
+
+// NewProblem maps Code to type, Message to detail and Fields to
+// invalid-params. Internal and unknown errors are reported as about:blank
+// unless opts.Debug is set. Errors become a problem whose errors extension
+// holds one problem per error.
+func NewProblem(err error, opts *ProblemOptions) *Problem {
+	if opts == nil {
+		opts = &ProblemOptions{}
+	}
+
+	status := StatusCode(err)
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+
+	switch err := err.(type) {
+	case Errors:
+		for _, err := range err {
+			child := NewProblem(err, opts)
+			p.InvalidParams = append(p.InvalidParams, child.InvalidParams...)
+			p.Errors = append(p.Errors, child)
+		}
+	case Error:
+		if (err.Type != Internal && err.Type != Unknown) || opts.Debug {
+			p.Type = err.Code
+			p.Detail = err.Message
+			for _, f := range err.Fields {
+				p.InvalidParams = append(p.InvalidParams, InvalidParam{
+					Name:   f.Field,
+					Code:   f.Code,
+					Reason: f.Message,
+				})
+			}
+		}
+		if opts.Debug && err.Cause != nil {
+			p.Stack = BuildStack(err.Cause, FullStack)
+		}
+	case error:
+		if opts.Debug {
+			p.Detail = err.Error()
+		}
+	}
+
+	return p
+}
+
+// StatusCode returns the HTTP status set with Error.S or, if there is none, a
+// fallback by Type. For Errors the highest status wins.
+func StatusCode(err error) int {
+	switch err := err.(type) {
+	case Errors:
+		status := 0
+		for _, err := range err {
+			if s := StatusCode(err); s > status {
+				status = s
+			}
+		}
+		if status == 0 {
+			return http.StatusInternalServerError
+		}
+		return status
+	case Error:
+		if err.Status != 0 {
+			return err.Status
+		}
+
+		switch err.Type {
+		case Validation, Status:
+			return http.StatusBadRequest
+		}
+	}
+
+	return http.StatusInternalServerError
+}
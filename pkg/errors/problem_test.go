@@ -0,0 +1,133 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"raw error", errors.New("raw"), 500},
+		{"internal", Internal.New("I"), 500},
+		{"unknown", Unknown.New("U"), 500},
+		{"status", Status.New("S"), 400},
+		{"validation", Validation.New("V"), 400},
+		{"explicit status", Status.New("S").S(404), 404},
+		{"explicit internal status", Internal.New("I").S(503), 503},
+		{"empty errors", Errors{}, 500},
+		{"errors", Errors{Validation.New("V"), Status.New("S").S(409)}, 409},
+		{"errors with internal", Errors{Validation.New("V"), Internal.New("I")}, 500},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.status, StatusCode(test.err))
+		})
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		opts *ProblemOptions
+		out  *Problem
+	}{{
+		"raw error",
+		errors.New("raw"),
+		nil,
+		&Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500},
+	}, {
+		"raw error with debug",
+		errors.New("raw"),
+		&ProblemOptions{Debug: true},
+		&Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500, Detail: "raw"},
+	}, {
+		"internal error",
+		Internal.New("db.insert").M("duplicated key").Wrap(errors.New("raw")),
+		nil,
+		&Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500},
+	}, {
+		"status error hides cause",
+		Status.New("user.not_found").M("user %s not found", "123").S(404).Wrap(Internal.New("db.not_found")),
+		nil,
+		&Problem{Type: "user.not_found", Title: "Not Found", Status: 404, Detail: "user 123 not found"},
+	}, {
+		"status error with debug",
+		Status.New("user.not_found").S(404).Wrap(Internal.New("db.not_found").P("db.go")),
+		&ProblemOptions{Debug: true},
+		&Problem{
+			Type:   "user.not_found",
+			Title:  "Not Found",
+			Status: 404,
+			Stack:  []Stack{{Error: Error{Type: Internal, Code: "db.not_found", Path: "db.go"}}},
+		},
+	}, {
+		"validation error",
+		Validation.New("user.invalid_schema").F("email", "invalid", "must be an %s", "email").F("name", "required"),
+		nil,
+		&Problem{
+			Type:   "user.invalid_schema",
+			Title:  "Bad Request",
+			Status: 400,
+			InvalidParams: []InvalidParam{
+				{Name: "email", Code: "invalid", Reason: "must be an email"},
+				{Name: "name", Code: "required"},
+			},
+		},
+	}, {
+		"aggregate",
+		Errors{
+			Validation.New("user.invalid_password").F("password", "too_weak"),
+			Validation.New("user.invalid_schema").F("email", "invalid"),
+		},
+		nil,
+		&Problem{
+			Type:   "about:blank",
+			Title:  "Bad Request",
+			Status: 400,
+			InvalidParams: []InvalidParam{
+				{Name: "password", Code: "too_weak"},
+				{Name: "email", Code: "invalid"},
+			},
+			Errors: []*Problem{{
+				Type:          "user.invalid_password",
+				Title:         "Bad Request",
+				Status:        400,
+				InvalidParams: []InvalidParam{{Name: "password", Code: "too_weak"}},
+			}, {
+				Type:          "user.invalid_schema",
+				Title:         "Bad Request",
+				Status:        400,
+				InvalidParams: []InvalidParam{{Name: "email", Code: "invalid"}},
+			}},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.out, NewProblem(test.err, test.opts))
+		})
+	}
+}
+
+func TestProblemJSON(t *testing.T) {
+	p := NewProblem(Validation.New("user.invalid_schema").F("email", "invalid"), nil)
+
+	b, err := json.Marshal(p)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{
+		"type": "user.invalid_schema",
+		"title": "Bad Request",
+		"status": 400,
+		"invalid-params": [{"name": "email", "code": "invalid"}]
+	}`, string(b))
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"sync"
+)
+
+// InMemory keeps sent messages, for tests.
+type InMemory struct {
+	mux      sync.Mutex
+	messages []*Message
+}
+
+func NewInMemory() *InMemory {
+	return &InMemory{}
+}
+
+func (m *InMemory) Send(msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	sent := *msg
+	m.messages = append(m.messages, &sent)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *InMemory) Messages() []*Message {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	messages := make([]*Message, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
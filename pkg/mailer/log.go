@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"io"
+	"sync"
+)
+
+// logMailer writes messages to w instead of sending them, for development.
+type logMailer struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+// NewLog writes every message to w, one after the other, as they would be
+// sent.
+func NewLog(w io.Writer) Mailer {
+	return &logMailer{w: w}
+}
+
+func (m *logMailer) Send(msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	b := append(msg.Bytes(), "\r\n"...)
+	if _, err := m.w.Write(b); err != nil {
+		return ErrSend.Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+// Errors
+var (
+	ErrInvalidMessage = errors.Internal.New("mailer.invalid_message")
+	ErrSend           = errors.Internal.New("mailer.send")
+	ErrBackend        = errors.Internal.New("mailer.backend")
+)
+
+// Message is an email with a plain text body and, optionally, an HTML
+// alternative.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Validate checks the message has what every backend needs.
+func (m *Message) Validate() error {
+	if m.Subject == "" || m.Text == "" || len(m.To) == 0 {
+		return ErrInvalidMessage.C("subject", m.Subject)
+	}
+	if _, err := m.addresses(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// addresses returns the bare addresses of the sender and the recipients,
+// without display names, as the SMTP envelope needs them.
+func (m *Message) addresses() ([]string, error) {
+	addrs := make([]string, 0, len(m.To)+1)
+	for _, a := range append([]string{m.From}, m.To...) {
+		addr, err := mail.ParseAddress(a)
+		if err != nil {
+			return nil, ErrInvalidMessage.M("invalid address %q", a).Wrap(err)
+		}
+		addrs = append(addrs, addr.Address)
+	}
+	return addrs, nil
+}
+
+// Bytes formats the message as RFC 5322, with a multipart/alternative body
+// when it has HTML.
+func (m *Message) Bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if m.HTML == "" {
+		writePart(&b, "text/plain", m.Text)
+		return b.Bytes()
+	}
+
+	boundary := newBoundary()
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	writePart(&b, "text/plain", m.Text)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	writePart(&b, "text/html", m.HTML)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+func writePart(b *bytes.Buffer, contentType, body string) {
+	fmt.Fprintf(b, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(b)
+	w.Write([]byte(body))
+	w.Close()
+}
+
+func newBoundary() string {
+	r := make([]byte, 16)
+	rand.Read(r)
+	return hex.EncodeToString(r)
+}
+
+// Interfaces
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// New returns the mailer selected by config.Mailer.
+func New() (Mailer, error) {
+	c := config.Get()
+
+	switch c.Mailer {
+	case "smtp":
+		return NewSMTP(c.SMTPAddr, c.SMTPUsername, c.SMTPPassword), nil
+	case "log":
+		if c.MailFile == "" {
+			return NewLog(os.Stdout), nil
+		}
+		file, err := os.OpenFile(c.MailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, ErrBackend.C("file", c.MailFile).Wrap(err)
+		}
+		return NewLog(file), nil
+	case "memory":
+		return NewInMemory(), nil
+	}
+
+	return nil, ErrBackend.M("unknown mailer backend %s", c.Mailer).C("backend", c.Mailer)
+}
@@ -0,0 +1,170 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockMessage() *Message {
+	return &Message{
+		From:    "Big Brother <no-reply@localhost>",
+		To:      []string{"user@user.com"},
+		Subject: "Verificá tu email",
+		Text:    "Follow https://example.com/verify?token=abc",
+		HTML:    `<a href="https://example.com/verify?token=abc">Verify</a>`,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  func(m *Message)
+		err  error
+	}{
+		{"valid", func(m *Message) {}, nil},
+		{"without recipients", func(m *Message) { m.To = nil }, ErrInvalidMessage},
+		{"without subject", func(m *Message) { m.Subject = "" }, ErrInvalidMessage},
+		{"without text", func(m *Message) { m.Text = "" }, ErrInvalidMessage},
+		{"header injection", func(m *Message) { m.To = []string{"user@user.com\r\nBcc: other@user.com"} }, ErrInvalidMessage},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg := mockMessage()
+			test.msg(msg)
+			err := msg.Validate()
+			if test.err == nil {
+				assert.Nil(t, err)
+			} else {
+				errors.Assert(t, test.err, err)
+			}
+		})
+	}
+}
+
+func TestBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(mockMessage().Bytes()))
+	require.Nil(t, err)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	require.Nil(t, err)
+	assert.Equal("Verificá tu email", subject)
+	assert.Equal("user@user.com", parsed.Header.Get("To"))
+
+	contentType := parsed.Header.Get("Content-Type")
+	require.True(t, strings.HasPrefix(contentType, "multipart/alternative"))
+	boundary := contentType[strings.Index(contentType, `boundary="`)+10 : len(contentType)-1]
+
+	r := multipart.NewReader(parsed.Body, boundary)
+	var types, bodies []string
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		b, _ := ioutil.ReadAll(part)
+		types = append(types, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(b))
+	}
+	assert.Equal([]string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+	assert.Equal([]string{mockMessage().Text, mockMessage().HTML}, bodies)
+}
+
+func TestLog(t *testing.T) {
+	var b bytes.Buffer
+	m := NewLog(&b)
+
+	require.Nil(t, m.Send(mockMessage()))
+	assert.Contains(t, b.String(), "To: user@user.com")
+
+	errors.Assert(t, ErrInvalidMessage, m.Send(&Message{}))
+}
+
+func TestInMemory(t *testing.T) {
+	m := NewInMemory()
+
+	msg := mockMessage()
+	require.Nil(t, m.Send(msg))
+	msg.Subject = "Changed"
+
+	messages := m.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Verificá tu email", messages[0].Subject)
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(t, l, received)
+
+	m := NewSMTP(l.Addr().String(), "", "")
+	require.Nil(t, m.Send(mockMessage()))
+
+	data := <-received
+	assert.Contains(t, data, "MAIL FROM:<no-reply@localhost>")
+	assert.Contains(t, data, "RCPT TO:<user@user.com>")
+	assert.Contains(t, data, "Content-Type: multipart/alternative")
+}
+
+// serveSMTP answers a single session, sending what the client wrote to
+// received.
+func serveSMTP(t *testing.T, l net.Listener, received chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	var session strings.Builder
+
+	write("220 localhost ESMTP")
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		session.WriteString(line)
+
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				write("250 OK")
+			}
+			continue
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			write("250 localhost")
+		case cmd == "DATA":
+			inData = true
+			write("354 End data with <CR><LF>.<CR><LF>")
+		case cmd == "QUIT":
+			write("221 Bye")
+			received <- session.String()
+			return
+		default:
+			write("250 OK")
+		}
+	}
+	received <- session.String()
+}
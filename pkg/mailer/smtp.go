@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTP sends mail through the server at addr, as host:port. Without
+// username it does not authenticate, which is what local servers like
+// MailHog expect. net/smtp upgrades to TLS when the server offers STARTTLS.
+func NewSMTP(addr, username, password string) Mailer {
+	m := &smtpMailer{addr: addr}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *smtpMailer) Send(msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	addrs, err := msg.addresses()
+	if err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(m.addr, m.auth, addrs[0], addrs[1:], msg.Bytes()); err != nil {
+		return ErrSend.C("addr", m.addr).Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a long-lived credential a user creates for automation. Only the
+// SHA-256 Hash of the key is stored; Prefix is the start of the key, shown to
+// tell keys apart. Permissions are granted per module, e.g. "users": [r, u].
+type APIKey struct {
+	ID          string                  `json:"id"`
+	UserID      string                  `json:"user_id"`
+	Role        Role                    `json:"role"`
+	Name        string                  `json:"name"`
+	Prefix      string                  `json:"prefix"`
+	Hash        string                  `json:"hash"`
+	Permissions map[string][]Permission `json:"permissions"`
+	CreatedAt   int64                   `json:"created_at"`
+	LastUsedAt  int64                   `json:"last_used_at"`
+	ExpiresAt   int64                   `json:"expires_at"`
+}
+
+func NewAPIKey(id, userID string) *APIKey {
+	return &APIKey{
+		ID:          id,
+		UserID:      userID,
+		Permissions: make(map[string][]Permission),
+		CreatedAt:   time.Now().UnixNano(),
+	}
+}
+
+// HasPermission reports whether the key grants perm on module.
+func (k *APIKey) HasPermission(module string, perm Permission) bool {
+	for _, p := range k.Permissions[module] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Expires sets the expiration of the key ttl after its creation.
+func (k *APIKey) Expires(ttl time.Duration) {
+	k.ExpiresAt = k.CreatedAt + int64(ttl)
+}
+
+// Expired reports whether the key has an expiration and it has passed.
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != 0 && time.Now().UnixNano() >= k.ExpiresAt
+}
+
+// TTL returns the time left until expiration, or zero if the key never
+// expires.
+func (k *APIKey) TTL() time.Duration {
+	if k.ExpiresAt == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(0, k.ExpiresAt))
+}
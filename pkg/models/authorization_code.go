@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// AuthorizationCode is what a user grants a client on /authorize and the
+// client trades for tokens. CodeChallenge is the PKCE S256 challenge and
+// Nonce is copied to the ID token.
+type AuthorizationCode struct {
+	Code          string `json:"code"`
+	ClientID      string `json:"client_id"`
+	UserID        string `json:"user_id"`
+	Role          Role   `json:"role"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce"`
+	CreatedAt     int64  `json:"created_at"`
+	ExpiresAt     int64  `json:"expires_at"`
+}
+
+func NewAuthorizationCode(code string, ttl time.Duration) *AuthorizationCode {
+	now := time.Now().UnixNano()
+	return &AuthorizationCode{
+		Code:      code,
+		CreatedAt: now,
+		ExpiresAt: now + int64(ttl),
+	}
+}
+
+// Expired reports whether the code can no longer be used.
+func (c *AuthorizationCode) Expired() bool {
+	return time.Now().UnixNano() >= c.ExpiresAt
+}
+
+// TTL returns the time left until expiration.
+func (c *AuthorizationCode) TTL() time.Duration {
+	return time.Until(time.Unix(0, c.ExpiresAt))
+}
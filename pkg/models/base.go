@@ -5,11 +5,11 @@ import (
 )
 
 type Base struct {
-	ID        string    `json:"id"`
-	Enabled   bool      `json:"enabled"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	DeletedAt time.Time `json:"deleted_at""`
+	ID        string    `json:"id" bson:"_id"`
+	Enabled   bool      `json:"enabled" bson:"enabled"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at" bson:"deleted_at"`
 }
 
 func NewBase() Base {
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// Client is an application registered to use the OAuth endpoints. Secret is
+// hashed. Public clients (SPAs, mobile apps) cannot keep a secret and have
+// none.
+type Client struct {
+	ID           string    `json:"id"`
+	Secret       string    `json:"secret"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func NewClient() *Client {
+	return &Client{
+		ID:           NewID(),
+		RedirectURIs: make([]string, 0),
+		Scopes:       make([]string, 0),
+		CreatedAt:    time.Now(),
+	}
+}
+
+// HasRedirectURI reports whether uri is registered. URIs are compared as
+// strings, without any normalization.
+func (c *Client) HasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the client may request scope.
+func (c *Client) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// DeviceCode is a device authorization (RFC 8628): the device polls with
+// DeviceCode while the user approves UserCode from another browser. UserID
+// and Role are set on approval. Interval is the minimum time between polls.
+type DeviceCode struct {
+	DeviceCode   string        `json:"device_code"`
+	UserCode     string        `json:"user_code"`
+	ClientID     string        `json:"client_id"`
+	Scope        string        `json:"scope"`
+	UserID       string        `json:"user_id"`
+	Role         Role          `json:"role"`
+	Approved     bool          `json:"approved"`
+	Denied       bool          `json:"denied"`
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt int64         `json:"last_polled_at"`
+	CreatedAt    int64         `json:"created_at"`
+	ExpiresAt    int64         `json:"expires_at"`
+}
+
+func NewDeviceCode(deviceCode, userCode string, ttl, interval time.Duration) *DeviceCode {
+	now := time.Now().UnixNano()
+	return &DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		Interval:   interval,
+		CreatedAt:  now,
+		ExpiresAt:  now + int64(ttl),
+	}
+}
+
+// Pending reports whether the user has not decided yet.
+func (c *DeviceCode) Pending() bool {
+	return !c.Approved && !c.Denied
+}
+
+// Expired reports whether the code can no longer be used.
+func (c *DeviceCode) Expired() bool {
+	return time.Now().UnixNano() >= c.ExpiresAt
+}
+
+// TTL returns the time left until expiration.
+func (c *DeviceCode) TTL() time.Duration {
+	return time.Until(time.Unix(0, c.ExpiresAt))
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// MagicLink is a login link emailed to a user, valid until used once or
+// expired. Only the SHA-256 Hash of its token is stored.
+type MagicLink struct {
+	Hash      string `json:"hash"`
+	UserID    string `json:"user_id"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func NewMagicLink(hash, userID string, ttl time.Duration) *MagicLink {
+	now := time.Now().UnixNano()
+	return &MagicLink{
+		Hash:      hash,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now + int64(ttl),
+	}
+}
+
+// Expired reports whether the link can no longer be used.
+func (l *MagicLink) Expired() bool {
+	return time.Now().UnixNano() >= l.ExpiresAt
+}
+
+// TTL returns the time left until expiration.
+func (l *MagicLink) TTL() time.Duration {
+	return time.Until(time.Unix(0, l.ExpiresAt))
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordReset lets the holder of a token emailed to a user choose a new
+// password. Only the SHA-256 Hash of the token is stored. PasswordHash is a
+// fingerprint of the password when the reset was requested: once it changes,
+// the reset is no longer valid.
+type PasswordReset struct {
+	Hash         string `json:"hash"`
+	UserID       string `json:"user_id"`
+	PasswordHash string `json:"password_hash"`
+	CreatedAt    int64  `json:"created_at"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+func NewPasswordReset(hash, userID string, ttl time.Duration) *PasswordReset {
+	now := time.Now().UnixNano()
+	return &PasswordReset{
+		Hash:      hash,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now + int64(ttl),
+	}
+}
+
+// Expired reports whether the reset can no longer be used.
+func (r *PasswordReset) Expired() bool {
+	return time.Now().UnixNano() >= r.ExpiresAt
+}
+
+// TTL returns the time left until expiration.
+func (r *PasswordReset) TTL() time.Duration {
+	return time.Until(time.Unix(0, r.ExpiresAt))
+}
@@ -1,5 +1,6 @@
 package models
 
+// Permission is an operation on the resources of a module.
 type Permission string
 
 const (
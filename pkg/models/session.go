@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is the family of tokens issued from one login. Every refresh adds
+// a new access and refresh token to it; revoking it invalidates all of them.
+type Session struct {
+	ID         string   `json:"id"`
+	UserID     string   `json:"user_id"`
+	Role       Role     `json:"role"`
+	ClientID   string   `json:"client_id"`
+	Scope      string   `json:"scope"`
+	TokenIDs   []string `json:"token_ids"`
+	Revoked    bool     `json:"revoked"`
+	IP         string   `json:"ip"`
+	UserAgent  string   `json:"user_agent"`
+	CreatedAt  int64    `json:"created_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+}
+
+func NewSession(userID string) *Session {
+	now := time.Now().UnixNano()
+	return &Session{
+		ID:         NewID(),
+		UserID:     userID,
+		TokenIDs:   make([]string, 0),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+}
+
+// Expires sets the expiration of the session ttl after its creation.
+func (s *Session) Expires(ttl time.Duration) {
+	s.ExpiresAt = s.CreatedAt + int64(ttl)
+}
+
+// Expired reports whether the session has an expiration and it has passed.
+func (s *Session) Expired() bool {
+	return s.ExpiresAt != 0 && time.Now().UnixNano() >= s.ExpiresAt
+}
+
+// TTL returns the time left until expiration, or zero if the session never
+// expires.
+func (s *Session) TTL() time.Duration {
+	if s.ExpiresAt == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(0, s.ExpiresAt))
+}
@@ -4,16 +4,53 @@ import (
 	"time"
 )
 
+type TokenKind string
+
+const (
+	ACCESS  = TokenKind("access")
+	REFRESH = TokenKind("refresh")
+	// API_KEY tokens are not stored: they stand for an APIKey in a request.
+	API_KEY = TokenKind("api_key")
+)
+
 type Token struct {
-	ID        string `json:"id"`
-	UserID    string `json:"user_id"`
+	ID       string    `json:"id"`
+	UserID   string    `json:"user_id"`
+	Role     Role      `json:"role"`
+	Kind     TokenKind `json:"kind"`
+	ClientID string    `json:"client_id"`
+	Scope    string    `json:"scope"`
+	// Machine tokens identify a client, in UserID, instead of a user.
+	Machine   bool   `json:"machine"`
+	SessionID string `json:"session_id"`
 	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
 }
 
 func NewToken(userID string) *Token {
 	return &Token{
 		ID:        NewID(),
 		UserID:    userID,
+		Kind:      ACCESS,
 		CreatedAt: time.Now().UnixNano(),
 	}
 }
+
+// Expires sets the expiration of the token ttl after its creation.
+func (t *Token) Expires(ttl time.Duration) {
+	t.ExpiresAt = t.CreatedAt + int64(ttl)
+}
+
+// Expired reports whether the token has an expiration and it has passed.
+func (t *Token) Expired() bool {
+	return t.ExpiresAt != 0 && time.Now().UnixNano() >= t.ExpiresAt
+}
+
+// TTL returns the time left until expiration, or zero if the token never
+// expires.
+func (t *Token) TTL() time.Duration {
+	if t.ExpiresAt == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(0, t.ExpiresAt))
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// TOTP is the authenticator app a user enrolled as second factor. It is not
+// enforced until Confirmed with a first code. RecoveryCodes are the SHA-256
+// hashes of the codes issued, each one usable once instead of a TOTP code;
+// the ones used are recorded apart. LastCounter is the time step of the
+// code that confirmed it, which cannot be used to log in.
+type TOTP struct {
+	UserID        string   `json:"user_id"`
+	Secret        string   `json:"secret"`
+	Confirmed     bool     `json:"confirmed"`
+	RecoveryCodes []string `json:"recovery_codes"`
+	LastCounter   int64    `json:"last_counter"`
+	CreatedAt     int64    `json:"created_at"`
+	ConfirmedAt   int64    `json:"confirmed_at"`
+}
+
+func NewTOTP(userID, secret string) *TOTP {
+	return &TOTP{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
+
+// HasRecoveryCode reports whether a code with hash was issued.
+func (t *TOTP) HasRecoveryCode(hash string) bool {
+	for _, h := range t.RecoveryCodes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
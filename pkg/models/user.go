@@ -18,15 +18,21 @@ const (
 )
 
 type User struct {
-	Base
-	Username string `json:"username"validate:"required,min=4,max=32,alphanumdash"`
-	Password string `json:"password" validate:"required"`
-	Email    string `json:"email" validate:"required,min=5,max=64,email"`
-	Name     string `json:"name" validate:"required,min=2,max=32,alphaspaces"`
-	Lastname string `json:"lastname" validate:"required,min=2,max=32,alphaspaces"`
-	Role     Role   `json:"role"`
+	Base     `bson:",inline"`
+	Username string `json:"username" bson:"username" validate:"required,min=4,max=32,alphanumdash"`
+	Password string `json:"password" bson:"password" validate:"required"`
+	Email    string `json:"email" bson:"email" validate:"required,min=5,max=64,email"`
+	Name     string `json:"name" bson:"name" validate:"required,min=2,max=32,alphaspaces"`
+	Lastname string `json:"lastname" bson:"lastname" validate:"required,min=2,max=32,alphaspaces"`
+	Role     Role   `json:"role" bson:"role"`
+	// Locale is the language the user is mailed in. Empty means the
+	// configured default.
+	Locale string `json:"locale" bson:"locale" validate:"omitempty,max=16,locale"`
 
 	Validated bool `json:"validated" bson:"validated"`
+	// MFAEnabled is set while the user has a second factor. Logins fail
+	// rather than skip it when the factor cannot be found.
+	MFAEnabled bool `json:"mfa_enabled" bson:"mfa_enabled"`
 }
 
 func NewUser() *User {
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+)
+
+// WebAuthnCredential is a passkey or security key of a user. ID is the
+// credential ID in base64url and PublicKey its COSE encoded key. SignCount is
+// the last signature counter seen, which only goes up unless the
+// authenticator was cloned.
+type WebAuthnCredential struct {
+	ID         string   `json:"id" bson:"_id"`
+	UserID     string   `json:"user_id" bson:"user_id"`
+	Name       string   `json:"name" bson:"name"`
+	PublicKey  []byte   `json:"public_key" bson:"public_key"`
+	Algorithm  int      `json:"algorithm" bson:"algorithm"`
+	SignCount  uint32   `json:"sign_count" bson:"sign_count"`
+	AAGUID     []byte   `json:"aaguid" bson:"aaguid"`
+	Transports []string `json:"transports" bson:"transports"`
+	CreatedAt  int64    `json:"created_at" bson:"created_at"`
+	LastUsedAt int64    `json:"last_used_at" bson:"last_used_at"`
+}
+
+func NewWebAuthnCredential(id, userID, name string) *WebAuthnCredential {
+	return &WebAuthnCredential{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
+
+// WebAuthnChallenge is a registration or login waiting for the response of
+// an authenticator. Challenge is in base64url. UserID is empty on logins
+// without password, where the authenticator tells who the user is.
+type WebAuthnChallenge struct {
+	Challenge string `json:"challenge"`
+	UserID    string `json:"user_id"`
+	Purpose   string `json:"purpose"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func NewWebAuthnChallenge(challenge, userID, purpose string, ttl time.Duration) *WebAuthnChallenge {
+	now := time.Now().UnixNano()
+	return &WebAuthnChallenge{
+		Challenge: challenge,
+		UserID:    userID,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now + int64(ttl),
+	}
+}
+
+// Expired reports whether the challenge can no longer be answered.
+func (c *WebAuthnChallenge) Expired() bool {
+	return time.Now().UnixNano() >= c.ExpiresAt
+}
+
+// TTL returns the time left until expiration.
+func (c *WebAuthnChallenge) TTL() time.Duration {
+	return time.Until(time.Unix(0, c.ExpiresAt))
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+// Errors
+var (
+	ErrLimiterStore = errors.Internal.New("ratelimit.store")
+)
+
+// Interfaces
+type Limiter interface {
+	// Allow counts an attempt for key and reports whether it is within the
+	// limit.
+	Allow(key string) (bool, error)
+}
+
+// Implementations
+// limiter counts attempts in fixed windows stored in a cache. Each window has
+// its own counter, incremented atomically, so concurrent attempts never get
+// past the limit together.
+type limiter struct {
+	cache  cache.Cache
+	name   string
+	limit  int
+	window time.Duration
+
+	now func() time.Time
+}
+
+// New allows limit attempts per key every window. name keeps the counters of
+// different limiters sharing a cache apart.
+func New(c cache.Cache, name string, limit int, window time.Duration) Limiter {
+	return &limiter{
+		cache:  c,
+		name:   name,
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+func (l *limiter) Allow(key string) (bool, error) {
+	now := l.now().UnixNano()
+	w := now / int64(l.window)
+	k := "ratelimit:" + l.name + ":" + key + ":" + strconv.FormatInt(w, 10)
+
+	n, err := l.cache.Incr(k, time.Duration((w+1)*int64(l.window)-now))
+	if err != nil {
+		return false, ErrLimiterStore.Wrap(err)
+	}
+
+	return n <= int64(l.limit), nil
+}
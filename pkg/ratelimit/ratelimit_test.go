@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aboglioli/big-brother/mocks"
+	"github.com/aboglioli/big-brother/pkg/cache"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAllow(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	l := New(cache.NewInMemory("test"), "login", 2, time.Minute).(*limiter)
+	l.now = func() time.Time { return now }
+
+	allow := func(key string) bool {
+		ok, err := l.Allow(key)
+		assert.Nil(err)
+		return ok
+	}
+
+	assert.True(allow("a"))
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+	assert.False(allow("a"))
+
+	// Keys and limiters are independent
+	assert.True(allow("b"))
+	other := New(l.cache, "reset", 1, time.Minute)
+	ok, err := other.Allow("a")
+	assert.Nil(err)
+	assert.True(ok)
+
+	// New window
+	now = now.Add(time.Minute)
+	assert.True(allow("a"))
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	l := New(cache.NewInMemory("test"), "login", 5, time.Minute)
+
+	const n = 50
+	allowed := make(chan bool, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := l.Allow("a")
+			assert.Nil(t, err)
+			allowed <- ok
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	count := 0
+	for ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	assert.Equal(t, 5, count)
+}
+
+func TestAllowStoreError(t *testing.T) {
+	c := mocks.NewMockCache()
+	c.On("Incr", mock.Anything).Return(int64(0), cache.ErrCacheSet)
+
+	l := New(c, "login", 1, time.Minute).(*limiter)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	ok, err := l.Allow("a")
+	assert.False(t, ok)
+	errors.Assert(t, ErrLimiterStore, err)
+	c.AssertExpectations(t)
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/aboglioli/big-brother/pkg/config"
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+// Error aborts the request with an RFC 7807 problem built from err. Internal
+// errors and causes are only exposed when config.Debug is set; they are
+// attached to the context to be logged instead.
+func Error(c *gin.Context, err error) {
+	c.Error(err)
+
+	problem := errors.NewProblem(err, &errors.ProblemOptions{
+		Debug: config.Get().Debug,
+	})
+
+	c.Header("Content-Type", errors.ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		typ    string
+	}{{
+		"internal error",
+		errors.Internal.New("db.connect").C("password", "secret").Wrap(goerrors.New("connection refused")),
+		500,
+		"about:blank",
+	}, {
+		"status error hides internal cause",
+		errors.Status.New("user.register").S(500).Wrap(
+			errors.Internal.New("db.insert").Wrap(goerrors.New("duplicated key")),
+		),
+		500,
+		"user.register",
+	}, {
+		"validation error",
+		errors.Validation.New("user.invalid_schema").F("email", "invalid"),
+		400,
+		"user.invalid_schema",
+	}, {
+		"aggregate",
+		errors.Errors{
+			errors.Validation.New("user.invalid_password"),
+			errors.Validation.New("user.invalid_schema"),
+		},
+		400,
+		"about:blank",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			res := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(res)
+
+			Error(c, test.err)
+
+			assert.True(c.IsAborted())
+			assert.Equal(test.status, res.Code)
+			assert.Equal(errors.ProblemContentType, res.Header().Get("Content-Type"))
+			assert.NotContains(res.Body.String(), "secret")
+			assert.NotContains(res.Body.String(), "refused")
+			assert.NotContains(res.Body.String(), "duplicated")
+
+			problem := &errors.Problem{}
+			require.Nil(t, json.Unmarshal(res.Body.Bytes(), problem))
+			assert.Equal(test.status, problem.Status)
+			assert.Equal(test.typ, problem.Type)
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// New returns a gin engine with the middlewares shared by every service.
+func New() *gin.Engine {
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AddAllowHeaders("Authorization")
+
+	r := gin.New()
+	r.Use(gin.Logger(), gin.Recovery(), cors.New(corsConfig))
+	return r
+}
@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(rfcSecret, Counter(time.Unix(test.time, 0)))
+		require.Nil(t, err)
+		assert.Equal(t, test.code, code, test.time)
+	}
+
+	_, err := Code("not base32!", 1)
+	assert.NotNil(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	counter, ok := Validate(rfcSecret, "050471", now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	// Previous step within skew
+	counter, ok = Validate(rfcSecret, "050471", now.Add(Period), 1)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	_, ok = Validate(rfcSecret, "050471", now.Add(2*Period), 1)
+	assert.False(t, ok)
+	_, ok = Validate(rfcSecret, "123456", now, 1)
+	assert.False(t, ok)
+	_, ok = Validate(rfcSecret, "05047", now, 1)
+	assert.False(t, ok)
+	_, ok = Validate(rfcSecret, "050 471", now, 1)
+	assert.True(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.Nil(t, err)
+	assert.Len(t, secret, 32)
+
+	other, err := GenerateSecret()
+	require.Nil(t, err)
+	assert.NotEqual(t, secret, other)
+
+	_, err = Code(secret, 1)
+	assert.Nil(t, err)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Big Brother", "user@example.com", "SECRET"))
+	require.Nil(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Big Brother:user@example.com", u.Path)
+	assert.Equal(t, "SECRET", u.Query().Get("secret"))
+	assert.Equal(t, "Big Brother", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
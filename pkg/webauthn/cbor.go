@@ -0,0 +1,150 @@
+package webauthn
+
+import (
+	"encoding/binary"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+// Errors
+var (
+	ErrCBOR = errors.Internal.New("webauthn.cbor")
+)
+
+// maxDepth bounds nesting, which authenticators never need much of.
+const maxDepth = 16
+
+// decodeCBOR decodes the first CBOR item of b, returning the bytes after it.
+// It supports the subset WebAuthn uses: definite lengths, integers, byte and
+// text strings, arrays, maps and simple values. Integers are int64, maps are
+// map[interface{}]interface{}.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, ErrCBOR.M("too deep")
+	}
+	if len(b) == 0 {
+		return nil, nil, ErrCBOR.M("unexpected end")
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	// Simple values
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b[1:], nil
+		case 21:
+			return true, b[1:], nil
+		case 22, 23:
+			return nil, b[1:], nil
+		}
+		return nil, nil, ErrCBOR.M("unsupported simple value %d", info)
+	}
+
+	n, b, err := decodeArgument(info, b[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if n > 1<<63-1 {
+			return nil, nil, ErrCBOR.M("integer overflow")
+		}
+		return int64(n), b, nil
+	case 1:
+		if n > 1<<63-1 {
+			return nil, nil, ErrCBOR.M("integer overflow")
+		}
+		return -1 - int64(n), b, nil
+	case 2, 3:
+		if n > uint64(len(b)) {
+			return nil, nil, ErrCBOR.M("unexpected end")
+		}
+		if major == 2 {
+			v := make([]byte, n)
+			copy(v, b[:n])
+			return v, b[n:], nil
+		}
+		return string(b[:n]), b[n:], nil
+	case 4:
+		if n > uint64(len(b)) {
+			return nil, nil, ErrCBOR.M("unexpected end")
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			item, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, b, nil
+	case 5:
+		if n > uint64(len(b)) {
+			return nil, nil, ErrCBOR.M("unexpected end")
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			k, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrCBOR.M("unsupported map key")
+			}
+			v, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	}
+
+	return nil, nil, ErrCBOR.M("unsupported major type %d", major)
+}
+
+// decodeArgument reads the length or value that follows the initial byte.
+func decodeArgument(info byte, b []byte) (uint64, []byte, error) {
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, ErrCBOR.M("indefinite lengths are not supported")
+	}
+
+	if len(b) < size {
+		return 0, nil, ErrCBOR.M("unexpected end")
+	}
+	var n uint64
+	switch size {
+	case 1:
+		n = uint64(b[0])
+	case 2:
+		n = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		n = uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		n = binary.BigEndian.Uint64(b)
+	}
+	return n, b[size:], nil
+}
@@ -0,0 +1,64 @@
+package webauthn
+
+import (
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// RFC 8949 appendix A
+	tests := []struct {
+		in  []byte
+		out interface{}
+	}{
+		{[]byte{0x00}, int64(0)},
+		{[]byte{0x17}, int64(23)},
+		{[]byte{0x18, 0x64}, int64(100)},
+		{[]byte{0x19, 0x03, 0xe8}, int64(1000)},
+		{[]byte{0x20}, int64(-1)},
+		{[]byte{0x38, 0x63}, int64(-100)},
+		{[]byte{0xf4}, false},
+		{[]byte{0xf5}, true},
+		{[]byte{0xf6}, nil},
+		{[]byte{0x44, 0x01, 0x02, 0x03, 0x04}, []byte{1, 2, 3, 4}},
+		{[]byte{0x64, 0x49, 0x45, 0x54, 0x46}, "IETF"},
+		{[]byte{0x83, 0x01, 0x02, 0x03}, []interface{}{int64(1), int64(2), int64(3)}},
+		{
+			[]byte{0xa2, 0x61, 0x61, 0x01, 0x20, 0x82, 0x02, 0x03},
+			map[interface{}]interface{}{"a": int64(1), int64(-1): []interface{}{int64(2), int64(3)}},
+		},
+	}
+
+	for _, test := range tests {
+		v, rest, err := decodeCBOR(append(test.in, 0xff))
+		assert.Nil(t, err, test.in)
+		assert.Equal(t, test.out, v, test.in)
+		assert.Equal(t, []byte{0xff}, rest, test.in)
+	}
+}
+
+func TestDecodeInvalidCBOR(t *testing.T) {
+	deep := make([]byte, maxDepth+2)
+	for i := range deep {
+		deep[i] = 0x81
+	}
+
+	tests := [][]byte{
+		{},
+		{0x19, 0x03},                   // Short integer
+		{0x44, 0x01},                   // Short byte string
+		{0x9a, 0xff, 0xff, 0xff, 0xff}, // Array longer than the input
+		{0x5f, 0x41, 0x01, 0xff},       // Indefinite length
+		{0xa1, 0x41, 0x01, 0x01},       // Byte string key
+		{0xfb, 0, 0, 0, 0, 0, 0, 0, 0}, // Float
+		{0xc0, 0x00},                   // Tag
+		deep,
+	}
+
+	for _, in := range tests {
+		_, _, err := decodeCBOR(in)
+		errors.Assert(t, ErrCBOR, err)
+	}
+}
@@ -0,0 +1,145 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+// COSE algorithms supported, as registered by IANA.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms are the ones offered on registration, in order of preference.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key labels and values.
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// Errors
+var (
+	ErrPublicKey = errors.Validation.New("webauthn.public_key")
+	ErrSignature = errors.Validation.New("webauthn.signature")
+)
+
+// PublicKey is a credential public key decoded from its COSE encoding.
+type PublicKey struct {
+	Algorithm int
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key, as stored with a credential.
+func ParsePublicKey(b []byte) (*PublicKey, error) {
+	key, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, ErrPublicKey.Wrap(err)
+	}
+	if len(rest) > 0 {
+		return nil, ErrPublicKey.M("trailing data")
+	}
+	return publicKeyFromCOSE(key)
+}
+
+func publicKeyFromCOSE(v interface{}) (*PublicKey, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrPublicKey.M("not a map")
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrPublicKey.M("invalid EC2 key")
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrPublicKey.M("point not on curve")
+		}
+		return &PublicKey{Algorithm: AlgES256, Key: key}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrPublicKey.M("invalid OKP key")
+		}
+		return &PublicKey{Algorithm: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrPublicKey.M("invalid RSA key")
+		}
+		return &PublicKey{Algorithm: AlgRS256, Key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+
+	return nil, ErrPublicKey.M("unsupported key type %d and algorithm %d", kty, alg)
+}
+
+// Verify checks sig over data, with the signature format of the algorithm.
+// A key of another type than the algorithm never verifies.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	switch k.Algorithm {
+	case AlgES256:
+		var esig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &esig); err != nil || len(rest) > 0 {
+			return ErrSignature.M("malformed signature")
+		}
+		key, ok := k.Key.(*ecdsa.PublicKey)
+		hash := sha256.Sum256(data)
+		if ok && ecdsa.Verify(key, hash[:], esig.R, esig.S) {
+			return nil
+		}
+	case AlgEdDSA:
+		key, ok := k.Key.(ed25519.PublicKey)
+		if ok && ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	case AlgRS256:
+		key, ok := k.Key.(*rsa.PublicKey)
+		hash := sha256.Sum256(data)
+		if ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+			return nil
+		}
+	}
+	return ErrSignature
+}
@@ -0,0 +1,499 @@
+// Package webauthn implements the relying party side of Web Authentication:
+// the options browsers take to create and get credentials, and the
+// verification of what authenticators return. Attestation formats "none"
+// and "packed" are verified; attestation certificates are not checked
+// against trust anchors, so the authenticator model is not trusted.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+)
+
+const (
+	TypePublicKey = "public-key"
+
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+
+	// Authenticator data flags.
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionData          = 0x80
+
+	challengeSize = 32
+	// minAuthDataSize is the RP ID hash, the flags and the sign count.
+	minAuthDataSize = 37
+)
+
+// Errors
+var (
+	ErrInvalidResponse = errors.Validation.New("webauthn.invalid_response")
+	ErrAttestation     = errors.Validation.New("webauthn.attestation")
+	ErrCloned          = errors.Validation.New("webauthn.cloned_authenticator")
+)
+
+// Interfaces
+// RelyingParty is this service as authenticators see it. ID is the domain
+// credentials are scoped to and Origins the pages allowed to use them.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	Timeout time.Duration
+}
+
+func New(id, name string, origins ...string) *RelyingParty {
+	return &RelyingParty{
+		ID:      id,
+		Name:    name,
+		Origins: origins,
+		Timeout: 5 * time.Minute,
+	}
+}
+
+// URLBytes is binary data, which WebAuthn clients encode in base64url.
+type URLBytes []byte
+
+func (b URLBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := DecodeURLBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// DecodeURLBytes decodes base64url, with or without padding.
+func DecodeURLBytes(s string) (URLBytes, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (b URLBytes) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewChallenge returns the random bytes a ceremony signs.
+func NewChallenge() (URLBytes, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Options
+type Entity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// User is the account a credential is created for. ID is opaque and
+// returned as the user handle of discoverable credentials.
+type User struct {
+	ID          URLBytes `json:"id"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         URLBytes `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions are the publicKey options of navigator.credentials.create.
+type CreationOptions struct {
+	RP                     Entity                 `json:"rp"`
+	User                   User                   `json:"user"`
+	Challenge              URLBytes               `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation,omitempty"`
+}
+
+// RequestOptions are the publicKey options of navigator.credentials.get.
+// With no AllowCredentials the authenticator picks a discoverable one.
+type RequestOptions struct {
+	Challenge        URLBytes               `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// CreationOptions asks for a discoverable credential when possible, so it
+// also works for logins without password, and for no attestation.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude []CredentialDescriptor) *CreationOptions {
+	params := make([]CredentialParameter, 0, len(Algorithms))
+	for _, alg := range Algorithms {
+		params = append(params, CredentialParameter{Type: TypePublicKey, Alg: alg})
+	}
+
+	return &CreationOptions{
+		RP:                 Entity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            rp.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: UserVerificationPreferred,
+		},
+		Attestation: "none",
+	}
+}
+
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor, userVerification string) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// Responses
+// AttestationResponse is the PublicKeyCredential created by the browser.
+type AttestationResponse struct {
+	ID       string   `json:"id"`
+	RawID    URLBytes `json:"rawId"`
+	Type     string   `json:"type"`
+	Response struct {
+		ClientDataJSON    URLBytes `json:"clientDataJSON"`
+		AttestationObject URLBytes `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the PublicKeyCredential got from the browser.
+type AssertionResponse struct {
+	ID       string   `json:"id"`
+	RawID    URLBytes `json:"rawId"`
+	Type     string   `json:"type"`
+	Response struct {
+		ClientDataJSON    URLBytes `json:"clientDataJSON"`
+		AuthenticatorData URLBytes `json:"authenticatorData"`
+		Signature         URLBytes `json:"signature"`
+		UserHandle        URLBytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// CredentialID returns the raw ID, falling back on the encoded one.
+func (r *AssertionResponse) CredentialID() []byte {
+	if len(r.RawID) > 0 {
+		return r.RawID
+	}
+	id, _ := DecodeURLBytes(r.ID)
+	return id
+}
+
+// ClientData is the collected client data the browser signs along.
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ParseClientData decodes clientDataJSON, which callers need to find the
+// challenge a response is for.
+func ParseClientData(b []byte) (*ClientData, error) {
+	c := &ClientData{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidResponse.M("invalid client data").Wrap(err)
+	}
+	return c, nil
+}
+
+// ChallengeBytes decodes the challenge the client data is for.
+func (c *ClientData) ChallengeBytes() ([]byte, error) {
+	b, err := DecodeURLBytes(c.Challenge)
+	if err != nil {
+		return nil, ErrInvalidResponse.M("invalid challenge").Wrap(err)
+	}
+	return b, nil
+}
+
+// AuthenticatorData is the data authenticators sign. The attested
+// credential data is only there on registration.
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	// PublicKey is the COSE_Key of the credential as encoded.
+	PublicKey []byte
+}
+
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < minAuthDataSize {
+		return nil, ErrInvalidResponse.M("authenticator data too short")
+	}
+
+	data := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[minAuthDataSize:]
+
+	if data.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, ErrInvalidResponse.M("attested credential data too short")
+		}
+		data.AAGUID = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, ErrInvalidResponse.M("credential ID too short")
+		}
+		data.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidResponse.M("invalid credential public key").Wrap(err)
+		}
+		data.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if data.Flags&FlagExtensionData != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidResponse.M("invalid extensions").Wrap(err)
+		}
+		rest = after
+	}
+
+	if len(rest) > 0 {
+		return nil, ErrInvalidResponse.M("trailing authenticator data")
+	}
+	return data, nil
+}
+
+// Credential is what is kept of a registered credential.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte
+	Algorithm    int
+	SignCount    uint32
+	AAGUID       []byte
+	Transports   []string
+	UserVerified bool
+}
+
+// VerifyRegistration checks the response to the creation options with
+// challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, res *AttestationResponse, requireUV bool) (*Credential, error) {
+	if res == nil || res.Type != TypePublicKey {
+		return nil, ErrInvalidResponse.M("invalid credential type")
+	}
+	if err := rp.verifyClientData(res.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	obj, rest, err := decodeCBOR(res.Response.AttestationObject)
+	if err != nil || len(rest) > 0 {
+		return nil, ErrInvalidResponse.M("invalid attestation object").Wrap(err)
+	}
+	m, _ := obj.(map[interface{}]interface{})
+	format, _ := m["fmt"].(string)
+	stmt, _ := m["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := m["authData"].([]byte)
+	if stmt == nil || rawAuthData == nil {
+		return nil, ErrInvalidResponse.M("invalid attestation object")
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	if authData.Flags&FlagAttestedCredentialData == 0 {
+		return nil, ErrInvalidResponse.M("no attested credential data")
+	}
+	if len(res.RawID) > 0 && !bytes.Equal(res.RawID, authData.CredentialID) {
+		return nil, ErrInvalidResponse.M("credential ID mismatch")
+	}
+
+	key, err := ParsePublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(res.Response.ClientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := verifyAttestation(format, stmt, key, signed); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:           authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		Algorithm:    key.Algorithm,
+		SignCount:    authData.SignCount,
+		AAGUID:       authData.AAGUID,
+		Transports:   res.Response.Transports,
+		UserVerified: authData.Flags&FlagUserVerified != 0,
+	}, nil
+}
+
+// VerifyLogin checks the response to the request options with challenge,
+// signed by cred, and returns the new sign count of the authenticator.
+func (rp *RelyingParty) VerifyLogin(challenge []byte, cred *Credential, res *AssertionResponse, requireUV bool) (uint32, error) {
+	if res == nil || res.Type != TypePublicKey {
+		return 0, ErrInvalidResponse.M("invalid credential type")
+	}
+	if !bytes.Equal(res.CredentialID(), cred.ID) {
+		return 0, ErrInvalidResponse.M("credential ID mismatch")
+	}
+	if err := rp.verifyClientData(res.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := ParseAuthenticatorData(res.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(res.Response.ClientDataJSON)
+	signed := append(append([]byte{}, res.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.Verify(signed, res.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that count signatures never go back, unless cloned.
+	if (authData.SignCount != 0 || cred.SignCount != 0) && authData.SignCount <= cred.SignCount {
+		return 0, ErrCloned
+	}
+
+	return authData.SignCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	c, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if c.Type != typ {
+		return ErrInvalidResponse.M("invalid client data type")
+	}
+
+	got, err := c.ChallengeBytes()
+	if err != nil {
+		return err
+	}
+	if len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrInvalidResponse.M("challenge mismatch")
+	}
+
+	for _, origin := range rp.Origins {
+		if c.Origin == origin {
+			return nil
+		}
+	}
+	return ErrInvalidResponse.M("origin %s not allowed", c.Origin)
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(data *AuthenticatorData, requireUV bool) error {
+	hash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data.RPIDHash, hash[:]) != 1 {
+		return ErrInvalidResponse.M("RP ID mismatch")
+	}
+	if data.Flags&FlagUserPresent == 0 {
+		return ErrInvalidResponse.M("user not present")
+	}
+	if requireUV && data.Flags&FlagUserVerified == 0 {
+		return ErrInvalidResponse.M("user not verified")
+	}
+	return nil
+}
+
+// verifyAttestation checks the attestation statement of format over signed,
+// the authenticator data followed by the client data hash.
+func verifyAttestation(format string, stmt map[interface{}]interface{}, key *PublicKey, signed []byte) error {
+	switch format {
+	case "none":
+		if len(stmt) > 0 {
+			return ErrAttestation.M("statement not empty")
+		}
+		return nil
+	case "packed":
+		alg, _ := stmt["alg"].(int64)
+		sig, _ := stmt["sig"].([]byte)
+		if sig == nil {
+			return ErrAttestation.M("no signature")
+		}
+
+		x5c, hasX5C := stmt["x5c"].([]interface{})
+		if !hasX5C {
+			// Self attestation, signed with the credential key.
+			if int(alg) != key.Algorithm {
+				return ErrAttestation.M("algorithm mismatch")
+			}
+			if err := key.Verify(signed, sig); err != nil {
+				return ErrAttestation.Wrap(err)
+			}
+			return nil
+		}
+
+		if len(x5c) == 0 {
+			return ErrAttestation.M("empty certificate chain")
+		}
+		der, _ := x5c[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ErrAttestation.M("invalid certificate").Wrap(err)
+		}
+		attKey := &PublicKey{Algorithm: int(alg), Key: cert.PublicKey}
+		if err := attKey.Verify(signed, sig); err != nil {
+			return ErrAttestation.Wrap(err)
+		}
+		return nil
+	}
+	return ErrAttestation.M("unsupported format %s", format)
+}
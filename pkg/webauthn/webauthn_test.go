@@ -0,0 +1,160 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aboglioli/big-brother/pkg/errors"
+	"github.com/aboglioli/big-brother/pkg/webauthn"
+	"github.com/aboglioli/big-brother/pkg/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const origin = "https://example.com"
+
+func register(t *testing.T, rp *webauthn.RelyingParty, a *webauthntest.Authenticator) (*webauthn.Credential, []byte, *webauthn.AttestationResponse) {
+	challenge, err := webauthn.NewChallenge()
+	require.Nil(t, err)
+	opts := rp.CreationOptions(challenge, webauthn.User{ID: []byte("user-id"), Name: "user"}, nil)
+	res, err := a.Create(opts)
+	require.Nil(t, err)
+
+	cred, err := rp.VerifyRegistration(challenge, res, false)
+	require.Nil(t, err)
+	return cred, challenge, res
+}
+
+func TestRegistration(t *testing.T) {
+	rp := webauthn.New("example.com", "Example", origin)
+
+	for _, format := range []string{webauthntest.FormatNone, webauthntest.FormatPacked} {
+		a := webauthntest.New(origin)
+		a.Format = format
+
+		cred, _, res := register(t, rp, a)
+		assert.Equal(t, []byte(res.RawID), cred.ID, format)
+		assert.Equal(t, webauthn.AlgES256, cred.Algorithm, format)
+		assert.True(t, cred.UserVerified, format)
+		assert.Equal(t, []string{"internal"}, cred.Transports, format)
+
+		key, err := webauthn.ParsePublicKey(cred.PublicKey)
+		require.Nil(t, err)
+		assert.Equal(t, webauthn.AlgES256, key.Algorithm)
+	}
+
+	// Survives JSON, as sent by browsers
+	a := webauthntest.New(origin)
+	challenge, _ := webauthn.NewChallenge()
+	res, err := a.Create(rp.CreationOptions(challenge, webauthn.User{ID: []byte("id")}, nil))
+	require.Nil(t, err)
+	b, err := json.Marshal(res)
+	require.Nil(t, err)
+	decoded := &webauthn.AttestationResponse{}
+	require.Nil(t, json.Unmarshal(b, decoded))
+	_, err = rp.VerifyRegistration(challenge, decoded, true)
+	assert.Nil(t, err)
+}
+
+func TestInvalidRegistration(t *testing.T) {
+	rp := webauthn.New("example.com", "Example", origin)
+	challenge, err := webauthn.NewChallenge()
+	require.Nil(t, err)
+	other, err := webauthn.NewChallenge()
+	require.Nil(t, err)
+
+	tests := []struct {
+		name      string
+		rp        *webauthn.RelyingParty
+		origin    string
+		uv        bool
+		requireUV bool
+		challenge []byte
+		tamper    func(res *webauthn.AttestationResponse)
+		err       error
+	}{
+		{"other challenge", rp, origin, true, false, other, nil, webauthn.ErrInvalidResponse},
+		{"other origin", rp, "https://evil.com", true, false, challenge, nil, webauthn.ErrInvalidResponse},
+		{"other RP", webauthn.New("evil.com", "Evil", origin), origin, true, false, challenge, nil, webauthn.ErrInvalidResponse},
+		{"not verified", rp, origin, false, true, challenge, nil, webauthn.ErrInvalidResponse},
+		{"wrong type", rp, origin, true, false, challenge, func(res *webauthn.AttestationResponse) {
+			res.Type = "password"
+		}, webauthn.ErrInvalidResponse},
+		{"other ID", rp, origin, true, false, challenge, func(res *webauthn.AttestationResponse) {
+			res.RawID = []byte("other")
+		}, webauthn.ErrInvalidResponse},
+		{"truncated object", rp, origin, true, false, challenge, func(res *webauthn.AttestationResponse) {
+			res.Response.AttestationObject = res.Response.AttestationObject[:40]
+		}, webauthn.ErrInvalidResponse},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := webauthntest.New(test.origin)
+			a.UserVerified = test.uv
+			res, err := a.Create(test.rp.CreationOptions(challenge, webauthn.User{ID: []byte("id")}, nil))
+			require.Nil(t, err)
+			if test.tamper != nil {
+				test.tamper(res)
+			}
+
+			cred, err := rp.VerifyRegistration(test.challenge, res, test.requireUV)
+			assert.Nil(t, cred)
+			errors.Assert(t, test.err, err)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	rp := webauthn.New("example.com", "Example", origin)
+	a := webauthntest.New(origin)
+	cred, _, _ := register(t, rp, a)
+
+	login := func(requireUV bool, allow ...webauthn.CredentialDescriptor) (*webauthn.AssertionResponse, []byte) {
+		challenge, err := webauthn.NewChallenge()
+		require.Nil(t, err)
+		res, err := a.Get(rp.RequestOptions(challenge, allow, webauthn.UserVerificationRequired))
+		require.Nil(t, err)
+		return res, challenge
+	}
+
+	// Discoverable, with the user handle
+	res, challenge := login(true)
+	assert.Equal(t, []byte("user-id"), []byte(res.Response.UserHandle))
+	count, err := rp.VerifyLogin(challenge, cred, res, true)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(1), count)
+	cred.SignCount = count
+
+	// Replayed
+	_, err = rp.VerifyLogin(challenge, cred, res, true)
+	errors.Assert(t, webauthn.ErrCloned, err)
+
+	res, challenge = login(false, webauthn.CredentialDescriptor{Type: webauthn.TypePublicKey, ID: cred.ID})
+	_, err = rp.VerifyLogin([]byte("other"), cred, res, false)
+	errors.Assert(t, webauthn.ErrInvalidResponse, err)
+
+	res.Response.Signature[len(res.Response.Signature)-1] ^= 1
+	_, err = rp.VerifyLogin(challenge, cred, res, false)
+	errors.Assert(t, webauthn.ErrSignature, err)
+
+	// Another credential
+	other, _, _ := register(t, rp, webauthntest.New(origin))
+	res, challenge = login(false)
+	_, err = rp.VerifyLogin(challenge, other, res, false)
+	errors.Assert(t, webauthn.ErrInvalidResponse, err)
+
+	// Authenticators that do not count
+	a.Counter = false
+	a.UserVerified = false
+	noCount, _, _ := register(t, rp, a)
+	allow := webauthn.CredentialDescriptor{Type: webauthn.TypePublicKey, ID: noCount.ID}
+	res, challenge = login(false, allow)
+	count, err = rp.VerifyLogin(challenge, noCount, res, false)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(0), count)
+
+	res, challenge = login(false, allow)
+	_, err = rp.VerifyLogin(challenge, noCount, res, true)
+	errors.Assert(t, webauthn.ErrInvalidResponse, err)
+}
@@ -0,0 +1,229 @@
+// Package webauthntest provides a software authenticator, so ceremonies can
+// be tested without hardware.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/aboglioli/big-brother/pkg/webauthn"
+)
+
+// Attestation formats the authenticator can produce.
+const (
+	FormatNone   = "none"
+	FormatPacked = "packed"
+)
+
+// Authenticator holds ES256 credentials in memory, all of them discoverable.
+// Origin is what the browser would report the page to be.
+type Authenticator struct {
+	Origin string
+	// Format is the attestation format, "none" by default; "packed" is self
+	// attestation.
+	Format string
+	// UserVerified sets the UV flag, as after a PIN or biometric.
+	UserVerified bool
+	// Counter makes it count signatures, which authenticators may not do.
+	Counter bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{
+		Origin:       origin,
+		Format:       FormatNone,
+		UserVerified: true,
+		Counter:      true,
+	}
+}
+
+// Create makes a credential for opts, as navigator.credentials.create.
+func (a *Authenticator) Create(opts *webauthn.CreationOptions) (*webauthn.AttestationResponse, error) {
+	supported := false
+	for _, p := range opts.PubKeyCredParams {
+		if p.Alg == webauthn.AlgES256 {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, errors.New("webauthntest: ES256 not allowed")
+	}
+	for _, d := range opts.ExcludeCredentials {
+		if a.find(opts.RP.ID, d.ID) != nil {
+			return nil, errors.New("webauthntest: credential excluded")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	cred := &credential{
+		id:         id,
+		rpID:       opts.RP.ID,
+		userHandle: opts.User.ID,
+		key:        key,
+	}
+
+	clientData, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authData(cred, webauthn.FlagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	var idLen [2]byte
+	binary.BigEndian.PutUint16(idLen[:], uint16(len(id)))
+	authData = append(authData, idLen[:]...)
+	authData = append(authData, id...)
+	authData = append(authData, coseKey(&key.PublicKey)...)
+
+	stmt := map[interface{}]interface{}{}
+	if a.Format == FormatPacked {
+		sig, err := sign(key, authData, clientData)
+		if err != nil {
+			return nil, err
+		}
+		stmt["alg"] = int64(webauthn.AlgES256)
+		stmt["sig"] = sig
+	}
+	obj := encode(map[interface{}]interface{}{
+		"fmt":      a.Format,
+		"attStmt":  stmt,
+		"authData": authData,
+	})
+
+	a.credentials = append(a.credentials, cred)
+
+	res := &webauthn.AttestationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  webauthn.TypePublicKey,
+	}
+	res.Response.ClientDataJSON = clientData
+	res.Response.AttestationObject = obj
+	res.Response.Transports = []string{"internal"}
+	return res, nil
+}
+
+// Get signs the challenge of opts with an allowed credential, or with any
+// of the relying party when none is listed, as navigator.credentials.get.
+func (a *Authenticator) Get(opts *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	var cred *credential
+	if len(opts.AllowCredentials) == 0 {
+		for _, c := range a.credentials {
+			if c.rpID == opts.RPID {
+				cred = c
+				break
+			}
+		}
+	}
+	for _, d := range opts.AllowCredentials {
+		if cred = a.find(opts.RPID, d.ID); cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return nil, errors.New("webauthntest: no credential")
+	}
+
+	clientData, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if a.Counter {
+		cred.signCount++
+	}
+	authData := a.authData(cred, 0)
+	sig, err := sign(cred.key, authData, clientData)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(cred.id),
+		RawID: cred.id,
+		Type:  webauthn.TypePublicKey,
+	}
+	res.Response.ClientDataJSON = clientData
+	res.Response.AuthenticatorData = authData
+	res.Response.Signature = sig
+	res.Response.UserHandle = cred.userHandle
+	return res, nil
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && bytes.Equal(c.id, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.Origin,
+	})
+}
+
+func (a *Authenticator) authData(cred *credential, flags byte) []byte {
+	flags |= webauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+	hash := sha256.Sum256([]byte(cred.rpID))
+	data := append(hash[:], flags)
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], cred.signCount)
+	return append(data, count[:]...)
+}
+
+func sign(key *ecdsa.PrivateKey, authData, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	hash := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+}
+
+func coseKey(key *ecdsa.PublicKey) []byte {
+	x := key.X.Bytes()
+	y := key.Y.Bytes()
+	x = append(make([]byte, 32-len(x)), x...)
+	y = append(make([]byte, 32-len(y)), y...)
+	return encode(map[interface{}]interface{}{
+		int64(1):  int64(2), // kty: EC2
+		int64(3):  int64(webauthn.AlgES256),
+		int64(-1): int64(1), // crv: P-256
+		int64(-2): x,
+		int64(-3): y,
+	})
+}
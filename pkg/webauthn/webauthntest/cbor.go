@@ -0,0 +1,66 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// encode writes v in CBOR. It takes the types the authenticator needs:
+// int64, string, []byte and maps keyed by int64 or string.
+func encode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		// Sorted, so the encoding is stable.
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string][]byte, len(v))
+		for k, item := range v {
+			ek := encode(k)
+			keys = append(keys, ek)
+			values[string(ek)] = encode(item)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return string(keys[i]) < string(keys[j])
+		})
+
+		b := header(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, k...)
+			b = append(b, values[string(k)]...)
+		}
+		return b
+	}
+	panic("webauthntest: unsupported CBOR type")
+}
+
+func header(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n <= 0xff:
+		return []byte{major | 24, byte(n)}
+	case n <= 0xffff:
+		b := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	case n <= 0xffffffff:
+		b := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+	b := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[1:], n)
+	return b
+}